@@ -1,30 +1,48 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
+	// Schedules name IANA time zones, which the container image doesn't ship
+	_ "time/tzdata"
 
 	// Logging package
 	log "github.com/sirupsen/logrus"
 
 	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/leader"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/metrics"
+	"github.com/taylorsmcclure/kube-server/internal/middleware"
 	k8sredis "github.com/taylorsmcclure/kube-server/internal/redis"
+	"github.com/taylorsmcclure/kube-server/internal/state"
 
 	// internal http handlers
 	"github.com/taylorsmcclure/kube-server/internal/deployments"
 	"github.com/taylorsmcclure/kube-server/internal/healthcheck"
 	"github.com/taylorsmcclure/kube-server/internal/replicas"
+	"github.com/taylorsmcclure/kube-server/internal/statefulsets"
 
 	// k8s client packages
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/scale"
 	"k8s.io/client-go/tools/clientcmd"
 
 	// Gorilla Mux for routing
@@ -45,8 +63,9 @@ func main() {
 	}
 
 	// Command line arguments
-	var port, kubeconfig, rAddr, ca, cert, key, rClientCert, rCACert, rClientKey string
-	var local, verbose, version bool
+	var port, kubeconfig, rAddr, ca, cert, key, rClientCert, rCACert, rClientKey, reconcileMode, storeBackend, stateFile, authzPolicy, limitsPolicy, metricsPort, leaderNamespace string
+	var local, verbose, version, authzRBAC, leaderElect bool
+	var reconcileInterval, scheduleInterval, shutdownTimeout, drainDelay time.Duration
 	flag.StringVar(&port, "port", "8080", "server port")
 	flag.StringVar(&kubeconfig, "kubeconfig", filepath.Join(homedir, ".kube", "config"), "path to the kubeconfig file")
 	flag.StringVar(&rAddr, "raddr", "localhost:6379", "Address of the Redis server, like: localhost:6379")
@@ -56,9 +75,22 @@ func main() {
 	flag.StringVar(&rCACert, "rca", "", "path to ca cert for Redis")
 	flag.StringVar(&rClientCert, "rcert", "", "path to cert for Redis")
 	flag.StringVar(&rClientKey, "rkey", "", "path to key for Redis")
+	flag.StringVar(&storeBackend, "store", state.BackendRedis, "state store backend: redis, memory, file, annotations or configmap")
+	flag.StringVar(&stateFile, "state-file", "kube-server.db", "path to the state file when using the file state store")
+	flag.StringVar(&authzPolicy, "authz-policy", "", "path to the authorization policy file, every client with a valid certificate has full access without one")
+	flag.StringVar(&limitsPolicy, "limits", "", "path to the replica limits file, only the kube-server/min-replicas, max-replicas and max-step annotations apply without one")
+	flag.BoolVar(&authzRBAC, "authz-rbac", false, "authorize clients with a SubjectAccessReview against Kubernetes RBAC, using the certificate CN as the user and O as the groups")
+	flag.StringVar(&metricsPort, "metrics-port", "9090", "port for the plain HTTP /metrics endpoint, empty disables it")
 	flag.BoolVar(&version, "version", false, "prints out the version of the application")
 	flag.BoolVar(&local, "local", false, "use kubeconfig on local machine instead of cluster ServiceAccount")
 	flag.BoolVar(&verbose, "verbose", false, "Enables verbose output")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", 0, "how often to reconcile tracked deployments against the state store, 0 disables the reconciler")
+	flag.DurationVar(&drainDelay, "drain-delay", 5*time.Second, "how long to keep serving after failing the readiness probe on shutdown, at least the readiness probe's periodSeconds")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 20*time.Second, "how long to wait for in-flight requests to finish on shutdown, keep it and --drain-delay below the pod's terminationGracePeriodSeconds")
+	flag.StringVar(&reconcileMode, "reconcile-mode", replicas.ReconcileReport, "default reconcile mode: enforce, report or disabled")
+	flag.DurationVar(&scheduleInterval, "schedule-interval", 30*time.Second, "how often to check the scale schedules, 0 disables the scheduler")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "only run background work like the reconciler and scheduler on the replica holding the kube-server Lease, needed when running more than one replica")
+	flag.StringVar(&leaderNamespace, "leader-election-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the kube-server Lease, defaults to $POD_NAMESPACE")
 
 	flag.Parse()
 
@@ -73,63 +105,216 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Generate the Kubernetes client set to access the cluster
-	kClient, err := clusterLogin(local, kubeconfig)
+	switch storeBackend {
+	case state.BackendRedis, state.BackendMemory, state.BackendFile, state.BackendAnnotations, state.BackendConfigMap:
+	default:
+		logger.Fatalf("Invalid state store: %s", storeBackend)
+	}
+
+	if !replicas.ValidReconcileMode(reconcileMode) {
+		logger.Fatalf("Invalid reconcile mode: %s", reconcileMode)
+	}
+
+	if leaderElect && leaderNamespace == "" {
+		logger.Fatal("--leader-elect needs --leader-election-namespace or $POD_NAMESPACE")
+	}
+
+	// Load the authorization policy before doing anything else so a bad policy fails fast
+	var authorizers []auth.Authorizer
+	if authzPolicy != "" {
+		policy, err := auth.LoadPolicy(authzPolicy)
+		if err != nil {
+			logger.Fatalf("Error loading authorization policy: %s", err)
+		}
+		authorizers = append(authorizers, policy)
+	}
+	var replicaLimits *limits.Policy
+	if limitsPolicy != "" {
+		replicaLimits, err = limits.LoadPolicy(limitsPolicy)
+		if err != nil {
+			logger.Fatalf("Error loading replica limits: %s", err)
+		}
+	}
+
+	// Cancelled on SIGTERM from Kubernetes or Ctrl-C, stops the background work and starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Generate the Kubernetes client set and the scale client to access the cluster
+	kClient, scales, err := clusterLogin(local, kubeconfig)
 	if err != nil {
 		logger.Fatalf("Error creating kubernetes client: %s", err)
 	}
 
+	// Requests have to pass both the policy file and RBAC when both are enabled
+	if authzRBAC {
+		authorizers = append(authorizers, auth.NewSubjectAccessReviewAuthorizer(kClient))
+	}
+	if len(authorizers) == 0 {
+		logger.Warn("No authorization configured, every client with a valid certificate has full access")
+	}
+
 	// Create a CA cert pool for Redis and server mTLS
 	caCertPool := x509.NewCertPool()
 
-	// Load the server CA and append to the CA pool
-	redisCACert, err := os.ReadFile(rCACert)
-	if err != nil {
-		logger.Fatal(err)
+	// Create the state store, only Redis needs certs and a connection up front
+	var store state.StateStore
+	switch storeBackend {
+	case state.BackendRedis:
+		// Load the server CA and append to the CA pool
+		redisCACert, err := os.ReadFile(rCACert)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		caCertPool.AppendCertsFromPEM(redisCACert)
+
+		// Load Redis client cert and key
+		redisClientCert, err := tls.LoadX509KeyPair(rClientCert, rClientKey)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		// Create the TLS Config for mTLS connection to Redis
+		redisTLSConfig := &tls.Config{
+			Certificates:       []tls.Certificate{redisClientCert},
+			InsecureSkipVerify: true,
+		}
+
+		// Create the Redis client
+		rClient, err := k8sredis.NewClient(rAddr, redisTLSConfig)
+		if err != nil {
+			logger.Fatalf("Error creating redis client: %s", err)
+		}
+		store = state.NewRedisStore(rClient)
+	case state.BackendMemory:
+		logger.Warn("Using the in-memory state store, state is lost on restart and not shared between replicas")
+		store = state.NewMemoryStore()
+	case state.BackendFile:
+		store, err = state.NewFileStore(stateFile)
+		if err != nil {
+			logger.Fatalf("Error opening state file: %s", err)
+		}
+	case state.BackendAnnotations:
+		store = state.NewAnnotationStore(kClient)
+	case state.BackendConfigMap:
+		store = state.NewConfigMapStore(kClient)
+	}
+
+	// Export the tracked and drifted deployments from the state store
+	metrics.RegisterStateStore(store)
+
+	// Run shared Deployment and StatefulSet informers so handlers read from a watch-backed cache instead of the API server
+	informerFactory := informers.NewSharedInformerFactory(kClient, 10*time.Minute)
+	deploymentInformer := informerFactory.Apps().V1().Deployments()
+	statefulSetInformer := informerFactory.Apps().V1().StatefulSets()
+	listers := replicas.Listers{Deployments: deploymentInformer.Lister(), StatefulSets: statefulSetInformer.Lister()}
+	replicas.WatchDrift(deploymentInformer.Informer(), store)
+	replicas.WatchDrift(statefulSetInformer.Informer(), store)
+
+	stopInformers := make(chan struct{})
+	informerFactory.Start(stopInformers)
+	for informerType, synced := range informerFactory.WaitForCacheSync(stopInformers) {
+		if !synced {
+			logger.Fatalf("Error syncing informer cache for %v", informerType)
+		}
 	}
-	caCertPool.AppendCertsFromPEM(redisCACert)
+	logger.Info("Deployment and StatefulSet informer caches synced")
 
-	// Load Redis client cert and key
-	redisClientCert, err := tls.LoadX509KeyPair(rClientCert, rClientKey)
+	// Background work only runs on the leader so it happens once however many replicas there are
+	identity, err := os.Hostname()
 	if err != nil {
-		logger.Fatal(err)
+		logger.Fatalf("Error getting the hostname for leader election: %s", err)
+	}
+	elector := leader.NewStandalone(identity)
+	if leaderElect {
+		elector = leader.NewElector(kClient, leaderNamespace, "kube-server", identity)
 	}
 
-	// Create the TLS Config for mTLS connection to Redis
-	redisTLSConfig := &tls.Config{
-		Certificates:       []tls.Certificate{redisClientCert},
-		InsecureSkipVerify: true,
+	// Start the drift reconciler in the background if enabled
+	if reconcileInterval > 0 {
+		elector.RunWhileLeader(func(ctx context.Context) {
+			replicas.RunReconciler(ctx, scales, listers, store, reconcileInterval, reconcileMode)
+		})
 	}
 
-	// Create the Redis client
-	rClient, err := k8sredis.NewClient(rAddr, redisTLSConfig)
-	if err != nil {
-		logger.Fatalf("Error creating redis client: %s", err)
+	// Start the scheduler in the background if enabled
+	if scheduleInterval > 0 {
+		elector.RunWhileLeader(func(ctx context.Context) {
+			replicas.RunScheduler(ctx, scales, listers, store, replicaLimits, scheduleInterval)
+		})
 	}
+	go elector.Run(ctx)
 
 	// Gorilla Mux was chosen for the router over the built-in due to it handling parameters in the URI better
-	// We are passing in the kubernetes clientSet and redis client to the handlers where appropriate
+	// We are passing in the kubernetes clientSet and state store to the handlers where appropriate
 	r := mux.NewRouter()
+	// Tag every request with an ID, log and measure it, turn panics into a 500 and identify the client by its certificate
+	// The policy and RBAC authorization happens in the handlers through the identity in the context
+	r.Use(middleware.RequestID, middleware.Logging, middleware.Metrics, middleware.Recovery, auth.Middleware(auth.All(authorizers...)))
 	r.HandleFunc("/v1/deployments", func(w http.ResponseWriter, r *http.Request) {
-		deployments.V1Deployments(w, r, kClient)
+		deployments.V1Deployments(w, r, listers.Deployments, kClient)
+	})
+	r.HandleFunc("/v1/deployments/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		deployments.V1Deployment(w, r, listers.Deployments, store)
+	})
+	r.HandleFunc("/v1/statefulsets", func(w http.ResponseWriter, r *http.Request) {
+		statefulsets.V1StatefulSets(w, r, listers.StatefulSets)
 	})
 	r.HandleFunc("/v1/healthz", func(w http.ResponseWriter, r *http.Request) {
-		healthcheck.V1HealthCheck(w, r, kClient, Version)
+		healthcheck.V1HealthCheck(w, r, kClient, elector, Version)
+	})
+	r.HandleFunc("/v1/livez", func(w http.ResponseWriter, r *http.Request) {
+		healthcheck.V1Livez(w, r, Version)
+	})
+	r.HandleFunc("/v1/readyz", func(w http.ResponseWriter, r *http.Request) {
+		healthcheck.V1Readyz(w, r, kClient, store, elector, Version)
+	})
+	r.HandleFunc("/v1/schedules", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1Schedules(w, r, listers, store)
+	})
+	r.HandleFunc("/v1/schedules/{namespace}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1Schedule(w, r, listers, store)
+	})
+	r.HandleFunc("/v1/replicas:batch", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1ReplicasBatch(w, r, scales, listers, store, replicaLimits)
+	})
+	// Namespaces are DNS labels so they can't contain the ':' before the action
+	r.HandleFunc("/v1/namespaces/{namespace:[a-z0-9-]+}:hibernate", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1NamespaceHibernate(w, r, scales, listers, store, replicaLimits)
+	})
+	r.HandleFunc("/v1/namespaces/{namespace:[a-z0-9-]+}:wake", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1NamespaceWake(w, r, scales, listers, store)
+	})
+	// StatefulSet routes go first so they aren't mistaken for the history of a deployment called statefulsets
+	r.HandleFunc("/v1/replicas/{namespace}/statefulsets/{statefulset}/history", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1ReplicasHistory(w, r, store)
+	})
+	r.HandleFunc("/v1/replicas/{namespace}/statefulsets/{statefulset}", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1StatefulSetReplicas(w, r, scales, listers, store, replicaLimits)
+	})
+	r.HandleFunc("/v1/replicas/{namespace}/{deployment}/history", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1ReplicasHistory(w, r, store)
 	})
 	r.HandleFunc("/v1/replicas/{namespace}/{deployment}", func(w http.ResponseWriter, r *http.Request) {
-		replicas.V1Replicas(w, r, kClient, rClient)
+		replicas.V1Replicas(w, r, scales, listers, store, replicaLimits)
+	})
+	// Catches replicas requests with incomplete paths or anything after the deployment, they get a 400 saying what is wrong
+	r.HandleFunc("/v1/replicas/{namespace}/{deployment}/{extra:.*}", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1Replicas(w, r, scales, listers, store, replicaLimits)
 	})
-	// Catches replicas requests with incomplete paths
 	r.HandleFunc("/v1/replicas/{namespace}", func(w http.ResponseWriter, r *http.Request) {
-		replicas.V1Replicas(w, r, kClient, rClient)
+		replicas.V1Replicas(w, r, scales, listers, store, replicaLimits)
 	})
 	r.HandleFunc("/v1/replicas", func(w http.ResponseWriter, r *http.Request) {
-		replicas.V1Replicas(w, r, kClient, rClient)
+		replicas.V1Replicas(w, r, scales, listers, store, replicaLimits)
+	})
+	// Any resource implementing the scale subresource, the core group is "core"
+	r.HandleFunc("/v1/scale/{group}/{resource}/{namespace}/{name}/history", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1ReplicasHistory(w, r, store)
+	})
+	r.HandleFunc("/v1/scale/{group}/{resource}/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		replicas.V1Scale(w, r, scales, listers, store, replicaLimits)
 	})
-
-	// TODO: we should implement a logging middleware which gorilla mux supports natively
-	// We should also track API requests with UUIDs and forward them to clients for easier troubleshooting
-	// r.Use(loggingMiddleware)
 
 	// Create the mTLS server
 	// Load the server CA and append to the CA pool
@@ -145,26 +330,61 @@ func main() {
 		ClientAuth: tls.RequireAndVerifyClientCert,
 	}
 
+	// Scale requests can wait for their rollout before writing the response
 	server := &http.Server{
 		Addr:         ":" + port,
 		TLSConfig:    serverTLSConfig,
 		Handler:      r,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: 15*time.Second + replicas.MaxRolloutWait,
+	}
+
+	// Serve the metrics on a separate plain HTTP port so Prometheus doesn't need a client certificate
+	if metricsPort != "" {
+		go serveMetrics(metricsPort)
 	}
 
 	// Start the TLS server with Gorilla Mux as the router
 	logger.Infof("Application version is: %s", Version)
 	logger.Infof("Starting server on localhost:%s", port)
-	err = server.ListenAndServeTLS(cert, key)
-	if err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServeTLS(cert, key)
+	}()
+
+	select {
+	case err := <-serverErr:
 		logger.Fatalf("Error: %v", err)
+	case <-ctx.Done():
 	}
 
+	// Fail the readiness probe and keep serving until the pod is taken out of the Service endpoints
+	logger.Infof("Shutting down, serving for %s until the readiness probe takes the pod out of the endpoints", drainDelay)
+	healthcheck.StartDraining()
+	time.Sleep(drainDelay)
+
+	// Stop accepting connections and wait for in-flight requests like scales to finish
+	// Waits for a rollout can take longer than that, so they answer with the progress so far
+	logger.Infof("Waiting up to %s for in-flight requests", shutdownTimeout)
+	replicas.StopWaiting()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Errorf("Error draining requests: %v", err)
+	}
+
+	close(stopInformers)
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Errorf("Error closing state store: %v", err)
+		}
+	}
+	logger.Info("Shutdown complete")
 }
 
 // Logs into the kubernetes cluster and get the clientset
-func clusterLogin(local bool, kubeconfig string) (*kubernetes.Clientset, error) {
+func clusterLogin(local bool, kubeconfig string) (*kubernetes.Clientset, scale.ScalesGetter, error) {
 	var config *rest.Config
 	var err error
 
@@ -172,21 +392,51 @@ func clusterLogin(local bool, kubeconfig string) (*kubernetes.Clientset, error)
 		// use the current context in kubeconfig
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 		if err != nil {
-			return &kubernetes.Clientset{}, err
+			return &kubernetes.Clientset{}, nil, err
 		}
 	} else {
 		// creates the in-cluster config
 		config, err = rest.InClusterConfig()
 		if err != nil {
-			return &kubernetes.Clientset{}, err
+			return &kubernetes.Clientset{}, nil, err
 		}
 	}
 
+	// Time every request to the Kubernetes API for the metrics endpoint
+	config.Wrap(metrics.WrapKubernetesTransport)
+
 	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return &kubernetes.Clientset{}, err
+		return &kubernetes.Clientset{}, nil, err
+	}
+
+	// The scale client finds the version and scale kind of any resource through discovery, cached so CRDs are only looked up once
+	cachedDiscovery := memory.NewMemCacheClient(clientset.Discovery())
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery)
+	scales, err := scale.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc, scale.NewDiscoveryScaleKindResolver(cachedDiscovery))
+	if err != nil {
+		return &kubernetes.Clientset{}, nil, err
 	}
 
-	return clientset, nil
+	return clientset, scales, nil
+}
+
+// Serves the /metrics endpoint, it's not worth taking the API down if this fails
+func serveMetrics(port string) {
+	adminRouter := http.NewServeMux()
+	adminRouter.Handle("/metrics", metrics.Handler())
+
+	adminServer := &http.Server{
+		Addr:         ":" + port,
+		Handler:      adminRouter,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}
+
+	logger.Log.Infof("Serving metrics on localhost:%s/metrics", port)
+	err := adminServer.ListenAndServe()
+	if err != nil {
+		logger.Log.Errorf("Error serving metrics: %v", err)
+	}
 }
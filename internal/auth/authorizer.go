@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+)
+
+// Resources a client can be authorized for, named like their Kubernetes API resources
+// Any other scalable resource is named by its resource and group like "rollouts.argoproj.io"
+const (
+	ResourceDeployments  = "deployments"
+	ResourceStatefulSets = "statefulsets"
+)
+
+// Decides if an identity may use a verb on a named resource in a namespace
+// An empty namespace or name means any of them
+type Authorizer interface {
+	Authorize(ctx context.Context, id *Identity, verb string, resource string, namespace string, name string) (bool, error)
+}
+
+// Authorizes against the rules of the policy file
+func (p *Policy) Authorize(ctx context.Context, id *Identity, verb string, resource string, namespace string, name string) (bool, error) {
+	return p.Allowed(id, verb, resource, namespace, name), nil
+}
+
+// Authorizer that only allows what every one of its authorizers allows
+type allAuthorizer []Authorizer
+
+// Combines authorizers so a request has to pass all of them, like the policy file and Kubernetes RBAC
+func All(authorizers ...Authorizer) Authorizer {
+	switch len(authorizers) {
+	case 0:
+		return nil
+	case 1:
+		return authorizers[0]
+	default:
+		return allAuthorizer(authorizers)
+	}
+}
+
+// Stops at the first authorizer that denies the request
+func (a allAuthorizer) Authorize(ctx context.Context, id *Identity, verb string, resource string, namespace string, name string) (bool, error) {
+	for _, authorizer := range a {
+		allowed, err := authorizer.Authorize(ctx, id, verb, resource, namespace, name)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
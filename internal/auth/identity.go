@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+)
+
+// Name used for clients that didn't present a certificate
+const anonymous = "anonymous"
+
+// Keys for the values the middleware stores in the request context
+type contextKey int
+
+const (
+	identityKey contextKey = iota
+	authorizerKey
+)
+
+// Who the client is according to its mTLS certificate
+type Identity struct {
+	CommonName          string   `json:"common_name"`
+	Organizations       []string `json:"organizations,omitempty"`
+	OrganizationalUnits []string `json:"organizational_units,omitempty"`
+	DNSNames            []string `json:"dns_names,omitempty"`
+	EmailAddresses      []string `json:"email_addresses,omitempty"`
+	URIs                []string `json:"uris,omitempty"`
+}
+
+// Short name of the identity for logs and the history, the CN or the first SAN if there is no CN
+func (id *Identity) String() string {
+	switch {
+	case id == nil:
+		return anonymous
+	case id.CommonName != "":
+		return id.CommonName
+	case len(id.DNSNames) > 0:
+		return id.DNSNames[0]
+	case len(id.EmailAddresses) > 0:
+		return id.EmailAddresses[0]
+	case len(id.URIs) > 0:
+		return id.URIs[0]
+	default:
+		return anonymous
+	}
+}
+
+// Extracts the identity from the verified client certificate, nil if there isn't one
+func IdentityFromRequest(r *http.Request) *Identity {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	// The first certificate is the leaf the client authenticated with
+	cert := r.TLS.PeerCertificates[0]
+	id := &Identity{
+		CommonName:          cert.Subject.CommonName,
+		Organizations:       cert.Subject.Organization,
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+		DNSNames:            cert.DNSNames,
+		EmailAddresses:      cert.EmailAddresses,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+
+	return id
+}
+
+// Gets the identity the middleware stored in the context, falling back to the certificate
+func FromRequest(r *http.Request) *Identity {
+	if id, ok := r.Context().Value(identityKey).(*Identity); ok {
+		return id
+	}
+
+	return IdentityFromRequest(r)
+}
+
+// Middleware that extracts the client identity and makes it and the authorizer available to the handlers
+// A nil authorizer allows every request
+func Middleware(authorizer Authorizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), identityKey, IdentityFromRequest(r))
+			if authorizer != nil {
+				ctx = context.WithValue(ctx, authorizerKey, authorizer)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Checks if the client of the request may use verb on a named resource in a namespace
+// An empty namespace or name means any, requests without an authorizer are always allowed
+// An error means the decision couldn't be made, like the Kubernetes API being down, and is returned to the client as it is
+func Authorized(r *http.Request, verb string, resource string, namespace string, name string) (bool, error) {
+	authorizer, ok := r.Context().Value(authorizerKey).(Authorizer)
+	if !ok {
+		return true, nil
+	}
+
+	allowed, err := authorizer.Authorize(r.Context(), FromRequest(r), verb, resource, namespace, name)
+	if err != nil {
+		logger.Log.Errorf("error authorizing %s to %s %s %s/%s: %s", FromRequest(r), verb, resource, namespace, name, err)
+		return false, err
+	}
+
+	return allowed, nil
+}
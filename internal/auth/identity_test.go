@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Tests extracting the identity from the client certificate of a request
+func TestIdentityFromRequest(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/ns/ci/sa/deployer")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "alice", Organization: []string{"example"}, OrganizationalUnit: []string{"platform"}},
+		DNSNames: []string{"alice.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/deployments", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	id := IdentityFromRequest(req)
+	switch {
+	case id == nil:
+		t.Fatal("Fail: got no identity")
+	case id.String() != "alice":
+		t.Errorf("Fail: got name %s want alice", id)
+	case len(id.OrganizationalUnits) != 1 || id.OrganizationalUnits[0] != "platform":
+		t.Errorf("Fail: got OUs %v want [platform]", id.OrganizationalUnits)
+	case len(id.URIs) != 1 || id.URIs[0] != spiffe.String():
+		t.Errorf("Fail: got URIs %v want [%s]", id.URIs, spiffe)
+	}
+
+	// Without a certificate the client is anonymous
+	req.TLS = nil
+	if id := IdentityFromRequest(req); id != nil || id.String() != anonymous {
+		t.Errorf("Fail: got %v want no identity", id)
+	}
+}
+
+// Tests that the middleware only enforces the policy when there is one
+func TestMiddleware(t *testing.T) {
+	policy := &Policy{Rules: []Rule{{
+		Subjects:    Subjects{CommonNames: []string{"alice"}},
+		Namespaces:  []string{"test"},
+		Deployments: []string{"*"},
+		Verbs:       []string{VerbRead},
+	}}}
+
+	testCases := []struct {
+		name       string
+		authorizer Authorizer
+		commonName string
+		expected   bool
+	}{
+		{name: "no-policy", expected: true},
+		{name: "policy-allowed", authorizer: policy, commonName: "alice", expected: true},
+		{name: "policy-denied", authorizer: policy, commonName: "bob", expected: false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var got bool
+			var err error
+			handler := Middleware(test.authorizer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, err = Authorized(r, VerbRead, ResourceDeployments, "test", "")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/deployments?namespace=test", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.commonName}}}}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			switch {
+			case err != nil:
+				t.Errorf("Fail: got error %s", err)
+			case got != test.expected:
+				t.Errorf("Fail: got %v want %v", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Verbs a policy rule can grant
+const (
+	// GET on deployments, replicas, scale and history
+	VerbRead = "read"
+	// POST on replicas and scale
+	VerbScale = "scale"
+)
+
+// Authorization policy mapping client identities to what they may do, anything not granted is denied
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Grants verbs on namespaces, deployments, StatefulSets and other scalable resources to the identities matched by the subjects
+// Resources are keyed by resource and group like "rollouts.argoproj.io"
+// Namespaces and names support glob patterns like "team-*"
+type Rule struct {
+	Name         string              `json:"name"`
+	Subjects     Subjects            `json:"subjects"`
+	Namespaces   []string            `json:"namespaces"`
+	Deployments  []string            `json:"deployments"`
+	StatefulSets []string            `json:"statefulsets"`
+	Resources    map[string][]string `json:"resources"`
+	Verbs        []string            `json:"verbs"`
+}
+
+// Name patterns the rule grants for a resource
+func (r Rule) names(resource string) []string {
+	switch resource {
+	case ResourceDeployments:
+		return r.Deployments
+	case ResourceStatefulSets:
+		return r.StatefulSets
+	default:
+		return r.Resources[resource]
+	}
+}
+
+// Certificate fields a rule matches on, an identity matching any one of them is a subject
+type Subjects struct {
+	CommonNames         []string `json:"commonNames"`
+	Organizations       []string `json:"organizations"`
+	OrganizationalUnits []string `json:"organizationalUnits"`
+	DNSNames            []string `json:"dnsNames"`
+	EmailAddresses      []string `json:"emailAddresses"`
+	URIs                []string `json:"uris"`
+}
+
+// Loads a YAML or JSON policy file and validates it
+func LoadPolicy(policyPath string) (*Policy, error) {
+	raw, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	err = yaml.UnmarshalStrict(raw, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing policy %s: %w", policyPath, err)
+	}
+
+	for i, rule := range policy.Rules {
+		for _, verb := range rule.Verbs {
+			if verb != VerbRead && verb != VerbScale {
+				return nil, fmt.Errorf("rule %d (%s) has unknown verb %q", i, rule.Name, verb)
+			}
+		}
+		patterns := append(append(append([]string{}, rule.Namespaces...), rule.Deployments...), rule.StatefulSets...)
+		for _, names := range rule.Resources {
+			patterns = append(patterns, names...)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d (%s) has invalid pattern %q", i, rule.Name, pattern)
+			}
+		}
+	}
+
+	logger.Log.Infof("Loaded authorization policy with %d rules from : %s", len(policy.Rules), policyPath)
+
+	return &policy, nil
+}
+
+// Checks if any rule grants the identity verb on the named resource in the namespace
+// An empty namespace or name matches if the rule grants it on any of them
+func (p *Policy) Allowed(id *Identity, verb string, resource string, namespace string, name string) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, rule := range p.Rules {
+		if rule.Subjects.matches(id) && contains(rule.Verbs, verb) &&
+			matchesAny(rule.Namespaces, namespace) && matchesAny(rule.names(resource), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks if the identity is one of the subjects
+func (s Subjects) matches(id *Identity) bool {
+	return contains(s.CommonNames, id.CommonName) ||
+		overlaps(s.Organizations, id.Organizations) ||
+		overlaps(s.OrganizationalUnits, id.OrganizationalUnits) ||
+		overlaps(s.DNSNames, id.DNSNames) ||
+		overlaps(s.EmailAddresses, id.EmailAddresses) ||
+		overlaps(s.URIs, id.URIs)
+}
+
+// Checks if the name matches any of the glob patterns, an empty name matches any non-empty list
+func matchesAny(patterns []string, name string) bool {
+	if name == "" {
+		return len(patterns) > 0
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper to check if a list contains a non-empty value
+func contains(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper to check if two lists share any value
+func overlaps(list []string, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+const testPolicy = `
+rules:
+  - name: platform
+    subjects:
+      organizationalUnits: ["platform"]
+    namespaces: ["*"]
+    deployments: ["*"]
+    verbs: ["read", "scale"]
+  - name: team-a
+    subjects:
+      commonNames: ["alice"]
+      dnsNames: ["ci.team-a.example.com"]
+    namespaces: ["team-a-*"]
+    deployments: ["web", "worker"]
+    statefulsets: ["cache"]
+    resources:
+      rollouts.argoproj.io: ["web-*"]
+    verbs: ["scale"]
+  - name: readers
+    subjects:
+      organizations: ["example"]
+    namespaces: ["team-a-prod"]
+    deployments: ["*"]
+    verbs: ["read"]
+`
+
+// Writes the test policy to a temp file and loads it
+func loadTestPolicy(t *testing.T, policy string) (*Policy, error) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadPolicy(path)
+}
+
+// Tests which identities the policy allows to do what
+func TestPolicyAllowed(t *testing.T) {
+	policy, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		identity   *Identity
+		verb       string
+		resource   string
+		namespace  string
+		deployment string
+		expected   bool
+	}{
+		{
+			name:       "ou-everything",
+			identity:   &Identity{CommonName: "bob", OrganizationalUnits: []string{"platform"}},
+			verb:       VerbScale,
+			namespace:  "kube-system",
+			deployment: "coredns",
+			expected:   true,
+		},
+		{
+			name:       "cn-glob-namespace",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			namespace:  "team-a-prod",
+			deployment: "web",
+			expected:   true,
+		},
+		{
+			name:       "cn-other-deployment",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			namespace:  "team-a-prod",
+			deployment: "database",
+			expected:   false,
+		},
+		{
+			name:       "cn-verb-not-granted",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbRead,
+			namespace:  "team-a-prod",
+			deployment: "web",
+			expected:   false,
+		},
+		{
+			name:       "statefulset-granted",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			resource:   ResourceStatefulSets,
+			namespace:  "team-a-prod",
+			deployment: "cache",
+			expected:   true,
+		},
+		{
+			name:       "statefulset-named-like-deployment",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			resource:   ResourceStatefulSets,
+			namespace:  "team-a-prod",
+			deployment: "web",
+			expected:   false,
+		},
+		{
+			name:       "custom-resource-granted",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			resource:   "rollouts.argoproj.io",
+			namespace:  "team-a-prod",
+			deployment: "web-canary",
+			expected:   true,
+		},
+		{
+			name:       "custom-resource-other-group",
+			identity:   &Identity{CommonName: "alice"},
+			verb:       VerbScale,
+			resource:   "rollouts.example.com",
+			namespace:  "team-a-prod",
+			deployment: "web-canary",
+			expected:   false,
+		},
+		{
+			name:       "dns-san",
+			identity:   &Identity{DNSNames: []string{"ci.team-a.example.com"}},
+			verb:       VerbScale,
+			namespace:  "team-a-dev",
+			deployment: "worker",
+			expected:   true,
+		},
+		{
+			name:      "org-namespace-read",
+			identity:  &Identity{CommonName: "carol", Organizations: []string{"example"}},
+			verb:      VerbRead,
+			namespace: "team-a-prod",
+			expected:  true,
+		},
+		{
+			name:     "org-any-namespace-read",
+			identity: &Identity{CommonName: "carol", Organizations: []string{"example"}},
+			verb:     VerbRead,
+			expected: true,
+		},
+		{
+			name:      "org-other-namespace",
+			identity:  &Identity{CommonName: "carol", Organizations: []string{"example"}},
+			verb:      VerbRead,
+			namespace: "team-b",
+			expected:  false,
+		},
+		{
+			name:      "unknown-identity",
+			identity:  &Identity{CommonName: "mallory"},
+			verb:      VerbRead,
+			namespace: "team-a-prod",
+			expected:  false,
+		},
+		{
+			name:      "no-identity",
+			verb:      VerbRead,
+			namespace: "team-a-prod",
+			expected:  false,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			resource := test.resource
+			if resource == "" {
+				resource = ResourceDeployments
+			}
+			got := policy.Allowed(test.identity, test.verb, resource, test.namespace, test.deployment)
+			if got != test.expected {
+				t.Errorf("Fail: got %v want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+// Tests that invalid policies are rejected when loading
+func TestLoadPolicyInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+	}{
+		{
+			name:   "unknown-verb",
+			policy: "rules:\n  - name: bad\n    verbs: [\"delete\"]\n",
+		},
+		{
+			name:   "bad-pattern",
+			policy: "rules:\n  - name: bad\n    namespaces: [\"[\"]\n",
+		},
+		{
+			name:   "bad-resource-pattern",
+			policy: "rules:\n  - name: bad\n    resources:\n      rollouts.argoproj.io: [\"[\"]\n",
+		},
+		{
+			name:   "unknown-field",
+			policy: "rules:\n  - name: bad\n    users: [\"alice\"]\n",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := loadTestPolicy(t, test.policy); err == nil {
+				t.Errorf("Fail: expected an error loading the policy")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	// Kubernetes packages
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Group the API server adds to every authenticated user, so RBAC bindings to it apply to clients too
+const authenticatedGroup = "system:authenticated"
+
+// How long SubjectAccessReview decisions are cached, listing deployments checks each one
+const defaultReviewTTL = 10 * time.Second
+
+// Most decisions kept in the cache, when it is full of unexpired decisions it starts over
+const maxCachedReviews = 10000
+
+// Authorizer that asks the Kubernetes API with a SubjectAccessReview, so RBAC decides what each client can do
+// Clients are mapped to users the same way the API server does for client certificates: CN is the user and O the groups
+type SubjectAccessReviewAuthorizer struct {
+	kClient kubernetes.Interface
+	ttl     time.Duration
+
+	mu        sync.Mutex
+	cache     map[reviewKey]reviewDecision
+	nextSweep time.Time
+}
+
+// Cache key of a single decision, the attributes are the ones sent to the API server
+type reviewKey struct {
+	user        string
+	groups      string
+	verb        string
+	group       string
+	resource    string
+	subresource string
+	namespace   string
+	name        string
+}
+
+// Cached decision and when it stops being valid
+type reviewDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// Creates an authorizer that delegates to Kubernetes RBAC
+func NewSubjectAccessReviewAuthorizer(kClient kubernetes.Interface) *SubjectAccessReviewAuthorizer {
+	return &SubjectAccessReviewAuthorizer{kClient: kClient, ttl: defaultReviewTTL, cache: map[reviewKey]reviewDecision{}}
+}
+
+// Checks with the API server if the user of the identity may do the equivalent Kubernetes action
+// A named resource is first checked for every name in the namespace, so listing a namespace the client may fully read
+// takes one review instead of one per workload, and only when that is denied the name itself is checked
+func (a *SubjectAccessReviewAuthorizer) Authorize(ctx context.Context, id *Identity, verb string, resource string, namespace string, name string) (bool, error) {
+	if id == nil || id.CommonName == "" {
+		return false, nil
+	}
+
+	attributes := resourceAttributes(verb, resource, namespace, name)
+	if name != "" {
+		allNames := *attributes
+		allNames.Name = ""
+		allowed, err := a.review(ctx, id, &allNames)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+
+	return a.review(ctx, id, attributes)
+}
+
+// Sends a SubjectAccessReview for the attributes unless the decision is cached
+func (a *SubjectAccessReviewAuthorizer) review(ctx context.Context, id *Identity, attributes *authorizationv1.ResourceAttributes) (bool, error) {
+	groups := append([]string{authenticatedGroup}, id.Organizations...)
+	key := reviewKey{
+		user:        id.CommonName,
+		groups:      strings.Join(groups, "\n"),
+		verb:        attributes.Verb,
+		group:       attributes.Group,
+		resource:    attributes.Resource,
+		subresource: attributes.Subresource,
+		namespace:   attributes.Namespace,
+		name:        attributes.Name,
+	}
+	if allowed, ok := a.cached(key); ok {
+		return allowed, nil
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               id.CommonName,
+			Groups:             groups,
+			ResourceAttributes: attributes,
+		},
+	}
+	resp, err := a.kClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		logger.Log.Errorf("error creating SubjectAccessReview for %s: %s", id, err)
+		// Not knowing isn't a denial, the client should retry rather than ask for permissions it may already have
+		return false, e.UpstreamUnavailable(err, "couldn't check the permissions of %s with the Kubernetes API, try again later", id)
+	}
+
+	logger.Log.Debugf("SubjectAccessReview for %s to %s %s %s/%s: allowed=%t %s", id, attributes.Verb, attributes.Resource, attributes.Namespace, attributes.Name, resp.Status.Allowed, resp.Status.Reason)
+	a.store(key, resp.Status.Allowed)
+
+	return resp.Status.Allowed, nil
+}
+
+// Gets a decision from the cache if it hasn't expired
+func (a *SubjectAccessReviewAuthorizer) cached(key reviewKey) (bool, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	decision, ok := a.cache[key]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(decision.expires) {
+		delete(a.cache, key)
+		return false, false
+	}
+
+	return decision.allowed, true
+}
+
+// Caches a decision, removing expired ones once per TTL so clients that stop calling don't stay in memory
+func (a *SubjectAccessReviewAuthorizer) store(key reviewKey, allowed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.After(a.nextSweep) || len(a.cache) >= maxCachedReviews {
+		for k, decision := range a.cache {
+			if now.After(decision.expires) {
+				delete(a.cache, k)
+			}
+		}
+		a.nextSweep = now.Add(a.ttl)
+	}
+	// Every decision is still valid, dropping them all only costs new reviews
+	if len(a.cache) >= maxCachedReviews {
+		a.cache = map[reviewKey]reviewDecision{}
+	}
+
+	a.cache[key] = reviewDecision{allowed: allowed, expires: now.Add(a.ttl)}
+}
+
+// Maps our verbs to the Kubernetes request they stand for
+// Reading a workload is get, reading a namespace is list and scaling is update on the scale subresource like kubectl scale
+func resourceAttributes(verb string, resource string, namespace string, name string) *authorizationv1.ResourceAttributes {
+	groupResource := schema.GroupResource{Group: "apps", Resource: resource}
+	if resource != ResourceDeployments && resource != ResourceStatefulSets {
+		groupResource = schema.ParseGroupResource(resource)
+	}
+
+	attributes := &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Group:     groupResource.Group,
+		Resource:  groupResource.Resource,
+		Name:      name,
+	}
+
+	switch {
+	case verb == VerbScale:
+		attributes.Verb = "update"
+		attributes.Subresource = "scale"
+	case name == "":
+		attributes.Verb = "list"
+	default:
+		attributes.Verb = "get"
+	}
+
+	return attributes
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Tests that requests are mapped to SubjectAccessReviews, named ones after a review for the whole namespace, and the decisions are cached
+func TestSubjectAccessReviewAuthorizer(t *testing.T) {
+	fakeClientset := testclient.NewSimpleClientset()
+
+	// Pretend RBAC lets the deployers group scale web in test, the readers group get every deployment in test and nothing else
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		reviews = append(reviews, review.Spec)
+
+		attributes := review.Spec.ResourceAttributes
+		for _, group := range review.Spec.Groups {
+			if group == "deployers" && attributes.Resource == "deployments" && attributes.Namespace == "test" && attributes.Name == "web" &&
+				attributes.Verb == "update" && attributes.Subresource == "scale" {
+				review.Status.Allowed = true
+			}
+			if group == "readers" && attributes.Resource == "deployments" && attributes.Namespace == "test" && attributes.Name == "" && attributes.Verb == "get" {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+
+	authorizer := NewSubjectAccessReviewAuthorizer(fakeClientset)
+
+	testCases := []struct {
+		name            string
+		identity        *Identity
+		verb            string
+		resource        string
+		deployment      string
+		expected        bool
+		expectedVerb    string
+		expectedReviews int
+	}{
+		{
+			name:            "scale-allowed",
+			identity:        &Identity{CommonName: "alice", Organizations: []string{"deployers"}},
+			verb:            VerbScale,
+			resource:        ResourceDeployments,
+			deployment:      "web",
+			expected:        true,
+			expectedVerb:    "update",
+			expectedReviews: 2,
+		},
+		{
+			name:            "read-denied",
+			identity:        &Identity{CommonName: "alice", Organizations: []string{"deployers"}},
+			verb:            VerbRead,
+			resource:        ResourceDeployments,
+			deployment:      "web",
+			expected:        false,
+			expectedVerb:    "get",
+			expectedReviews: 2,
+		},
+		{
+			name:            "list-denied",
+			identity:        &Identity{CommonName: "alice", Organizations: []string{"deployers"}},
+			verb:            VerbRead,
+			resource:        ResourceDeployments,
+			expected:        false,
+			expectedVerb:    "list",
+			expectedReviews: 1,
+		},
+		{
+			name:            "statefulset-scale-denied",
+			identity:        &Identity{CommonName: "alice", Organizations: []string{"deployers"}},
+			verb:            VerbScale,
+			resource:        ResourceStatefulSets,
+			deployment:      "web",
+			expected:        false,
+			expectedVerb:    "update",
+			expectedReviews: 2,
+		},
+		{
+			name:            "namespace-read-allowed",
+			identity:        &Identity{CommonName: "carol", Organizations: []string{"readers"}},
+			verb:            VerbRead,
+			resource:        ResourceDeployments,
+			deployment:      "web",
+			expected:        true,
+			expectedVerb:    "get",
+			expectedReviews: 1,
+		},
+		{
+			name:            "other-user",
+			identity:        &Identity{CommonName: "bob"},
+			verb:            VerbScale,
+			resource:        ResourceDeployments,
+			deployment:      "web",
+			expected:        false,
+			expectedVerb:    "update",
+			expectedReviews: 2,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			reviews = nil
+			allowed, err := authorizer.Authorize(context.TODO(), test.identity, test.verb, test.resource, "test", test.deployment)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case allowed != test.expected:
+				t.Errorf("Fail: got %v want %v", allowed, test.expected)
+			case len(reviews) != test.expectedReviews:
+				t.Errorf("Fail: got %d reviews want %d", len(reviews), test.expectedReviews)
+			case reviews[0].User != test.identity.CommonName || reviews[0].Groups[0] != authenticatedGroup:
+				t.Errorf("Fail: got user %s groups %v", reviews[0].User, reviews[0].Groups)
+			case reviews[0].ResourceAttributes.Name != "":
+				t.Errorf("Fail: got name %q in the first review want the whole namespace", reviews[0].ResourceAttributes.Name)
+			case len(reviews) > 1 && reviews[len(reviews)-1].ResourceAttributes.Name != test.deployment:
+				t.Errorf("Fail: got name %q in the last review want %q", reviews[len(reviews)-1].ResourceAttributes.Name, test.deployment)
+			case reviews[len(reviews)-1].ResourceAttributes.Verb != test.expectedVerb:
+				t.Errorf("Fail: got verb %s want %s", reviews[len(reviews)-1].ResourceAttributes.Verb, test.expectedVerb)
+			}
+
+			// The same question again is answered from the cache
+			reviews = nil
+			if _, err := authorizer.Authorize(context.TODO(), test.identity, test.verb, test.resource, "test", test.deployment); err != nil {
+				t.Fatal(err)
+			}
+			if len(reviews) != 0 {
+				t.Errorf("Fail: got %d reviews want the cached decision", len(reviews))
+			}
+		})
+	}
+}
+
+// Tests a failed SubjectAccessReview is reported as the Kubernetes API being unavailable instead of a denial
+func TestSubjectAccessReviewError(t *testing.T) {
+	fakeClientset := testclient.NewSimpleClientset()
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	authorizer := NewSubjectAccessReviewAuthorizer(fakeClientset)
+
+	allowed, err := authorizer.Authorize(context.TODO(), &Identity{CommonName: "alice"}, VerbRead, ResourceDeployments, "test", "web")
+	var problem *e.Error
+	switch {
+	case allowed:
+		t.Errorf("Fail: got allowed want denied")
+	case !errors.As(err, &problem) || problem.Kind != e.KindUpstreamUnavailable:
+		t.Errorf("Fail: got error %v want the Kubernetes API to be unavailable", err)
+	}
+	// Failures aren't cached, the next check asks again
+	if len(authorizer.cache) != 0 {
+		t.Errorf("Fail: cached a failed review")
+	}
+}
+
+// Tests expired decisions are swept out of the cache and it never grows past its limit
+func TestSubjectAccessReviewCache(t *testing.T) {
+	authorizer := NewSubjectAccessReviewAuthorizer(testclient.NewSimpleClientset())
+	expired := reviewKey{user: "gone"}
+	authorizer.cache[expired] = reviewDecision{allowed: true, expires: time.Now().Add(-time.Second)}
+
+	authorizer.store(reviewKey{user: "alice"}, true)
+	if _, ok := authorizer.cache[expired]; ok {
+		t.Errorf("Fail: expired decision is still cached")
+	}
+
+	for i := 0; i < maxCachedReviews+10; i++ {
+		authorizer.store(reviewKey{user: "alice", name: fmt.Sprint(i)}, true)
+	}
+	if len(authorizer.cache) > maxCachedReviews {
+		t.Errorf("Fail: got %d cached decisions want at most %d", len(authorizer.cache), maxCachedReviews)
+	}
+}
+
+// Tests the API group and resource every kind of resource is checked against
+func TestResourceAttributes(t *testing.T) {
+	testCases := []struct {
+		name             string
+		resource         string
+		expectedGroup    string
+		expectedResource string
+	}{
+		{name: "deployments", resource: ResourceDeployments, expectedGroup: "apps", expectedResource: "deployments"},
+		{name: "statefulsets", resource: ResourceStatefulSets, expectedGroup: "apps", expectedResource: "statefulsets"},
+		{name: "replicasets", resource: "replicasets.apps", expectedGroup: "apps", expectedResource: "replicasets"},
+		{name: "custom-resource", resource: "rollouts.argoproj.io", expectedGroup: "argoproj.io", expectedResource: "rollouts"},
+		{name: "core", resource: "replicationcontrollers", expectedGroup: "", expectedResource: "replicationcontrollers"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			attributes := resourceAttributes(VerbScale, test.resource, "test", "web")
+			if attributes.Group != test.expectedGroup || attributes.Resource != test.expectedResource || attributes.Subresource != "scale" {
+				t.Errorf("Fail: got %s/%s/%s want %s/%s/scale", attributes.Group, attributes.Resource, attributes.Subresource, test.expectedGroup, test.expectedResource)
+			}
+		})
+	}
+}
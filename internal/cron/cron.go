@@ -0,0 +1,233 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shorthands for common schedules
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Bounds and names allowed in one field of an expression
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minutes     = field{name: "minute", min: 0, max: 59}
+	hours       = field{name: "hour", min: 0, max: 23}
+	daysOfMonth = field{name: "day of month", min: 1, max: 31}
+	months      = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	daysOfWeek = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Don't look further ahead than this for a matching time, "0 0 30 2 *" never matches
+const maxYears = 5
+
+// Parsed standard 5 field cron expression: minute, hour, day of month, month and day of week
+// Each field is a bitset of the values it matches
+type Schedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// Like Vixie cron, a day matches either day field unless one of them starts with *
+	dayOfMonthStar bool
+	dayOfWeekStar  bool
+}
+
+// Parses a cron expression like "0 8 * * MON-FRI" or a shorthand like "@daily"
+// Fields accept *, values, ranges, steps and comma separated lists, months and days of the week also accept names
+func Parse(spec string) (*Schedule, error) {
+	if expanded, ok := descriptors[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &Schedule{
+		dayOfMonthStar: strings.HasPrefix(fields[2], "*"),
+		dayOfWeekStar:  strings.HasPrefix(fields[4], "*"),
+	}
+	for i, f := range []struct {
+		bits  *uint64
+		field field
+	}{
+		{&s.minute, minutes},
+		{&s.hour, hours},
+		{&s.dayOfMonth, daysOfMonth},
+		{&s.month, months},
+		{&s.dayOfWeek, daysOfWeek},
+	} {
+		bits, err := parseField(fields[i], f.field)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", spec, err)
+		}
+		*f.bits = bits
+	}
+	// Fold 7 into Sunday so it matches time.Sunday
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek = s.dayOfWeek&^(1<<7) | 1
+	}
+
+	return s, nil
+}
+
+// Parses a comma separated list of values, ranges and steps into a bitset
+func parseField(expr string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepExpr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepExpr, f.name)
+			}
+		}
+
+		var low, high int
+		switch lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-"); {
+		case rangeExpr == "*":
+			low, high = f.min, f.max
+		case isRange:
+			var err error
+			if low, err = parseValue(lowExpr, f); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(highExpr, f); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s", rangeExpr, f.name)
+			}
+		default:
+			var err error
+			if low, err = parseValue(rangeExpr, f); err != nil {
+				return 0, err
+			}
+			// "5/15" means every 15 starting at 5
+			high = low
+			if hasStep {
+				high = f.max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// Parses a number or name within the bounds of the field
+func parseValue(expr string, f field) (int, error) {
+	if v, ok := f.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, must be between %d and %d", f.name, expr, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Gets the first time after t the expression matches, in the location of t
+// Returns the zero time if nothing matches within the next few years
+// Times skipped by a daylight saving change don't match and times it repeats match twice
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + maxYears
+
+	// Each field moves to the next value it matches, starting over from the month when a bigger unit rolls over
+search:
+	for t.Year() <= limit {
+		for !has(s.month, int(t.Month())) {
+			t = startOfDay(t.Year(), t.Month()+1, 1, loc)
+		}
+
+		for !s.dayMatches(t) {
+			t = startOfDay(t.Year(), t.Month(), t.Day()+1, loc)
+			if t.Day() == 1 {
+				continue search
+			}
+		}
+
+		day := t.Day()
+		for !has(s.hour, t.Hour()) {
+			// Adding the minutes rather than building the next hour with time.Date always moves forward across a daylight saving change
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			if t.Day() != day {
+				continue search
+			}
+		}
+
+		hour := t.Hour()
+		for !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			if t.Hour() != hour {
+				continue search
+			}
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// Gets the first minute of a day, which is after midnight when a daylight saving change skips midnight
+// time.Date moves a skipped time back before the change, which could be the day before
+func startOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	// Noon always exists, so it has the day the date normalizes to
+	for noon := time.Date(year, month, day, 12, 0, 0, 0, loc); t.Day() != noon.Day(); {
+		t = t.Add(time.Hour)
+	}
+
+	return t
+}
+
+// Checks the day of the month and day of the week of t
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dayOfMonth := has(s.dayOfMonth, t.Day())
+	dayOfWeek := has(s.dayOfWeek, int(t.Weekday()))
+	if s.dayOfMonthStar || s.dayOfWeekStar {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
+
+// Checks whether the bit for v is set
+func has(bits uint64, v int) bool {
+	return bits&(1<<v) != 0
+}
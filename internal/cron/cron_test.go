@@ -0,0 +1,154 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests parsing valid and invalid cron expressions
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name          string
+		spec          string
+		expectSuccess bool
+	}{
+		{name: "every-minute", spec: "* * * * *", expectSuccess: true},
+		{name: "weekdays", spec: "0 8 * * MON-FRI", expectSuccess: true},
+		{name: "lists-and-steps", spec: "*/15 8-18/2 1,15 jan-jun 0,7", expectSuccess: true},
+		{name: "descriptor", spec: "@daily", expectSuccess: true},
+		{name: "too-few-fields", spec: "0 8 * *", expectSuccess: false},
+		{name: "too-many-fields", spec: "0 0 8 * * *", expectSuccess: false},
+		{name: "out-of-range", spec: "60 * * * *", expectSuccess: false},
+		{name: "backwards-range", spec: "0 20-8 * * *", expectSuccess: false},
+		{name: "zero-step", spec: "*/0 * * * *", expectSuccess: false},
+		{name: "unknown-name", spec: "0 8 * * MONDAY", expectSuccess: false},
+		{name: "empty-list-item", spec: "0 8,,9 * * *", expectSuccess: false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.spec)
+			switch {
+			case test.expectSuccess && err != nil:
+				t.Errorf("expected success, got error: %v", err)
+			case !test.expectSuccess && err == nil:
+				t.Errorf("expected error, got success")
+			}
+		})
+	}
+}
+
+// Tests finding the next time an expression matches
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %s", err)
+	}
+	// Sao Paulo used to start daylight saving time at midnight
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skipf("no time zone database: %s", err)
+	}
+
+	testCases := []struct {
+		name     string
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			name:     "next-minute",
+			spec:     "* * * * *",
+			from:     time.Date(2022, 6, 1, 10, 30, 15, 0, time.UTC),
+			expected: time.Date(2022, 6, 1, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name:     "strictly-after",
+			spec:     "30 10 * * *",
+			from:     time.Date(2022, 6, 1, 10, 30, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 2, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "friday-to-monday",
+			spec:     "0 8 * * MON-FRI",
+			from:     time.Date(2022, 6, 3, 9, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 6, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "evening",
+			spec:     "0 20 * * 1-5",
+			from:     time.Date(2022, 6, 6, 8, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 6, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "steps",
+			spec:     "*/20 * * * *",
+			from:     time.Date(2022, 6, 1, 10, 41, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "new-year",
+			spec:     "@yearly",
+			from:     time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day-of-month-or-week",
+			spec:     "0 0 13 * FRI",
+			from:     time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday-as-7",
+			spec:     "0 0 * * 7",
+			from:     time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap-day",
+			spec:     "0 0 29 2 *",
+			from:     time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never",
+			spec:     "0 0 30 2 *",
+			from:     time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Time{},
+		},
+		{
+			name:     "time-zone",
+			spec:     "0 8 * * *",
+			from:     time.Date(2022, 6, 1, 9, 0, 0, 0, newYork),
+			expected: time.Date(2022, 6, 2, 8, 0, 0, 0, newYork),
+		},
+		{
+			name:     "skipped-by-daylight-saving",
+			spec:     "30 2 * * *",
+			from:     time.Date(2022, 3, 12, 3, 0, 0, 0, newYork),
+			expected: time.Date(2022, 3, 14, 2, 30, 0, 0, newYork),
+		},
+		{
+			name:     "midnight-skipped-by-daylight-saving",
+			spec:     "0 * 4 11 *",
+			from:     time.Date(2018, 11, 3, 12, 0, 0, 0, saoPaulo),
+			expected: time.Date(2018, 11, 4, 1, 0, 0, 0, saoPaulo),
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := Parse(test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := schedule.Next(test.from)
+			if !next.Equal(test.expected) {
+				t.Errorf("Fail: got %s want %s", next, test.expected)
+			} else {
+				t.Logf("test passed %s", next)
+			}
+		})
+	}
+}
@@ -1,87 +1,114 @@
 package deployments
 
 import (
-	"context"
-	"errors"
-	"fmt"
 	"net/http"
+	"time"
 
 	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
 	e "github.com/taylorsmcclure/kube-server/internal/errors"
-	"github.com/taylorsmcclure/kube-server/internal/logger"
 	"github.com/taylorsmcclure/kube-server/internal/responses"
 
 	// k8s api packages
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
 )
 
 // JSON response for deployments
 type getDeploymentsResponse struct {
 	Code        int               `json:"http_response_code"`
 	Deployments []DeployNamespace `json:"deployments"`
+	// Pass it back as ?continue= for the next page, empty on the last page
+	Continue string `json:"continue,omitempty"`
 }
 
 // Struct for a list of deployments and namespaces
 type DeployNamespace struct {
-	Deployment string `json:"deployment_name"`
-	Namespace  string `json:"namespace"`
+	Deployment string    `json:"deployment_name"`
+	Namespace  string    `json:"namespace"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
-// Error type for when there are no deployments found
-type errNoDeployments error
-
 // Lists all deployments on the cluster
-func V1Deployments(w http.ResponseWriter, r *http.Request, kClient kubernetes.Interface) {
-	// Catch fatal errors that would otherwise cause the server to quit
-	defer e.NonFatal()
-
+func V1Deployments(w http.ResponseWriter, r *http.Request, dLister appslisters.DeploymentLister, kClient kubernetes.Interface) {
 	// We may want to support more methods in the future, so we'll use a switch statement
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
-		var namespace string
-		// Allow filtering by namespace deployments?namespace=<namespace>
-		if len(r.URL.Query()["namespace"]) == 0 {
-			namespace = ""
-		} else {
-			namespace = r.URL.Query()["namespace"][0]
+		// Allow filtering by namespace deployments?namespace=<namespace>, by labels and fields, sorting and paging
+		opts, err := parseListOptions(r)
+		if err != nil {
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+		namespace := opts.Namespace
+		// Clients need read access to at least one deployment in the namespace, or any namespace when not filtering
+		allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceDeployments, namespace, "")
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to read deployments", auth.FromRequest(r)))
+			return
 		}
 		// Get the deployments
-		resp, err := getDeployments(kClient, namespace)
+		resp, err := getDeployments(dLister, opts)
 		if err != nil {
-			// Handle specific error types
-			// TODO: implement a more scalable HTTP error handling system and DRY it up
-			switch err.(type) {
-			case errNoDeployments:
-				responses.ReturnJsonResponse(w, 404, e.GenericError{Code: 404, Message: fmt.Sprint(err)})
+			responses.ReturnError(w, err)
+			return
+		}
+		// The cache can't tell an empty namespace from a missing one, so ask the API server
+		if len(resp.Deployments) == 0 && namespace != "" {
+			_, err = kClient.CoreV1().Namespaces().Get(r.Context(), namespace, metav1.GetOptions{})
+			if err != nil {
+				responses.ReturnError(w, err)
 				return
-			default:
-				logger.Log.Error(err)
-				responses.ReturnJsonResponse(w, 500, e.GenericError{Code: 500, Message: "Internal server"})
 			}
 		}
+		// Only list the deployments the client is allowed to read, before paging so every page is full
+		readable := []DeployNamespace{}
+		for _, d := range resp.Deployments {
+			allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceDeployments, d.Namespace, d.Deployment)
+			if err != nil {
+				responses.ReturnError(w, err)
+				return
+			}
+			if allowed {
+				readable = append(readable, d)
+			}
+		}
+		resp.Deployments, resp.Continue = paginate(readable, opts)
 		responses.ReturnJsonResponse(w, 200, resp)
 	default:
-		responses.ReturnJsonResponse(w, 405, e.GenericError{Code: 405, Message: "method not allowed"})
+		responses.ReturnError(w, e.MethodNotAllowed())
 	}
 }
 
-// Gets all deployments on the cluster or filter by namespace
-func getDeployments(kClient kubernetes.Interface, namespace string) (*getDeploymentsResponse, error) {
-	deployments, err := kClient.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+// Gets all deployments on the cluster or filter by namespace, labels and fields from the informer cache, sorted like the client asked
+func getDeployments(dLister appslisters.DeploymentLister, opts *listOptions) (*getDeploymentsResponse, error) {
+	var deployments []*appsv1.Deployment
+	var err error
+	if opts.Namespace == "" {
+		deployments, err = dLister.List(opts.LabelSelector)
+	} else {
+		deployments, err = dLister.Deployments(opts.Namespace).List(opts.LabelSelector)
+	}
 	if err != nil {
 		return &getDeploymentsResponse{}, err
 	}
 
-	// If there are no deployments, return a specific error
-	if len(deployments.Items) == 0 {
-		return &getDeploymentsResponse{}, errNoDeployments(errors.New("no deployments found"))
+	availableDeployments := []DeployNamespace{}
+	for _, d := range deployments {
+		deployment := DeployNamespace{Deployment: d.Name, Namespace: d.Namespace, CreatedAt: d.CreationTimestamp.UTC()}
+		if matchesFields(opts.FieldSelector, deployment) {
+			availableDeployments = append(availableDeployments, deployment)
+		}
 	}
 
-	var availableDeployments []DeployNamespace
-	for _, d := range deployments.Items {
-		availableDeployments = append(availableDeployments, DeployNamespace{Deployment: d.Name, Namespace: d.Namespace})
-	}
+	// The cache has no ordering, sort so responses and pages are stable
+	sortDeployments(availableDeployments, opts.Sort)
 
 	resp := &getDeploymentsResponse{Code: 200, Deployments: availableDeployments}
 
@@ -1,17 +1,31 @@
 package deployments
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"github.com/taylorsmcclure/kube-server/internal/auth"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
 	testclient "k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 // I don't like being dependent on the internal package, but
@@ -20,6 +34,20 @@ func init() {
 	logger.Setup(false)
 }
 
+// Builds a deployment lister backed by a fake clientset and waits for its cache to fill
+func newTestLister(t *testing.T, objects ...runtime.Object) appslisters.DeploymentLister {
+	fakeClientset := testclient.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(fakeClientset, 0)
+	dLister := factory.Apps().V1().Deployments().Lister()
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+
+	return dLister
+}
+
 // Tests getDeployments() with multiple cases
 func TestGetDeployments(t *testing.T) {
 
@@ -62,24 +90,24 @@ func TestGetDeployments(t *testing.T) {
 				Items: []appsv1.Deployment{},
 			},
 			},
-			expectSuccess:    false,
-			expectedResponse: getDeploymentsResponse{},
+			expectSuccess:    true,
+			expectedResponse: getDeploymentsResponse{Code: 200, Deployments: []DeployNamespace{}},
 		},
 		{
 			name:      "multi_deployment_filter",
-			namespace: "test_multi",
+			namespace: "test-multi",
 			deployments: []runtime.Object{&appsv1.DeploymentList{
 				Items: []appsv1.Deployment{
 					{
 						ObjectMeta: metav1.ObjectMeta{
 							Name:      "test_deployment0",
-							Namespace: "test_multi",
+							Namespace: "test-multi",
 						},
 					},
 					{
 						ObjectMeta: metav1.ObjectMeta{
 							Name:      "test_deployment1",
-							Namespace: "test_multi",
+							Namespace: "test-multi",
 						},
 					},
 				},
@@ -91,11 +119,11 @@ func TestGetDeployments(t *testing.T) {
 				Deployments: []DeployNamespace{
 					{
 						Deployment: "test_deployment0",
-						Namespace:  "test_multi",
+						Namespace:  "test-multi",
 					},
 					{
 						Deployment: "test_deployment1",
-						Namespace:  "test_multi",
+						Namespace:  "test-multi",
 					},
 				},
 			},
@@ -159,10 +187,9 @@ func TestGetDeployments(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			fakeClientset := testclient.NewSimpleClientset(test.deployments...)
 			resp, err := getDeployments(
-				fakeClientset,
-				test.namespace,
+				newTestLister(t, test.deployments...),
+				&listOptions{Namespace: test.namespace, LabelSelector: labels.Everything(), FieldSelector: fields.Everything(), Sort: sortNamespace},
 			)
 			switch {
 			case test.expectSuccess && err != nil:
@@ -224,24 +251,24 @@ func TestV1Deployments(t *testing.T) {
 				Items: []appsv1.Deployment{},
 			},
 			},
-			expectSuccess:    false,
-			expectedResponse: getDeploymentsResponse{},
+			expectSuccess:    true,
+			expectedResponse: getDeploymentsResponse{Code: 200, Deployments: []DeployNamespace{}},
 		},
 		{
 			name:      "multi_deployment_filter",
-			namespace: "test_multi",
+			namespace: "test-multi",
 			deployments: []runtime.Object{&appsv1.DeploymentList{
 				Items: []appsv1.Deployment{
 					{
 						ObjectMeta: metav1.ObjectMeta{
 							Name:      "test_deployment0",
-							Namespace: "test_multi",
+							Namespace: "test-multi",
 						},
 					},
 					{
 						ObjectMeta: metav1.ObjectMeta{
 							Name:      "test_deployment1",
-							Namespace: "test_multi",
+							Namespace: "test-multi",
 						},
 					},
 				},
@@ -253,11 +280,11 @@ func TestV1Deployments(t *testing.T) {
 				Deployments: []DeployNamespace{
 					{
 						Deployment: "test_deployment0",
-						Namespace:  "test_multi",
+						Namespace:  "test-multi",
 					},
 					{
 						Deployment: "test_deployment1",
-						Namespace:  "test_multi",
+						Namespace:  "test-multi",
 					},
 				},
 			},
@@ -333,10 +360,11 @@ func TestV1Deployments(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			fakeClientset := testclient.NewSimpleClientset(test.deployments...)
+			dLister := newTestLister(t, test.deployments...)
+			kClient := testclient.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: test.namespace}})
 
 			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				V1Deployments(w, r, fakeClientset)
+				V1Deployments(w, r, dLister, kClient)
 			})
 
 			handler.ServeHTTP(rr, req)
@@ -354,3 +382,141 @@ func TestV1Deployments(t *testing.T) {
 	}
 
 }
+
+// Tests that clients only see the deployments the authorization policy lets them read
+func TestV1DeploymentsAuthorization(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{
+		Subjects:    auth.Subjects{CommonNames: []string{"reader"}},
+		Namespaces:  []string{"test"},
+		Deployments: []string{"*"},
+		Verbs:       []string{auth.VerbRead},
+	}}}
+	dLister := newTestLister(t,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test_deployment", Namespace: "test"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other_deployment", Namespace: "other"}},
+	)
+
+	testCases := []struct {
+		name                string
+		namespace           string
+		commonName          string
+		expectedCode        int
+		expectedDeployments []DeployNamespace
+	}{
+		{
+			name:                "filtered-list",
+			commonName:          "reader",
+			expectedCode:        200,
+			expectedDeployments: []DeployNamespace{{Deployment: "test_deployment", Namespace: "test"}},
+		},
+		{
+			name:         "namespace-denied",
+			namespace:    "other",
+			commonName:   "reader",
+			expectedCode: 403,
+		},
+		{
+			name:         "unknown-client",
+			commonName:   "mallory",
+			expectedCode: 403,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/deployments?namespace="+test.namespace, nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.commonName}}}}
+			rr := httptest.NewRecorder()
+
+			handler := auth.Middleware(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				V1Deployments(w, r, dLister, testclient.NewSimpleClientset())
+			}))
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp getDeploymentsResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Deployments, test.expectedDeployments) {
+				t.Errorf("Fail: got %v want %v", resp.Deployments, test.expectedDeployments)
+			}
+		})
+	}
+}
+
+// Tests empty lists are a 200 and errors from the Kubernetes API keep their status code, with exactly one response each
+func TestV1DeploymentsErrors(t *testing.T) {
+	dLister := newTestLister(t, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test_deployment", Namespace: "test"}})
+
+	testCases := []struct {
+		name         string
+		namespace    string
+		namespaceErr error
+		expectedCode int
+	}{
+		{
+			name:         "empty-namespace",
+			namespace:    "empty",
+			expectedCode: 200,
+		},
+		{
+			name:         "missing-namespace",
+			namespace:    "missing",
+			expectedCode: 404,
+		},
+		{
+			name:         "forbidden-namespace",
+			namespace:    "empty",
+			namespaceErr: errors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "empty", fmt.Errorf("RBAC denied")),
+			expectedCode: 403,
+		},
+		{
+			name:         "api-error",
+			namespace:    "empty",
+			namespaceErr: fmt.Errorf("connection refused"),
+			expectedCode: 500,
+		},
+		{
+			name:         "no-selector-match",
+			namespace:    "test",
+			expectedCode: 200,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kClient := testclient.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}})
+			if test.namespaceErr != nil {
+				kClient.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, test.namespaceErr
+				})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/deployments?labelSelector=app%3Dnone&namespace="+test.namespace, nil)
+			rr := httptest.NewRecorder()
+			V1Deployments(rr, req, dLister, kClient)
+
+			// A second response written after the first one would make the body invalid JSON
+			var resp map[string]interface{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			switch {
+			case err != nil:
+				t.Errorf("Fail: got %s: %s", rr.Body.String(), err)
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case rr.Code == 200 && !reflect.DeepEqual(resp["deployments"], []interface{}{}):
+				t.Errorf("Fail: got %s want an empty list", rr.Body.String())
+			default:
+				t.Logf("test passed %s", rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package deployments
+
+import (
+	"net/http"
+	"time"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	// k8s api packages
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+
+	"github.com/gorilla/mux"
+)
+
+// JSON response for a single deployment
+type getDeploymentResponse struct {
+	Code              int                   `json:"http_response_code"`
+	Deployment        string                `json:"deployment_name"`
+	Namespace         string                `json:"namespace"`
+	Replicas          int32                 `json:"replicas"`
+	ReadyReplicas     int32                 `json:"ready_replicas"`
+	AvailableReplicas int32                 `json:"available_replicas"`
+	UpdatedReplicas   int32                 `json:"updated_replicas"`
+	Unavailable       int32                 `json:"unavailable_replicas"`
+	Strategy          deploymentStrategy    `json:"strategy"`
+	Selector          string                `json:"selector,omitempty"`
+	Containers        []container           `json:"containers"`
+	InitContainers    []container           `json:"init_containers,omitempty"`
+	Conditions        []deploymentCondition `json:"conditions"`
+	Labels            map[string]string     `json:"labels,omitempty"`
+	Annotations       map[string]string     `json:"annotations,omitempty"`
+	CreatedAt         time.Time             `json:"created_at"`
+	Age               string                `json:"age"`
+	State             deploymentState       `json:"state"`
+}
+
+// Update strategy of a deployment, the surge and unavailable limits are numbers or percentages
+type deploymentStrategy struct {
+	Type           string `json:"type"`
+	MaxSurge       string `json:"max_surge,omitempty"`
+	MaxUnavailable string `json:"max_unavailable,omitempty"`
+}
+
+// Name and image of a container in the pod template
+type container struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// Condition reported by the deployment controller
+type deploymentCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastUpdateTime     time.Time `json:"last_update_time"`
+	LastTransitionTime time.Time `json:"last_transition_time"`
+}
+
+// What kube-server has stored for the deployment, only the tracked field is set if it never saw it
+type deploymentState struct {
+	Tracked            bool   `json:"tracked"`
+	DesiredReplicas    *int32 `json:"desired_replicas,omitempty"`
+	Drift              bool   `json:"state_drift"`
+	HibernatedReplicas *int32 `json:"hibernated_replicas,omitempty"`
+}
+
+// Gets the details of a single deployment at /v1/deployments/{namespace}/{name}
+func V1Deployment(w http.ResponseWriter, r *http.Request, dLister appslisters.DeploymentLister, store state.StateStore) {
+	vars := mux.Vars(r)
+	namespace, name := vars["namespace"], vars["name"]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if err := validation.NamespacedName(namespace, "name", name); err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceDeployments, namespace, name)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to read deployment %s/%s", auth.FromRequest(r), namespace, name))
+			return
+		}
+		resp, err := getDeployment(r, dLister, store, namespace, name)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		responses.ReturnJsonResponse(w, 200, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Gets a deployment from the informer cache along with its state, reading the state doesn't change it
+func getDeployment(r *http.Request, dLister appslisters.DeploymentLister, store state.StateStore, namespace string, name string) (*getDeploymentResponse, error) {
+	d, err := dLister.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The API server defaults spec.replicas to 1, it is only nil on objects that weren't defaulted
+	var replicas int32 = 1
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	resp := &getDeploymentResponse{
+		Code:              200,
+		Deployment:        d.Name,
+		Namespace:         d.Namespace,
+		Replicas:          replicas,
+		ReadyReplicas:     d.Status.ReadyReplicas,
+		AvailableReplicas: d.Status.AvailableReplicas,
+		UpdatedReplicas:   d.Status.UpdatedReplicas,
+		Unavailable:       d.Status.UnavailableReplicas,
+		Strategy:          newStrategy(d.Spec.Strategy),
+		Selector:          formatSelector(d.Spec.Selector),
+		Containers:        newContainers(d.Spec.Template.Spec.Containers),
+		InitContainers:    newContainers(d.Spec.Template.Spec.InitContainers),
+		Conditions:        []deploymentCondition{},
+		Labels:            d.Labels,
+		Annotations:       d.Annotations,
+		CreatedAt:         d.CreationTimestamp.UTC(),
+		Age:               time.Since(d.CreationTimestamp.Time).Round(time.Second).String(),
+	}
+	for _, c := range d.Status.Conditions {
+		resp.Conditions = append(resp.Conditions, deploymentCondition{Type: string(c.Type), Status: string(c.Status), Reason: c.Reason, Message: c.Message,
+			LastUpdateTime: c.LastUpdateTime.UTC(), LastTransitionTime: c.LastTransitionTime.UTC()})
+	}
+
+	stored, tracked, err := store.Get(r.Context(), state.Key{Kind: state.KindDeployment, Namespace: namespace, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if tracked {
+		resp.State = deploymentState{Tracked: true, DesiredReplicas: &stored.DesiredReplicas, Drift: stored.Drift,
+			HibernatedReplicas: stored.HibernatedReplicas}
+	}
+
+	return resp, nil
+}
+
+// Gets the update strategy of a deployment, only rolling updates have limits
+func newStrategy(strategy appsv1.DeploymentStrategy) deploymentStrategy {
+	resp := deploymentStrategy{Type: string(strategy.Type)}
+	if strategy.RollingUpdate != nil {
+		if strategy.RollingUpdate.MaxSurge != nil {
+			resp.MaxSurge = strategy.RollingUpdate.MaxSurge.String()
+		}
+		if strategy.RollingUpdate.MaxUnavailable != nil {
+			resp.MaxUnavailable = strategy.RollingUpdate.MaxUnavailable.String()
+		}
+	}
+
+	return resp
+}
+
+// Formats a label selector the way kubectl takes it, empty if it selects nothing
+func formatSelector(selector *metav1.LabelSelector) string {
+	if selector == nil || len(selector.MatchLabels)+len(selector.MatchExpressions) == 0 {
+		return ""
+	}
+
+	return metav1.FormatLabelSelector(selector)
+}
+
+// Gets the names and images of the containers in a pod template
+func newContainers(containers []corev1.Container) []container {
+	resp := []container{}
+	for _, c := range containers {
+		resp = append(resp, container{Name: c.Name, Image: c.Image})
+	}
+
+	return resp
+}
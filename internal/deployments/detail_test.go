@@ -0,0 +1,174 @@
+package deployments
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/gorilla/mux"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Tests the HTTP GET endpoint for /v1/deployments/{namespace}/{name}
+func TestV1Deployment(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{
+		Subjects:    auth.Subjects{CommonNames: []string{"reader"}},
+		Namespaces:  []string{"test"},
+		Deployments: []string{"*"},
+		Verbs:       []string{auth.VerbRead},
+	}}}
+
+	replicas := int32(3)
+	created := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	transitioned := created.Add(time.Minute)
+	maxSurge, maxUnavailable := intstr.FromString("25%"), intstr.FromInt(0)
+	dLister := newTestLister(t,
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-deployment", Namespace: "test", CreationTimestamp: metav1.NewTime(created),
+				Labels: map[string]string{"app": "web"}, Annotations: map[string]string{"kube-server/max-replicas": "10"}},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Strategy: appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable}},
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "migrate", Image: "web:1.2.0"}},
+					Containers:     []corev1.Container{{Name: "web", Image: "web:1.2.0"}, {Name: "proxy", Image: "envoy:1.22"}},
+				}},
+			},
+			Status: appsv1.DeploymentStatus{ReadyReplicas: 2, AvailableReplicas: 2, UpdatedReplicas: 3, UnavailableReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated",
+					LastUpdateTime: metav1.NewTime(transitioned), LastTransitionTime: metav1.NewTime(transitioned)}}},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "untracked-deployment", Namespace: "test", CreationTimestamp: metav1.NewTime(created)}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other-deployment", Namespace: "other"}},
+	)
+	store := state.NewMemoryStore()
+	key := state.Key{Kind: state.KindDeployment, Namespace: "test", Name: "test-deployment"}
+	if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 4, CurrentReplicas: 3, Drift: true}); err != nil {
+		t.Fatal(err)
+	}
+	desired := int32(4)
+
+	testCases := []struct {
+		name             string
+		method           string
+		path             string
+		commonName       string
+		expectedCode     int
+		expectedResponse *getDeploymentResponse
+	}{
+		{
+			name:         "tracked",
+			method:       http.MethodGet,
+			path:         "/v1/deployments/test/test-deployment",
+			commonName:   "reader",
+			expectedCode: 200,
+			expectedResponse: &getDeploymentResponse{
+				Code: 200, Deployment: "test-deployment", Namespace: "test",
+				Replicas: 3, ReadyReplicas: 2, AvailableReplicas: 2, UpdatedReplicas: 3, Unavailable: 1,
+				Strategy:       deploymentStrategy{Type: "RollingUpdate", MaxSurge: "25%", MaxUnavailable: "0"},
+				Selector:       "app=web",
+				Containers:     []container{{Name: "web", Image: "web:1.2.0"}, {Name: "proxy", Image: "envoy:1.22"}},
+				InitContainers: []container{{Name: "migrate", Image: "web:1.2.0"}},
+				Conditions: []deploymentCondition{{Type: "Progressing", Status: "True", Reason: "ReplicaSetUpdated",
+					LastUpdateTime: transitioned, LastTransitionTime: transitioned}},
+				Labels:      map[string]string{"app": "web"},
+				Annotations: map[string]string{"kube-server/max-replicas": "10"},
+				CreatedAt:   created,
+				State:       deploymentState{Tracked: true, DesiredReplicas: &desired, Drift: true},
+			},
+		},
+		{
+			name:         "untracked",
+			method:       http.MethodGet,
+			path:         "/v1/deployments/test/untracked-deployment",
+			commonName:   "reader",
+			expectedCode: 200,
+			expectedResponse: &getDeploymentResponse{
+				Code: 200, Deployment: "untracked-deployment", Namespace: "test", Replicas: 1,
+				Containers: []container{}, Conditions: []deploymentCondition{}, CreatedAt: created,
+			},
+		},
+		{
+			name:         "missing",
+			method:       http.MethodGet,
+			path:         "/v1/deployments/test/missing-deployment",
+			commonName:   "reader",
+			expectedCode: 404,
+		},
+		{
+			name:         "unauthorized",
+			method:       http.MethodGet,
+			path:         "/v1/deployments/other/other-deployment",
+			commonName:   "reader",
+			expectedCode: 403,
+		},
+		{
+			name:         "invalid-name",
+			method:       http.MethodGet,
+			path:         "/v1/deployments/test/Test_Deployment",
+			commonName:   "reader",
+			expectedCode: 400,
+		},
+		{
+			name:         "method-not-allowed",
+			method:       http.MethodPost,
+			path:         "/v1/deployments/test/test-deployment",
+			commonName:   "reader",
+			expectedCode: 405,
+		},
+	}
+
+	router := mux.NewRouter()
+	router.Use(auth.Middleware(policy))
+	router.HandleFunc("/v1/deployments/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		V1Deployment(w, r, dLister, store)
+	})
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.commonName}}}}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			resp := &getDeploymentResponse{}
+			if err := json.Unmarshal(rr.Body.Bytes(), resp); err != nil {
+				t.Fatal(err)
+			}
+			// The age keeps growing, it only has to be about an hour
+			age, err := time.ParseDuration(resp.Age)
+			if err != nil || age < time.Hour || age > time.Hour+time.Minute {
+				t.Errorf("Fail: got age %q want about an hour", resp.Age)
+			}
+			resp.Age = ""
+
+			if !reflect.DeepEqual(resp, test.expectedResponse) {
+				t.Errorf("Fail: got %s", rr.Body.String())
+			} else {
+				t.Logf("test passed %s", rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package deployments
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	// k8s api packages
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Largest page a client can ask for
+const maxListLimit = 500
+
+// Orders the deployments can be listed in, prefixed with - for the reverse
+const (
+	sortNamespace = "namespace"
+	sortName      = "name"
+	sortAge       = "age"
+)
+
+// Fields the fieldSelector can match on, the same ones the API server supports for every resource
+const (
+	fieldName      = "metadata.name"
+	fieldNamespace = "metadata.namespace"
+)
+
+// How the client wants the deployments listed
+type listOptions struct {
+	Namespace     string
+	LabelSelector labels.Selector
+	FieldSelector fields.Selector
+	Sort          string
+	// Zero lists everything
+	Limit    int
+	Continue *continueToken
+}
+
+// Where the previous page ended, the next page starts right after this deployment
+// It is a position in the sort order rather than an offset, so deployments added or removed in between don't shift the pages
+// It holds no resourceVersion, every page is read from the cache as it is then rather than from a snapshot
+type continueToken struct {
+	Sort       string    `json:"sort"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment_name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Reads the namespace, labelSelector, fieldSelector, sort, limit and continue query parameters of a list request
+func parseListOptions(r *http.Request) (*listOptions, error) {
+	query := r.URL.Query()
+	opts := &listOptions{Namespace: query.Get("namespace"), LabelSelector: labels.Everything(), FieldSelector: fields.Everything(), Sort: sortNamespace}
+
+	var err error
+	if opts.Namespace != "" {
+		if err = validation.Namespace(opts.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if value := query.Get("labelSelector"); value != "" {
+		opts.LabelSelector, err = labels.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labelSelector: %s", err)
+		}
+	}
+	if value := query.Get("fieldSelector"); value != "" {
+		opts.FieldSelector, err = fields.ParseSelector(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fieldSelector: %s", err)
+		}
+		for _, requirement := range opts.FieldSelector.Requirements() {
+			if requirement.Field != fieldName && requirement.Field != fieldNamespace {
+				return nil, fmt.Errorf("fieldSelector only supports %s and %s, got %s", fieldName, fieldNamespace, requirement.Field)
+			}
+		}
+	}
+	if value := query.Get("sort"); value != "" {
+		switch strings.TrimPrefix(value, "-") {
+		case sortNamespace, sortName, sortAge:
+			opts.Sort = value
+		default:
+			return nil, fmt.Errorf("sort must be one of %s, %s or %s, optionally prefixed with -, got %q", sortNamespace, sortName, sortAge, value)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		opts.Limit, err = strconv.Atoi(value)
+		if err != nil || opts.Limit < 1 || opts.Limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be a number between 1 and %d, got %q", maxListLimit, value)
+		}
+	}
+	if value := query.Get("continue"); value != "" {
+		opts.Continue, err = decodeContinue(value)
+		if err != nil || opts.Continue.Sort != opts.Sort {
+			return nil, fmt.Errorf("invalid continue token, start again without it and keep the same sort between pages")
+		}
+	}
+
+	return opts, nil
+}
+
+// Checks a deployment matches the fieldSelector
+func matchesFields(selector fields.Selector, d DeployNamespace) bool {
+	return selector.Matches(fields.Set{fieldName: d.Deployment, fieldNamespace: d.Namespace})
+}
+
+// Sorts the deployments in the order the client asked for, ties are broken by namespace and name so the order is stable
+func sortDeployments(deployments []DeployNamespace, order string) {
+	sort.Slice(deployments, func(i, j int) bool {
+		return less(deployments[i], deployments[j], order)
+	})
+}
+
+// Checks if a deployment comes before another in the sort order
+func less(a DeployNamespace, b DeployNamespace, order string) bool {
+	if strings.HasPrefix(order, "-") {
+		return less(b, a, strings.TrimPrefix(order, "-"))
+	}
+
+	switch {
+	case order == sortAge && !a.CreatedAt.Equal(b.CreatedAt):
+		return a.CreatedAt.Before(b.CreatedAt)
+	case order == sortName && a.Deployment != b.Deployment:
+		return a.Deployment < b.Deployment
+	case a.Namespace != b.Namespace:
+		return a.Namespace < b.Namespace
+	default:
+		return a.Deployment < b.Deployment
+	}
+}
+
+// Cuts the page the client asked for out of the sorted deployments, returns the token for the next page if there is one
+func paginate(deployments []DeployNamespace, opts *listOptions) ([]DeployNamespace, string) {
+	if opts.Continue != nil {
+		last := DeployNamespace{Deployment: opts.Continue.Deployment, Namespace: opts.Continue.Namespace, CreatedAt: opts.Continue.CreatedAt}
+		start := sort.Search(len(deployments), func(i int) bool {
+			return less(last, deployments[i], opts.Sort)
+		})
+		deployments = deployments[start:]
+	}
+	if opts.Limit == 0 || len(deployments) <= opts.Limit {
+		return deployments, ""
+	}
+
+	page := deployments[:opts.Limit]
+	last := page[len(page)-1]
+	token := &continueToken{Sort: opts.Sort, Namespace: last.Namespace, Deployment: last.Deployment, CreatedAt: last.CreatedAt}
+
+	return page, encodeContinue(token)
+}
+
+// Encodes a continue token, it is opaque to clients
+func encodeContinue(token *continueToken) string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decodes a continue token from the previous page
+func decodeContinue(value string) (*continueToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &continueToken{}
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,165 @@
+package deployments
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+// Builds a deployment with labels created some hours ago
+func newListDeployment(namespace string, name string, hoursAgo int, labels map[string]string) runtime.Object {
+	created := time.Date(2022, 7, 12, 12, 0, 0, 0, time.UTC).Add(-time.Duration(hoursAgo) * time.Hour)
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels, CreationTimestamp: metav1.NewTime(created)}}
+}
+
+// Tests filtering, sorting and paging through /v1/deployments
+func TestV1DeploymentsList(t *testing.T) {
+	dLister := newTestLister(t,
+		newListDeployment("web", "frontend", 1, map[string]string{"app": "frontend", "tier": "web"}),
+		newListDeployment("web", "api", 5, map[string]string{"app": "api", "tier": "web"}),
+		newListDeployment("jobs", "worker", 3, map[string]string{"app": "worker", "tier": "batch"}),
+		newListDeployment("jobs", "api", 2, map[string]string{"app": "api", "tier": "batch"}),
+		newListDeployment("cache", "redis", 4, nil),
+	)
+	kClient := testclient.NewSimpleClientset()
+
+	testCases := []struct {
+		name          string
+		query         url.Values
+		expectedCode  int
+		expectedError string
+		expectedPages [][]string
+	}{
+		{
+			name:          "default",
+			query:         url.Values{},
+			expectedCode:  200,
+			expectedPages: [][]string{{"cache/redis", "jobs/api", "jobs/worker", "web/api", "web/frontend"}},
+		},
+		{
+			name:          "label-selector",
+			query:         url.Values{"labelSelector": {"tier in (web,batch),app!=worker"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"jobs/api", "web/api", "web/frontend"}},
+		},
+		{
+			name:          "field-selector",
+			query:         url.Values{"fieldSelector": {"metadata.name=api"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"jobs/api", "web/api"}},
+		},
+		{
+			name:          "sort-name",
+			query:         url.Values{"sort": {"name"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"jobs/api", "web/api", "web/frontend", "cache/redis", "jobs/worker"}},
+		},
+		{
+			name:          "sort-newest-first",
+			query:         url.Values{"sort": {"-age"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"web/frontend", "jobs/api", "jobs/worker", "cache/redis", "web/api"}},
+		},
+		{
+			name:          "pages",
+			query:         url.Values{"limit": {"2"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"cache/redis", "jobs/api"}, {"jobs/worker", "web/api"}, {"web/frontend"}},
+		},
+		{
+			name:          "pages-sorted-by-age",
+			query:         url.Values{"limit": {"3"}, "sort": {"age"}, "namespace": {"web"}},
+			expectedCode:  200,
+			expectedPages: [][]string{{"web/api", "web/frontend"}},
+		},
+		{
+			name:         "bad-label-selector",
+			query:        url.Values{"labelSelector": {"app in (api"}},
+			expectedCode: 400,
+		},
+		{
+			name:          "unsupported-field",
+			query:         url.Values{"fieldSelector": {"spec.replicas=1"}},
+			expectedCode:  400,
+			expectedError: "fieldSelector only supports metadata.name and metadata.namespace, got spec.replicas",
+		},
+		{
+			name:         "bad-sort",
+			query:        url.Values{"sort": {"replicas"}},
+			expectedCode: 400,
+		},
+		{
+			name:         "bad-limit",
+			query:        url.Values{"limit": {"1000"}},
+			expectedCode: 400,
+		},
+		{
+			name:         "invalid-namespace",
+			query:        url.Values{"namespace": {"Web_Apps"}},
+			expectedCode: 400,
+		},
+		{
+			name:         "bad-continue",
+			query:        url.Values{"continue": {"not-a-token"}},
+			expectedCode: 400,
+		},
+		{
+			name:         "continue-other-sort",
+			query:        url.Values{"continue": {encodeContinue(&continueToken{Sort: sortAge, Namespace: "web", Deployment: "api"})}},
+			expectedCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			query := test.query
+			var pages [][]string
+			for {
+				req := httptest.NewRequest(http.MethodGet, "/v1/deployments?"+query.Encode(), nil)
+				rr := httptest.NewRecorder()
+				V1Deployments(rr, req, dLister, kClient)
+
+				if rr.Code != test.expectedCode {
+					t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+				}
+				if rr.Code != http.StatusOK {
+					if !strings.Contains(rr.Body.String(), test.expectedError) {
+						t.Errorf("Fail: got %s want the error %q", rr.Body.String(), test.expectedError)
+					}
+					return
+				}
+
+				var resp getDeploymentsResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				var page []string
+				for _, d := range resp.Deployments {
+					page = append(page, d.Namespace+"/"+d.Deployment)
+				}
+				pages = append(pages, page)
+
+				if resp.Continue == "" || len(pages) > len(test.expectedPages) {
+					break
+				}
+				query.Set("continue", resp.Continue)
+			}
+
+			if !reflect.DeepEqual(pages, test.expectedPages) {
+				t.Errorf("Fail: got pages %v want %v", pages, test.expectedPages)
+			} else {
+				t.Logf("test passed %v", pages)
+			}
+		})
+	}
+}
@@ -11,9 +11,3 @@ func NonFatal() {
 		logger.Log.Errorf("Non-fatal error occurred: %v", err)
 	}
 }
-
-// Generic error struct to format a JSON response
-type GenericError struct {
-	Code    int    `json:"http_response_code"`
-	Message string `json:"message"`
-}
@@ -0,0 +1,164 @@
+package errors
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+
+	// Kubernetes packages
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Media type of error responses, see RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Prefix of the type of every problem, the rest names the kind of error and never changes so clients can switch on it
+const problemTypePrefix = "urn:kube-server:problem:"
+
+// A kind of error, every kind has its own type and title
+type Kind struct {
+	Type   string
+	Title  string
+	Status int
+}
+
+// Kinds of errors returned to clients
+var (
+	KindValidation          = Kind{Type: problemTypePrefix + "validation", Title: "The request is invalid", Status: http.StatusBadRequest}
+	KindForbidden           = Kind{Type: problemTypePrefix + "forbidden", Title: "The client is not allowed to do this", Status: http.StatusForbidden}
+	KindNotFound            = Kind{Type: problemTypePrefix + "not-found", Title: "The resource doesn't exist", Status: http.StatusNotFound}
+	KindMethodNotAllowed    = Kind{Type: problemTypePrefix + "method-not-allowed", Title: "The method isn't supported on this endpoint", Status: http.StatusMethodNotAllowed}
+	KindConflict            = Kind{Type: problemTypePrefix + "conflict", Title: "The resource changed since it was read", Status: http.StatusConflict}
+	KindPreconditionFailed  = Kind{Type: problemTypePrefix + "precondition-failed", Title: "The If-Match precondition doesn't hold", Status: http.StatusPreconditionFailed}
+	KindPolicyViolation     = Kind{Type: problemTypePrefix + "policy-violation", Title: "The request breaks a policy", Status: http.StatusUnprocessableEntity}
+	KindUpstreamUnavailable = Kind{Type: problemTypePrefix + "upstream-unavailable", Title: "A service kube-server depends on is unavailable", Status: http.StatusServiceUnavailable}
+	KindInternal            = Kind{Type: problemTypePrefix + "internal", Title: "Internal server error", Status: http.StatusInternalServerError}
+)
+
+// An error that can be shown to clients
+// Detail is written for the client, Err is the cause which is only logged
+type Error struct {
+	Kind   Kind
+	Status int
+	Detail string
+	Err    error
+	// Extra members of the problem, like the limits a scale broke
+	Extensions map[string]interface{}
+}
+
+func (err *Error) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("%s: %s", err.Detail, err.Err)
+	}
+
+	return err.Detail
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Creates an error of a kind with its usual status code
+func New(kind Kind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Status: kind.Status, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Creates an error for a request that is malformed or has invalid values
+func Validation(format string, args ...interface{}) *Error {
+	return New(KindValidation, format, args...)
+}
+
+// Creates an error for a client that isn't allowed to do something
+func Forbidden(format string, args ...interface{}) *Error {
+	return New(KindForbidden, format, args...)
+}
+
+// Creates an error for something that doesn't exist
+func NotFound(format string, args ...interface{}) *Error {
+	return New(KindNotFound, format, args...)
+}
+
+// Creates the error for a method an endpoint doesn't support
+func MethodNotAllowed() *Error {
+	return New(KindMethodNotAllowed, "method not allowed")
+}
+
+// Creates an error for something that changed since the client read it
+func Conflict(format string, args ...interface{}) *Error {
+	return New(KindConflict, format, args...)
+}
+
+// Creates the error for an If-Match that doesn't match anymore
+func PreconditionFailed(format string, args ...interface{}) *Error {
+	return New(KindPreconditionFailed, format, args...)
+}
+
+// Creates an error for a request that breaks a policy, like the replica limits
+func PolicyViolation(extensions map[string]interface{}, format string, args ...interface{}) *Error {
+	err := New(KindPolicyViolation, format, args...)
+	err.Extensions = extensions
+	return err
+}
+
+// Creates an error for a dependency like the Kubernetes API or the state store that can't be reached
+func UpstreamUnavailable(cause error, format string, args ...interface{}) *Error {
+	err := New(KindUpstreamUnavailable, format, args...)
+	err.Err = cause
+	return err
+}
+
+// Creates an error for anything unexpected, the cause is logged but never shown to the client
+func Internal(cause error) *Error {
+	err := New(KindInternal, "Internal server error")
+	err.Err = cause
+	return err
+}
+
+// Turns any error into one that can be shown to clients
+// Errors from the Kubernetes API keep their status but not their message, which can name the kube-server service account
+// Anything else is an internal error
+func From(err error) *Error {
+	var known *Error
+	if goerrors.As(err, &known) {
+		return known
+	}
+
+	var statusError k8serrors.APIStatus
+	if !goerrors.As(err, &statusError) {
+		return Internal(err)
+	}
+
+	status := statusError.Status()
+	resource := describe(status.Details)
+	var problem *Error
+	switch k8serrors.ReasonForError(err) {
+	case metav1.StatusReasonNotFound:
+		problem = NotFound("%s not found", resource)
+	case metav1.StatusReasonForbidden, metav1.StatusReasonUnauthorized:
+		problem = Forbidden("kube-server isn't allowed to access %s in Kubernetes", resource)
+	case metav1.StatusReasonConflict, metav1.StatusReasonAlreadyExists:
+		problem = Conflict("%s was changed by someone else, read it again and retry", resource)
+	case metav1.StatusReasonInvalid, metav1.StatusReasonBadRequest:
+		problem = Validation("%s", status.Message)
+	case metav1.StatusReasonTimeout, metav1.StatusReasonServerTimeout, metav1.StatusReasonTooManyRequests, metav1.StatusReasonServiceUnavailable:
+		problem = UpstreamUnavailable(nil, "the Kubernetes API is unavailable, try again later")
+	default:
+		return Internal(err)
+	}
+	problem.Err = err
+
+	return problem
+}
+
+// Names the resource a Kubernetes API error is about
+func describe(details *metav1.StatusDetails) string {
+	switch {
+	case details == nil || details.Kind == "":
+		return "the resource"
+	case details.Name == "":
+		return details.Kind
+	default:
+		return fmt.Sprintf("%s %q", details.Kind, details.Name)
+	}
+}
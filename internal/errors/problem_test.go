@@ -0,0 +1,104 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Tests errors are turned into the right kind of problem without leaking Kubernetes API messages
+func TestFrom(t *testing.T) {
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+	testCases := []struct {
+		name           string
+		err            error
+		expectedKind   Kind
+		expectedStatus int
+		expectedDetail string
+	}{
+		{
+			name:           "problem",
+			err:            Validation("replica_size must be positive"),
+			expectedKind:   KindValidation,
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "replica_size must be positive",
+		},
+		{
+			name:           "wrapped-problem",
+			err:            fmt.Errorf("scaling: %w", PreconditionFailed("changed")),
+			expectedKind:   KindPreconditionFailed,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedDetail: "changed",
+		},
+		{
+			name:           "not-found",
+			err:            k8serrors.NewNotFound(deployments, "web"),
+			expectedKind:   KindNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedDetail: `deployments "web" not found`,
+		},
+		{
+			name:           "forbidden",
+			err:            k8serrors.NewForbidden(deployments, "web", fmt.Errorf("User \"system:serviceaccount:kube-server:kube-server\" cannot patch")),
+			expectedKind:   KindForbidden,
+			expectedStatus: http.StatusForbidden,
+			expectedDetail: `kube-server isn't allowed to access deployments "web" in Kubernetes`,
+		},
+		{
+			name:           "conflict",
+			err:            k8serrors.NewConflict(deployments, "web", fmt.Errorf("the object has been modified")),
+			expectedKind:   KindConflict,
+			expectedStatus: http.StatusConflict,
+			expectedDetail: `deployments "web" was changed by someone else, read it again and retry`,
+		},
+		{
+			name:           "bad-request",
+			err:            k8serrors.NewBadRequest("replicas must be positive"),
+			expectedKind:   KindValidation,
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "replicas must be positive",
+		},
+		{
+			name:           "timeout",
+			err:            k8serrors.NewServerTimeout(deployments, "update", 1),
+			expectedKind:   KindUpstreamUnavailable,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDetail: "the Kubernetes API is unavailable, try again later",
+		},
+		{
+			name:           "internal-api-error",
+			err:            k8serrors.NewInternalError(fmt.Errorf("etcd is down")),
+			expectedKind:   KindInternal,
+			expectedStatus: http.StatusInternalServerError,
+			expectedDetail: "Internal server error",
+		},
+		{
+			name:           "other",
+			err:            fmt.Errorf("redis: connection refused"),
+			expectedKind:   KindInternal,
+			expectedStatus: http.StatusInternalServerError,
+			expectedDetail: "Internal server error",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			problem := From(test.err)
+
+			switch {
+			case problem.Kind != test.expectedKind:
+				t.Errorf("Fail: got type %q want %q", problem.Kind.Type, test.expectedKind.Type)
+			case problem.Status != test.expectedStatus:
+				t.Errorf("Fail: got status %d want %d", problem.Status, test.expectedStatus)
+			case problem.Detail != test.expectedDetail:
+				t.Errorf("Fail: got detail %q want %q", problem.Detail, test.expectedDetail)
+			default:
+				t.Logf("test passed %s", problem.Kind.Type)
+			}
+		})
+	}
+}
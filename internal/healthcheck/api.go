@@ -2,66 +2,152 @@ package healthcheck
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/leader"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
 	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
 
 	"k8s.io/client-go/kubernetes"
 )
 
+// Longest the readiness check waits on each dependency
+const readyzTimeout = 5 * time.Second
+
+// Statuses reported for each check
+const (
+	statusOK       = "ok"
+	statusFailed   = "failed"
+	statusDraining = "draining"
+)
+
 // Struct for the respons of the endpoint
 type getLivezResponse struct {
+	Code    int            `json:"http_response_code"`
+	Status  string         `json:"kubernetes_api_status"`
+	Version string         `json:"application_version"`
+	Leader  *leader.Status `json:"leader,omitempty"`
+}
+
+// Response of the /v1/livez endpoint, the process is up if it can answer at all
+type getProcessLivezResponse struct {
 	Code    int    `json:"http_response_code"`
-	Status  string `json:"kubernetes_api_status"`
+	Status  string `json:"status"`
 	Version string `json:"application_version"`
 }
 
-type errHealthCheckFailed error
+// Response of the /v1/readyz endpoint with the status of each dependency
+// Which replica runs the background work is informational, it doesn't affect readiness
+type getReadyzResponse struct {
+	Code       int            `json:"http_response_code"`
+	Status     string         `json:"status"`
+	Kubernetes string         `json:"kubernetes_api_status"`
+	StateStore string         `json:"state_store_status"`
+	Version    string         `json:"application_version"`
+	Leader     *leader.Status `json:"leader,omitempty"`
+}
 
-// API endpoint for checking the health of the cluster and application
-func V1HealthCheck(w http.ResponseWriter, r *http.Request, kClient kubernetes.Interface, Version string) {
-	// Catch fatal errors that would otherwise cause the server to quit
-	defer e.NonFatal()
+// Set once the server starts shutting down so load balancers stop sending it requests
+var draining int32
 
+// Marks the server as shutting down, /v1/readyz fails from now on while in-flight requests finish
+func StartDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// Checks if the server is shutting down
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// API endpoint for checking the health of the cluster and application
+func V1HealthCheck(w http.ResponseWriter, r *http.Request, kClient kubernetes.Interface, elector *leader.Elector, Version string) {
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
 		resp, err := getLivez(kClient, Version)
 		if err != nil {
-			// Handle specific error types
-			// TODO: implement a more scalable HTTP error handling system and DRY it up
-			switch err.(type) {
-			case errHealthCheckFailed:
-				logger.Log.Error(err)
-				responses.ReturnJsonResponse(w, 500, e.GenericError{Code: 500, Message: fmt.Sprint(err)})
-				return
-			default:
-				logger.Log.Error(err)
-				responses.ReturnJsonResponse(w, 500, e.GenericError{Code: 500, Message: "Internal server error"})
-			}
+			responses.ReturnError(w, err)
 			return
 		}
+		resp.Leader = elector.Status()
 		responses.ReturnJsonResponse(w, 200, resp)
 	default:
-		responses.ReturnJsonResponse(w, 405, e.GenericError{Code: 405, Message: "method not allowed"})
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// API endpoint for the liveness probe, only checks the process is serving requests
+// Dependencies are left to /v1/readyz so an outage of Redis or the API server doesn't restart every replica
+func V1Livez(w http.ResponseWriter, r *http.Request, Version string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		responses.ReturnJsonResponse(w, 200, &getProcessLivezResponse{Code: 200, Status: statusOK, Version: Version})
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// API endpoint for the readiness probe, checks the Kubernetes API and the state store can be reached
+func V1Readyz(w http.ResponseWriter, r *http.Request, kClient kubernetes.Interface, store state.StateStore, elector *leader.Elector, Version string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		resp := getReadyz(r.Context(), kClient, store, Version)
+		resp.Leader = elector.Status()
+		responses.ReturnJsonResponse(w, resp.Code, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
 	}
 }
 
 // Checks the livez endpoint of the cluster
 func getLivez(kClient kubernetes.Interface, Version string) (*getLivezResponse, error) {
-	defer e.NonFatal()
 	// Setting an int to capture the response code
 	var statusCode int
-	err := kClient.DiscoveryV1().RESTClient().Get().AbsPath("/livez").Do(context.TODO()).StatusCode(&statusCode)
-	fmt.Print(err)
+	err := kClient.DiscoveryV1().RESTClient().Get().AbsPath("/livez").Do(context.TODO()).StatusCode(&statusCode).Error()
+	if err != nil {
+		logger.Log.Debugf("error checking kubernetes API /livez: %s", err)
+	}
 
 	if statusCode != 200 {
-		err_message := "kubernetes API /livez check failed, cluster is unhealthy"
-		return &getLivezResponse{}, errHealthCheckFailed(errors.New(err_message))
+		return &getLivezResponse{}, e.UpstreamUnavailable(err, "kubernetes API /livez check failed, cluster is unhealthy")
 	}
 
 	return &getLivezResponse{Code: 200, Status: "ok", Version: Version}, nil
 }
+
+// Checks every dependency and reports not ready if any of them fails or the server is draining
+func getReadyz(ctx context.Context, kClient kubernetes.Interface, store state.StateStore, Version string) *getReadyzResponse {
+	ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+	defer cancel()
+
+	resp := &getReadyzResponse{Code: 200, Status: statusOK, Kubernetes: statusOK, StateStore: statusOK, Version: Version}
+
+	var statusCode int
+	err := kClient.DiscoveryV1().RESTClient().Get().AbsPath("/readyz").Do(ctx).StatusCode(&statusCode).Error()
+	if err != nil || statusCode != 200 {
+		logger.Log.Errorf("readiness check of the kubernetes API failed with status %d: %v", statusCode, err)
+		resp.Kubernetes = statusFailed
+	}
+
+	err = store.Ping(ctx)
+	if err != nil {
+		logger.Log.Errorf("readiness check of the state store failed: %s", err)
+		resp.StateStore = statusFailed
+	}
+
+	switch {
+	case isDraining():
+		resp.Status = statusDraining
+	case resp.Kubernetes != statusOK || resp.StateStore != statusOK:
+		resp.Status = statusFailed
+	}
+	if resp.Status != statusOK {
+		resp.Code = 503
+	}
+
+	return resp
+}
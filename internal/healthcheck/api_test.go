@@ -1,9 +1,20 @@
 package healthcheck
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/taylorsmcclure/kube-server/internal/leader"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // I don't like being dependent on the internal package, but
@@ -12,7 +23,159 @@ func init() {
 	logger.Setup(false)
 }
 
+// State store whose ping always fails, like Redis being down
+type unreachableStore struct {
+	*state.MemoryStore
+}
+
+func (s unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+// Builds a clientset talking to a fake API server answering health checks with the given status
+func newTestClient(t *testing.T, apiStatus int) kubernetes.Interface {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(apiStatus)
+	}))
+	t.Cleanup(server.Close)
+
+	kClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kClient
+}
+
 // Tests the /v1/healthz endpoint
 func TestV1HealthCheck(t *testing.T) {
-	t.Log("TODO: Implement healthcheck endpoint testing")
+	testCases := []struct {
+		name         string
+		apiStatus    int
+		expectedCode int
+	}{
+		{name: "cluster-healthy", apiStatus: 200, expectedCode: 200},
+		{name: "cluster-unhealthy", apiStatus: 500, expectedCode: 503},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kClient := newTestClient(t, test.apiStatus)
+			rr := httptest.NewRecorder()
+			V1HealthCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/healthz", nil), kClient, nil, "test")
+
+			if rr.Code != test.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			}
+		})
+	}
+}
+
+// Tests the /v1/livez endpoint doesn't depend on anything
+func TestV1Livez(t *testing.T) {
+	rr := httptest.NewRecorder()
+	V1Livez(rr, httptest.NewRequest(http.MethodGet, "/v1/livez", nil), "test")
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+// Tests the /v1/readyz endpoint checks the Kubernetes API and the state store
+func TestV1Readyz(t *testing.T) {
+	testCases := []struct {
+		name             string
+		apiStatus        int
+		store            state.StateStore
+		draining         bool
+		expectedResponse getReadyzResponse
+	}{
+		{
+			name:             "ready",
+			apiStatus:        200,
+			store:            state.NewMemoryStore(),
+			expectedResponse: getReadyzResponse{Code: 200, Status: statusOK, Kubernetes: statusOK, StateStore: statusOK, Version: "test"},
+		},
+		{
+			name:             "kubernetes-down",
+			apiStatus:        503,
+			store:            state.NewMemoryStore(),
+			expectedResponse: getReadyzResponse{Code: 503, Status: statusFailed, Kubernetes: statusFailed, StateStore: statusOK, Version: "test"},
+		},
+		{
+			name:             "store-down",
+			apiStatus:        200,
+			store:            unreachableStore{state.NewMemoryStore()},
+			expectedResponse: getReadyzResponse{Code: 503, Status: statusFailed, Kubernetes: statusOK, StateStore: statusFailed, Version: "test"},
+		},
+		{
+			name:             "draining",
+			apiStatus:        200,
+			store:            state.NewMemoryStore(),
+			draining:         true,
+			expectedResponse: getReadyzResponse{Code: 503, Status: statusDraining, Kubernetes: statusOK, StateStore: statusOK, Version: "test"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if test.draining {
+				StartDraining()
+				t.Cleanup(func() { draining = 0 })
+			}
+
+			kClient := newTestClient(t, test.apiStatus)
+			rr := httptest.NewRecorder()
+			V1Readyz(rr, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil), kClient, test.store, nil, "test")
+
+			var resp getReadyzResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case rr.Code != test.expectedResponse.Code:
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedResponse.Code)
+			case resp != test.expectedResponse:
+				t.Errorf("Fail: got %v want %v", resp, test.expectedResponse)
+			}
+		})
+	}
+}
+
+// Tests the health and readiness output report the leader
+func TestLeaderStatus(t *testing.T) {
+	elector := leader.NewStandalone("replica-0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go elector.Run(ctx)
+	deadline := time.Now().Add(time.Second)
+	for !elector.Status().IsLeader && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	kClient := newTestClient(t, 200)
+	expected := leader.Status{Identity: "replica-0", Leader: "replica-0", IsLeader: true}
+
+	rr := httptest.NewRecorder()
+	V1HealthCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/healthz", nil), kClient, elector, "test")
+	var healthz getLivezResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &healthz); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	V1Readyz(rr, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil), kClient, state.NewMemoryStore(), elector, "test")
+	var readyz getReadyzResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &readyz); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case healthz.Leader == nil || *healthz.Leader != expected:
+		t.Errorf("Fail: got healthz leader %v want %v", healthz.Leader, expected)
+	case readyz.Leader == nil || *readyz.Leader != expected:
+		t.Errorf("Fail: got readyz leader %v want %v", readyz.Leader, expected)
+	default:
+		t.Logf("test passed %v", expected)
+	}
 }
@@ -0,0 +1,154 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	// Kubernetes packages
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timings of the Lease, the same defaults the Kubernetes controllers use
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// Who holds the Lease as far as this replica knows
+type Status struct {
+	// Namespace/name of the Lease, empty when a single replica runs without one
+	Lease    string `json:"lease,omitempty"`
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"is_leader"`
+}
+
+// Lease-based leader election shared by the background work of a replica, so it runs on exactly one of them
+type Elector struct {
+	kClient   kubernetes.Interface
+	namespace string
+	name      string
+	identity  string
+
+	mu     sync.Mutex
+	leader string
+	// Context of the current term, nil while this replica isn't the leader
+	term  context.Context
+	tasks []func(ctx context.Context)
+}
+
+// Creates an elector competing for the Lease with the other replicas, it does nothing until Run is called
+func NewElector(kClient kubernetes.Interface, namespace string, name string, identity string) *Elector {
+	return &Elector{kClient: kClient, namespace: namespace, name: name, identity: identity}
+}
+
+// Creates an elector for a lone replica that is always the leader without a Lease
+func NewStandalone(identity string) *Elector {
+	return &Elector{identity: identity}
+}
+
+// Runs fn in the background every time this replica becomes the leader, the context of fn is cancelled when it stops being the leader
+// Hooks can be added before or after Run, a hook added while leading starts straight away
+func (e *Elector) RunWhileLeader(fn func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.tasks = append(e.tasks, fn)
+	if e.term != nil {
+		go fn(e.term)
+	}
+}
+
+// Competes for the Lease until ctx is cancelled, getting it back whenever it is lost
+func (e *Elector) Run(ctx context.Context) {
+	if e.kClient == nil {
+		e.startLeading(ctx)
+		<-ctx.Done()
+		e.stopLeading()
+		return
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: e.name, Namespace: e.namespace},
+		Client:     e.kClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.identity},
+	}
+
+	logger.Log.Infof("Waiting to become the leader for Lease %s/%s as %s", e.namespace, e.name, e.identity)
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			Name:          e.name,
+			LeaseDuration: leaseDuration,
+			RenewDeadline: renewDeadline,
+			RetryPeriod:   retryPeriod,
+			// The hooks may still be scaling when we shut down, so the next leader waits for the Lease to expire instead
+			ReleaseOnCancel: false,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					logger.Log.Infof("Became the leader for Lease %s/%s", e.namespace, e.name)
+					e.startLeading(ctx)
+				},
+				OnStoppedLeading: func() {
+					logger.Log.Infof("No longer the leader for Lease %s/%s", e.namespace, e.name)
+					e.stopLeading()
+				},
+				OnNewLeader: func(leader string) {
+					if leader != e.identity {
+						logger.Log.Infof("%s is the leader for Lease %s/%s", leader, e.namespace, e.name)
+					}
+					e.mu.Lock()
+					defer e.mu.Unlock()
+					e.leader = leader
+				},
+			},
+		})
+	}
+}
+
+// Reports who holds the Lease, a nil elector reports nothing
+func (e *Elector) Status() *Status {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := &Status{Identity: e.identity, Leader: e.leader, IsLeader: e.term != nil}
+	if e.kClient != nil {
+		status.Lease = e.namespace + "/" + e.name
+	}
+
+	return status
+}
+
+// Starts every hook for a new term
+func (e *Elector) startLeading(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.term = ctx
+	e.leader = e.identity
+	for _, fn := range e.tasks {
+		go fn(ctx)
+	}
+}
+
+// Ends the term, the hooks see their context cancelled
+func (e *Elector) stopLeading() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.term = nil
+	if e.leader == e.identity {
+		e.leader = ""
+	}
+}
@@ -0,0 +1,109 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+// Tests only the replica holding the Lease runs the hooks and both know who the leader is
+func TestElector(t *testing.T) {
+	fakeClientset := testclient.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leading := make(chan string, 2)
+	electors := map[string]*Elector{}
+	for _, identity := range []string{"replica-0", "replica-1"} {
+		identity := identity
+		elector := NewElector(fakeClientset, "kube-server", "kube-server", identity)
+		elector.RunWhileLeader(func(ctx context.Context) {
+			leading <- identity
+			<-ctx.Done()
+		})
+		electors[identity] = elector
+		go elector.Run(ctx)
+	}
+
+	var leader string
+	select {
+	case leader = <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fail: no replica became the leader")
+	}
+
+	// The other replica keeps waiting while the Lease is held, and sees who holds it
+	select {
+	case other := <-leading:
+		t.Fatalf("Fail: %s and %s both became the leader", leader, other)
+	case <-time.After(2 * retryPeriod):
+	}
+
+	// Hooks added while leading start straight away
+	late := make(chan struct{})
+	electors[leader].RunWhileLeader(func(ctx context.Context) {
+		close(late)
+	})
+	select {
+	case <-late:
+	case <-time.After(time.Second):
+		t.Errorf("Fail: hook added while leading didn't run")
+	}
+
+	for identity, elector := range electors {
+		status := elector.Status()
+		switch {
+		case status.Leader != leader:
+			t.Errorf("Fail: %s got leader %q want %q", identity, status.Leader, leader)
+		case status.IsLeader != (identity == leader):
+			t.Errorf("Fail: %s got is_leader %v", identity, status.IsLeader)
+		case status.Lease != "kube-server/kube-server":
+			t.Errorf("Fail: %s got lease %q", identity, status.Lease)
+		default:
+			t.Logf("test passed %v", status)
+		}
+	}
+}
+
+// Tests a standalone replica is always the leader and stops its hooks on shutdown
+func TestStandalone(t *testing.T) {
+	elector := NewStandalone("replica-0")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	elector.RunWhileLeader(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	go elector.Run(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for !elector.Status().IsLeader && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := elector.Status()
+	if !status.IsLeader || status.Leader != "replica-0" || status.Lease != "" {
+		t.Errorf("Fail: got %v want the standalone replica to lead without a Lease", status)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("Fail: hook still running after shutdown")
+	}
+
+	if status := (*Elector)(nil).Status(); status != nil {
+		t.Errorf("Fail: got %v from a nil elector want nil", status)
+	}
+}
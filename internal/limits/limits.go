@@ -0,0 +1,235 @@
+package limits
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Annotations on a workload limiting its replicas, they can only make the limits file stricter
+const (
+	AnnotationMinReplicas = "kube-server/min-replicas"
+	AnnotationMaxReplicas = "kube-server/max-replicas"
+	AnnotationMaxStep     = "kube-server/max-step"
+)
+
+// Limits a scale can violate
+const (
+	// Fewest replicas a workload can be scaled to
+	LimitMinReplicas = "min-replicas"
+	// Most replicas a workload can be scaled to
+	LimitMaxReplicas = "max-replicas"
+	// Most replicas a single scale can add or remove
+	LimitMaxStep = "max-step"
+)
+
+// Source of the limit nobody can configure, a workload can't have fewer than 0 replicas
+const sourceBuiltIn = "built-in"
+
+// Replica limits file, every rule matching a workload applies and the strictest limit wins
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Limits the replicas of the workloads in the namespaces matched by the rule
+// Leaving out namespaces matches every namespace, leaving out deployments, statefulsets and resources matches every workload
+// Resources are keyed by resource and group like "rollouts.argoproj.io"
+// Namespaces and names support glob patterns like "team-*"
+type Rule struct {
+	Name         string              `json:"name"`
+	Namespaces   []string            `json:"namespaces"`
+	Deployments  []string            `json:"deployments"`
+	StatefulSets []string            `json:"statefulsets"`
+	Resources    map[string][]string `json:"resources"`
+	MinReplicas  *int32              `json:"minReplicas"`
+	MaxReplicas  *int32              `json:"maxReplicas"`
+	MaxStep      *int32              `json:"maxStep"`
+}
+
+// Checks if the rule applies to the named resource in the namespace
+func (r Rule) matches(resource string, namespace string, name string) bool {
+	if len(r.Namespaces) > 0 && !matchesAny(r.Namespaces, namespace) {
+		return false
+	}
+	if len(r.Deployments) == 0 && len(r.StatefulSets) == 0 && len(r.Resources) == 0 {
+		return true
+	}
+
+	switch resource {
+	case auth.ResourceDeployments:
+		return matchesAny(r.Deployments, name)
+	case auth.ResourceStatefulSets:
+		return matchesAny(r.StatefulSets, name)
+	default:
+		return matchesAny(r.Resources[resource], name)
+	}
+}
+
+// Loads a YAML or JSON limits file and validates it
+func LoadPolicy(policyPath string) (*Policy, error) {
+	raw, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	err = yaml.UnmarshalStrict(raw, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing limits %s: %w", policyPath, err)
+	}
+
+	for i, rule := range policy.Rules {
+		for _, value := range []*int32{rule.MinReplicas, rule.MaxReplicas, rule.MaxStep} {
+			if value != nil && *value < 0 {
+				return nil, fmt.Errorf("rule %d (%s) has a negative limit", i, rule.Name)
+			}
+		}
+		if rule.MinReplicas != nil && rule.MaxReplicas != nil && *rule.MinReplicas > *rule.MaxReplicas {
+			return nil, fmt.Errorf("rule %d (%s) has minReplicas above maxReplicas", i, rule.Name)
+		}
+		patterns := append(append(append([]string{}, rule.Namespaces...), rule.Deployments...), rule.StatefulSets...)
+		for _, names := range rule.Resources {
+			patterns = append(patterns, names...)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d (%s) has invalid pattern %q", i, rule.Name, pattern)
+			}
+		}
+	}
+
+	logger.Log.Infof("Loaded replica limits with %d rules from : %s", len(policy.Rules), policyPath)
+
+	return &policy, nil
+}
+
+// A limit a scale broke and where it came from
+type Violation struct {
+	Limit     string `json:"limit"`
+	Source    string `json:"source"`
+	Value     int32  `json:"value"`
+	Requested int32  `json:"requested"`
+	Message   string `json:"message"`
+}
+
+// Error listing every limit a scale broke
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return "scale rejected by replica limits: " + strings.Join(messages, ", ")
+}
+
+// The strictest value of a limit and where it came from
+type limit struct {
+	value  int32
+	source string
+}
+
+// Keeps the lowest value of a limit, or the highest one with keepHighest
+func tighten(current *limit, value int32, source string, keepHighest bool) *limit {
+	if current == nil || (keepHighest && value > current.value) || (!keepHighest && value < current.value) {
+		return &limit{value: value, source: source}
+	}
+
+	return current
+}
+
+// Checks a scale of the named resource from current to requested replicas against the policy and the annotations of the workload
+// A nil policy only checks the annotations, the returned error is an *Error listing every limit that was broken
+func (p *Policy) Check(resource string, namespace string, name string, annotations map[string]string, current int32, requested int32) error {
+	minReplicas := &limit{value: 0, source: sourceBuiltIn}
+	var maxReplicas, maxStep *limit
+
+	if p != nil {
+		for _, rule := range p.Rules {
+			if !rule.matches(resource, namespace, name) {
+				continue
+			}
+			source := fmt.Sprintf("rule %s", rule.Name)
+			if rule.MinReplicas != nil {
+				minReplicas = tighten(minReplicas, *rule.MinReplicas, source, true)
+			}
+			if rule.MaxReplicas != nil {
+				maxReplicas = tighten(maxReplicas, *rule.MaxReplicas, source, false)
+			}
+			if rule.MaxStep != nil {
+				maxStep = tighten(maxStep, *rule.MaxStep, source, false)
+			}
+		}
+	}
+
+	if value, ok := annotationLimit(annotations, AnnotationMinReplicas); ok {
+		minReplicas = tighten(minReplicas, value, "annotation "+AnnotationMinReplicas, true)
+	}
+	if value, ok := annotationLimit(annotations, AnnotationMaxReplicas); ok {
+		maxReplicas = tighten(maxReplicas, value, "annotation "+AnnotationMaxReplicas, false)
+	}
+	if value, ok := annotationLimit(annotations, AnnotationMaxStep); ok {
+		maxStep = tighten(maxStep, value, "annotation "+AnnotationMaxStep, false)
+	}
+
+	var violations []Violation
+	if requested < minReplicas.value {
+		violations = append(violations, Violation{Limit: LimitMinReplicas, Source: minReplicas.source, Value: minReplicas.value, Requested: requested,
+			Message: fmt.Sprintf("%d replicas is below the minimum of %d from %s", requested, minReplicas.value, minReplicas.source)})
+	}
+	if maxReplicas != nil && requested > maxReplicas.value {
+		violations = append(violations, Violation{Limit: LimitMaxReplicas, Source: maxReplicas.source, Value: maxReplicas.value, Requested: requested,
+			Message: fmt.Sprintf("%d replicas is above the maximum of %d from %s", requested, maxReplicas.value, maxReplicas.source)})
+	}
+	step := requested - current
+	if step < 0 {
+		step = -step
+	}
+	if maxStep != nil && step > maxStep.value {
+		violations = append(violations, Violation{Limit: LimitMaxStep, Source: maxStep.source, Value: maxStep.value, Requested: requested,
+			Message: fmt.Sprintf("scaling from %d to %d replicas is a step of %d, above the maximum of %d from %s", current, requested, step, maxStep.value, maxStep.source)})
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+
+	return nil
+}
+
+// Reads a limit from the annotations of a workload, invalid values are ignored like an invalid reconcile mode
+func annotationLimit(annotations map[string]string, annotation string) (int32, bool) {
+	raw, ok := annotations[annotation]
+	if !ok {
+		return 0, false
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil || value < 0 {
+		logger.Log.Warnf("invalid %s annotation %q, ignoring it", annotation, raw)
+		return 0, false
+	}
+
+	return int32(value), true
+}
+
+// Checks if the name matches any of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
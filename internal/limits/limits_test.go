@@ -0,0 +1,252 @@
+package limits
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+const testPolicy = `
+rules:
+  - name: everything
+    maxReplicas: 50
+  - name: team-a
+    namespaces: ["team-a-*"]
+    maxReplicas: 10
+    maxStep: 5
+  - name: team-a-web
+    namespaces: ["team-a-prod"]
+    deployments: ["web"]
+    minReplicas: 2
+  - name: cache
+    statefulsets: ["cache"]
+    maxStep: 1
+`
+
+// Writes the test limits to a temp file and loads them
+func loadTestPolicy(t *testing.T, policy string) (*Policy, error) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	if err := os.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadPolicy(path)
+}
+
+// Tests which scales the limits and annotations reject and why
+func TestCheck(t *testing.T) {
+	policy, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		policy      *Policy
+		resource    string
+		namespace   string
+		workload    string
+		annotations map[string]string
+		current     int32
+		requested   int32
+		expected    []string
+		expectedSrc []string
+	}{
+		{
+			name:      "within-limits",
+			policy:    policy,
+			namespace: "team-a-prod",
+			workload:  "web",
+			current:   3,
+			requested: 6,
+		},
+		{
+			name:        "negative-without-policy",
+			namespace:   "test",
+			workload:    "web",
+			current:     3,
+			requested:   -1,
+			expected:    []string{LimitMinReplicas},
+			expectedSrc: []string{sourceBuiltIn},
+		},
+		{
+			name:        "absurd-value",
+			policy:      policy,
+			namespace:   "other",
+			workload:    "web",
+			current:     3,
+			requested:   100000,
+			expected:    []string{LimitMaxReplicas},
+			expectedSrc: []string{"rule everything"},
+		},
+		{
+			name:        "strictest-rule-wins",
+			policy:      policy,
+			namespace:   "team-a-dev",
+			workload:    "web",
+			current:     3,
+			requested:   20,
+			expected:    []string{LimitMaxReplicas, LimitMaxStep},
+			expectedSrc: []string{"rule team-a", "rule team-a"},
+		},
+		{
+			name:        "named-minimum",
+			policy:      policy,
+			namespace:   "team-a-prod",
+			workload:    "web",
+			current:     3,
+			requested:   1,
+			expected:    []string{LimitMinReplicas},
+			expectedSrc: []string{"rule team-a-web"},
+		},
+		{
+			name:      "other-deployment",
+			policy:    policy,
+			namespace: "team-a-prod",
+			workload:  "worker",
+			current:   3,
+			requested: 1,
+		},
+		{
+			name:        "statefulset",
+			policy:      policy,
+			resource:    auth.ResourceStatefulSets,
+			namespace:   "test",
+			workload:    "cache",
+			current:     3,
+			requested:   5,
+			expected:    []string{LimitMaxStep},
+			expectedSrc: []string{"rule cache"},
+		},
+		{
+			name:      "deployment-named-like-statefulset",
+			policy:    policy,
+			namespace: "test",
+			workload:  "cache",
+			current:   3,
+			requested: 5,
+		},
+		{
+			name:        "annotations",
+			namespace:   "test",
+			workload:    "web",
+			annotations: map[string]string{AnnotationMinReplicas: "2", AnnotationMaxReplicas: "4"},
+			current:     3,
+			requested:   8,
+			expected:    []string{LimitMaxReplicas},
+			expectedSrc: []string{"annotation " + AnnotationMaxReplicas},
+		},
+		{
+			name:        "annotation-stricter-than-rule",
+			policy:      policy,
+			namespace:   "team-a-prod",
+			workload:    "web",
+			annotations: map[string]string{AnnotationMaxStep: "1"},
+			current:     3,
+			requested:   5,
+			expected:    []string{LimitMaxStep},
+			expectedSrc: []string{"annotation " + AnnotationMaxStep},
+		},
+		{
+			name:        "annotation-looser-than-rule",
+			policy:      policy,
+			namespace:   "team-a-prod",
+			workload:    "web",
+			annotations: map[string]string{AnnotationMaxReplicas: "100"},
+			current:     3,
+			requested:   20,
+			expected:    []string{LimitMaxReplicas, LimitMaxStep},
+			expectedSrc: []string{"rule team-a", "rule team-a"},
+		},
+		{
+			name:        "invalid-annotation",
+			namespace:   "test",
+			workload:    "web",
+			annotations: map[string]string{AnnotationMaxReplicas: "lots"},
+			current:     3,
+			requested:   100,
+		},
+		{
+			name:        "scale-down-step",
+			namespace:   "test",
+			workload:    "web",
+			annotations: map[string]string{AnnotationMaxStep: "2"},
+			current:     10,
+			requested:   7,
+			expected:    []string{LimitMaxStep},
+			expectedSrc: []string{"annotation " + AnnotationMaxStep},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			resource := test.resource
+			if resource == "" {
+				resource = auth.ResourceDeployments
+			}
+
+			var got, gotSrc []string
+			err := test.policy.Check(resource, test.namespace, test.workload, test.annotations, test.current, test.requested)
+			if err != nil {
+				limitsError, ok := err.(*Error)
+				if !ok {
+					t.Fatalf("Fail: got %T want *Error", err)
+				}
+				for _, violation := range limitsError.Violations {
+					got = append(got, violation.Limit)
+					gotSrc = append(gotSrc, violation.Source)
+				}
+			}
+
+			switch {
+			case !reflect.DeepEqual(got, test.expected):
+				t.Errorf("Fail: got violations %v want %v", got, test.expected)
+			case !reflect.DeepEqual(gotSrc, test.expectedSrc):
+				t.Errorf("Fail: got sources %v want %v", gotSrc, test.expectedSrc)
+			}
+		})
+	}
+}
+
+// Tests that invalid limits are rejected when loading
+func TestLoadPolicyInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+	}{
+		{
+			name:   "negative",
+			policy: "rules:\n  - name: bad\n    maxStep: -1\n",
+		},
+		{
+			name:   "min-above-max",
+			policy: "rules:\n  - name: bad\n    minReplicas: 5\n    maxReplicas: 2\n",
+		},
+		{
+			name:   "bad-pattern",
+			policy: "rules:\n  - name: bad\n    namespaces: [\"[\"]\n",
+		},
+		{
+			name:   "unknown-field",
+			policy: "rules:\n  - name: bad\n    maxReplica: 5\n",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := loadTestPolicy(t, test.policy); err == nil {
+				t.Errorf("Fail: expected an error loading the limits")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RoundTripper timing every request the Kubernetes client makes
+type kubernetesTransport struct {
+	next http.RoundTripper
+}
+
+// Wraps the transport of the Kubernetes client, meant for rest.Config.Wrap
+func WrapKubernetesTransport(next http.RoundTripper) http.RoundTripper {
+	return &kubernetesTransport{next: next}
+}
+
+// Times the request and counts it by status code
+// Watches are counted when their response starts, not when they end
+func (t *kubernetesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	kubernetesDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	kubernetesRequests.WithLabelValues(req.Method, code).Inc()
+
+	return resp, err
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+// Tests the tracked and drifted gauges are read from the state store
+func TestStateCollector(t *testing.T) {
+	store := state.NewMemoryStore()
+	values := map[state.Key]*state.Value{
+		{Namespace: "test", Name: "in-sync"}:  {DesiredReplicas: 2, CurrentReplicas: 2},
+		{Namespace: "test", Name: "drifted"}:  {DesiredReplicas: 2, CurrentReplicas: 1, Drift: true},
+		{Namespace: "other", Name: "drifted"}: {DesiredReplicas: 0, CurrentReplicas: 3, Drift: true},
+	}
+	for key, value := range values {
+		if err := store.Set(context.TODO(), key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&stateCollector{store: store})
+
+	expected := `
+# HELP kube_server_drifted_deployments Tracked workloads whose replicas don't match the desired replicas according to the state store.
+# TYPE kube_server_drifted_deployments gauge
+kube_server_drifted_deployments 2
+# HELP kube_server_state_scrape_error 1 if reading the state store for the tracked and drifted gauges failed.
+# TYPE kube_server_state_scrape_error gauge
+kube_server_state_scrape_error 0
+# HELP kube_server_tracked_deployments Deployments and StatefulSets with a desired replica count in the state store.
+# TYPE kube_server_tracked_deployments gauge
+kube_server_tracked_deployments 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+// Tests Kubernetes requests are counted by status code
+func TestKubernetesTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	before := testutil.ToFloat64(kubernetesRequests.WithLabelValues(http.MethodGet, "404"))
+	client := &http.Client{Transport: WrapKubernetesTransport(http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/apis/apps/v1/deployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(kubernetesRequests.WithLabelValues(http.MethodGet, "404")) - before; got != 1 {
+		t.Errorf("Fail: got %v requests want 1", got)
+	}
+}
+
+// Tests failed Redis commands are counted but missing keys are not
+func TestRedisHook(t *testing.T) {
+	missing := redis.NewStringCmd(context.TODO(), "get", "missing")
+	missing.SetErr(redis.Nil)
+	broken := redis.NewStringCmd(context.TODO(), "get", "broken")
+	broken.SetErr(errors.New("connection reset"))
+
+	before := testutil.ToFloat64(redisErrors.WithLabelValues("get"))
+	// The redismock hook short-circuits the hooks added after it, so run ours by hand
+	hook := RedisHook{}
+	for _, cmd := range []redis.Cmder{missing, broken} {
+		ctx, err := hook.BeforeProcess(context.TODO(), cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := hook.AfterProcess(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := testutil.ToFloat64(redisErrors.WithLabelValues("get")) - before; got != 1 {
+		t.Errorf("Fail: got %v errors want 1", got)
+	}
+}
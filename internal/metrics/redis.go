@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Context key for the start time of a Redis command
+type startKey struct{}
+
+// Redis hook timing every command and counting the failed ones
+type RedisHook struct{}
+
+// Stores the start time of the command
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+// Records the latency and error of the command
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+// Stores the start time of the pipeline
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+// Records the pipeline as a single command, failing if any of its commands failed
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	observeRedis(ctx, "pipeline", err)
+	return nil
+}
+
+// Records a command, redis.Nil only means the key is missing
+func observeRedis(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		redisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && err != redis.Nil {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
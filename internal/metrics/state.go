@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Longest a scrape may spend reading the state store
+const stateScrapeTimeout = 5 * time.Second
+
+var (
+	trackedDesc = prometheus.NewDesc(namespace+"_tracked_deployments",
+		"Deployments and StatefulSets with a desired replica count in the state store.", nil, nil)
+	driftDesc = prometheus.NewDesc(namespace+"_drifted_deployments",
+		"Tracked workloads whose replicas don't match the desired replicas according to the state store.", nil, nil)
+	stateErrorDesc = prometheus.NewDesc(namespace+"_state_scrape_error",
+		"1 if reading the state store for the tracked and drifted gauges failed.", nil, nil)
+)
+
+// Collector reading the tracked and drifted deployments from the state store on every scrape
+type stateCollector struct {
+	store state.StateStore
+}
+
+// Registers the tracked and drifted deployment gauges for the state store
+func RegisterStateStore(store state.StateStore) {
+	Registry.MustRegister(&stateCollector{store: store})
+}
+
+// Describes the gauges
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- trackedDesc
+	ch <- driftDesc
+	ch <- stateErrorDesc
+}
+
+// Counts the tracked deployments and how many of them are in drift
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateScrapeTimeout)
+	defer cancel()
+
+	tracked, drifted, err := c.count(ctx)
+	scrapeError := 0.0
+	if err != nil {
+		logger.Log.Errorf("error reading state store for metrics: %s", err)
+		scrapeError = 1
+	} else {
+		ch <- prometheus.MustNewConstMetric(trackedDesc, prometheus.GaugeValue, float64(tracked))
+		ch <- prometheus.MustNewConstMetric(driftDesc, prometheus.GaugeValue, float64(drifted))
+	}
+	ch <- prometheus.MustNewConstMetric(stateErrorDesc, prometheus.GaugeValue, scrapeError)
+}
+
+// Walks the state store, keys removed since they were listed are skipped
+func (c *stateCollector) count(ctx context.Context) (int, int, error) {
+	keys, err := c.store.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var tracked, drifted int
+	for _, key := range keys {
+		value, exists, err := c.store.Get(ctx, key)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !exists {
+			continue
+		}
+		tracked++
+		if value.Drift {
+			drifted++
+		}
+	}
+
+	return tracked, drifted, nil
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Wraps a ResponseWriter to remember the status code and size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// Records the status code before writing it
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Records the size of the body, a write without a status is an implicit 200
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+
+	return n, err
+}
+
+// Checks if anything was sent to the client yet
+func (rr *responseRecorder) written() bool {
+	return rr.status != 0
+}
+
+// Status code sent to the client, the server sends a 200 if the handler didn't write anything
+func (rr *responseRecorder) code() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+
+	return rr.status
+}
+
+// Reuses the recorder of an outer middleware so they all see the same status
+func record(w http.ResponseWriter) *responseRecorder {
+	if rr, ok := w.(*responseRecorder); ok {
+		return rr
+	}
+
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// Middleware that logs every request with its status, latency and client identity
+func Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rr := record(w)
+
+		next.ServeHTTP(rr, r)
+
+		entry := logger.Log.WithFields(log.Fields{
+			"request_id":  RequestIDFromContext(r.Context()),
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"query":       r.URL.RawQuery,
+			"status":      rr.code(),
+			"bytes":       rr.bytes,
+			"latency_ms":  float64(time.Since(start).Microseconds()) / 1000,
+			"client":      auth.FromRequest(r).String(),
+			"remote_addr": r.RemoteAddr,
+		})
+		switch {
+		case rr.code() >= 500:
+			entry.Error("request failed")
+		case rr.code() >= 400:
+			entry.Warn("request rejected")
+		default:
+			entry.Info("request served")
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware that records request counts and latency by route template
+// The template keeps the label cardinality down, unlike the path which has the namespace and deployment in it
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rr := record(w)
+
+		next.ServeHTTP(rr, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.ObserveRequest(route, r.Method, rr.code(), time.Since(start))
+	})
+}
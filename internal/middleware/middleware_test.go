@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/metrics"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+// The members of an error body the tests look at
+type problem struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id"`
+}
+
+// Chains the middleware the same way main does
+func chain(handler http.HandlerFunc) http.Handler {
+	return RequestID(Logging(Recovery(handler)))
+}
+
+// Tests the request ID is propagated or generated and ends up in error bodies
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requestID string
+		expectNew bool
+	}{
+		{name: "propagated", requestID: "abc-123"},
+		{name: "generated", expectNew: true},
+		{name: "invalid", requestID: "bad id\n", expectNew: true},
+		{name: "too-long", requestID: strings.Repeat("a", maxRequestIDLength+1), expectNew: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var fromContext string
+			handler := chain(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+				responses.ReturnError(w, e.NotFound("not found"))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/deployments", nil)
+			if test.requestID != "" {
+				req.Header.Set(responses.RequestIDHeader, test.requestID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			var body problem
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			header := rr.Header().Get(responses.RequestIDHeader)
+
+			switch {
+			case header == "":
+				t.Errorf("Fail: got no %s header", responses.RequestIDHeader)
+			case header != fromContext || header != body.RequestID:
+				t.Errorf("Fail: header %q, context %q and body %q differ", header, fromContext, body.RequestID)
+			case !test.expectNew && header != test.requestID:
+				t.Errorf("Fail: got %q want %q", header, test.requestID)
+			case test.expectNew && header == test.requestID:
+				t.Errorf("Fail: got the client ID %q want a new one", header)
+			}
+		})
+	}
+}
+
+// Tests a panicking handler gets a 500 instead of an empty response
+func TestRecovery(t *testing.T) {
+	handler := chain(func(w http.ResponseWriter, r *http.Request) {
+		var deployments map[string]int
+		deployments["boom"]++
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/deployments", nil))
+
+	var body problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case rr.Code != http.StatusInternalServerError:
+		t.Errorf("Fail: got %d want %d", rr.Code, http.StatusInternalServerError)
+	case body.Type != e.KindInternal.Type:
+		t.Errorf("Fail: got type %q want %q", body.Type, e.KindInternal.Type)
+	case body.RequestID == "" || body.RequestID != rr.Header().Get(responses.RequestIDHeader):
+		t.Errorf("Fail: got request ID %q in the body", body.RequestID)
+	}
+}
+
+// Tests a panic after the handler started responding keeps the original status
+func TestRecoveryAfterWrite(t *testing.T) {
+	handler := chain(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late panic")
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/deployments", nil))
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Fail: got %d want %d", rr.Code, http.StatusAccepted)
+	}
+}
+
+// Tests requests are counted by route template instead of path
+func TestMetrics(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Metrics)
+	router.HandleFunc("/v1/replicas/{namespace}/{deployment}", func(w http.ResponseWriter, r *http.Request) {
+		responses.ReturnError(w, e.NotFound("not found"))
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/replicas/test/test-deployment", nil))
+
+	expected := `
+# HELP kube_server_http_requests_total API requests by route, method and status code.
+# TYPE kube_server_http_requests_total counter
+kube_server_http_requests_total{code="404",method="GET",route="/v1/replicas/{namespace}/{deployment}"} 1
+`
+	if err := testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "kube_server_http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Middleware that turns a panic in a handler into a 500 instead of leaving the response unwritten
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rr := record(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// The server relies on this to abort a response on purpose, let it through
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			logger.Log.WithFields(log.Fields{
+				"request_id": RequestIDFromContext(r.Context()),
+				"panic":      err,
+				"stack":      string(debug.Stack()),
+			}).Error("recovered from panic in handler")
+
+			// Too late to change the status if the handler already started responding
+			if !rr.written() {
+				responses.ReturnError(rr, e.Internal(nil))
+			}
+		}()
+
+		next.ServeHTTP(rr, r)
+	})
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+
+	"github.com/google/uuid"
+)
+
+// Key for the request ID in the request context
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// Longest request ID we accept from clients, anything else gets a new one
+const maxRequestIDLength = 128
+
+// Middleware that propagates the X-Request-ID of the client or assigns a new UUID
+// The ID is set on the response straight away so every response, including errors, carries it
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(responses.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set(responses.RequestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Gets the request ID the middleware stored in the context
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Only accepts printable ASCII IDs of a sane length so clients can't inject into our logs
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
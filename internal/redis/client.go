@@ -7,6 +7,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	e "github.com/taylorsmcclure/kube-server/internal/errors"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/metrics"
 )
 
 // Creates a Redis client with mTLS authentication
@@ -20,6 +21,8 @@ func NewClient(address string, redisTLSConfig *tls.Config) (*redis.Client, error
 		TLSConfig: redisTLSConfig,
 		DB:        0, // use default DB
 	})
+	// Time every command for the metrics endpoint
+	rClient.AddHook(metrics.RedisHook{})
 
 	// Verify we can connect to Redis
 	_, err := rClient.Ping(ctx).Result()
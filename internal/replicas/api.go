@@ -2,275 +2,476 @@ package replicas
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"reflect"
+	"strconv"
+	"time"
 
 	// internal packages
-	"github.com/go-redis/redis/v8"
+	"github.com/taylorsmcclure/kube-server/internal/auth"
 	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
 	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
 
 	// Kubernetes packages
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/scale"
+
+	"github.com/gorilla/mux"
 )
 
-// Handles the /v1/replicas endpoint
-func V1Replicas(w http.ResponseWriter, r *http.Request, kClient kubernetes.Interface, rClient *redis.Client) {
-	// Check if namespace and deployment are in the request
-	reqURI := strings.Split(r.URL.Path, "/")
-	if len(reqURI) < 5 {
-		responses.ReturnJsonResponse(w, 400, &e.GenericError{Code: 400, Message: "No namespace and/or deployment provided"})
+// Handles the /v1/replicas/{namespace}/{deployment} endpoint for deployments
+// The router also sends incomplete paths and anything after the deployment name here so the client learns what is wrong with the path
+func V1Replicas(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) {
+	vars := mux.Vars(r)
+	namespace, deployment := vars["namespace"], vars["deployment"]
+	switch extra, hasExtra := vars["extra"]; {
+	case namespace == "":
+		responses.ReturnError(w, e.Validation("no namespace and deployment in the path, use /v1/replicas/{namespace}/{deployment}"))
+		return
+	case deployment == "":
+		responses.ReturnError(w, e.Validation("no deployment in the path, use /v1/replicas/{namespace}/{deployment}"))
+		return
+	case hasExtra:
+		responses.ReturnError(w, e.Validation("unexpected %q after the deployment in the path, use /v1/replicas/{namespace}/{deployment}", "/"+extra))
 		return
 	}
-	// TODO: we should have better validation regex
-	namespace := reqURI[3]
-	deployment := reqURI[4]
 
+	serveReplicas(w, r, scales, deploymentWorkloads{listers.Deployments}, store, replicaLimits, namespace, deployment)
+}
+
+// Handles the /v1/replicas/{namespace}/statefulsets/{statefulset} endpoint
+func V1StatefulSetReplicas(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) {
+	vars := mux.Vars(r)
+
+	serveReplicas(w, r, scales, statefulSetWorkloads{listers.StatefulSets}, store, replicaLimits, vars["namespace"], vars["statefulset"])
+}
+
+// Handles the /v1/scale/{group}/{resource}/{namespace}/{name} endpoint for any resource implementing the scale subresource
+func V1Scale(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) {
+	vars := mux.Vars(r)
+	target := listers.forResource(scales, vars["group"], vars["resource"])
+
+	serveReplicas(w, r, scales, target, store, replicaLimits, vars["namespace"], vars["name"])
+}
+
+// Serves GET and POST on the replicas of a single workload
+func serveReplicas(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy, namespace string, name string) {
+	if err := validation.NamespacedName(namespace, "name", name); err != nil {
+		responses.ReturnError(w, err)
+		return
+	}
 	// Support both GET and POST requests on the replicas endpoint
 	switch r.Method {
 	// Handle the GET request
 	case http.MethodGet, http.MethodHead:
-		resp, err := getReplicas(kClient, rClient, namespace, deployment)
+		allowed, err := auth.Authorized(r, auth.VerbRead, target.resource(), namespace, name)
 		if err != nil {
-			// Handle k8s API specific errors and send to the client
-			if statusError, isStatus := err.(*errors.StatusError); isStatus {
-				responses.ReturnJsonResponse(w, int(statusError.ErrStatus.Code), &e.GenericError{Code: int(statusError.ErrStatus.Code), Message: fmt.Sprint(err)})
-			} else {
-				responses.ReturnJsonResponse(w, 500, &e.GenericError{Code: 500, Message: "Internal server error"})
-			}
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to read %s %s/%s", auth.FromRequest(r), target.resource(), namespace, name))
 			return
 		}
+		resp, etag, err := getReplicas(target, store, namespace, name)
+		if err != nil {
+			responses.ReturnError(w, scaleProblem(err))
+			return
+		}
+		w.Header().Set("ETag", etag)
 		responses.ReturnJsonResponse(w, 200, resp)
 	// Handle the POST request
 	case http.MethodPost:
+		allowed, err := auth.Authorized(r, auth.VerbScale, target.resource(), namespace, name)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to scale %s %s/%s", auth.FromRequest(r), target.resource(), namespace, name))
+			return
+		}
 		// Get replica_size from the data in the POST
 		var req setReplicasRequest
-		// Don't allow any other json fields in payload except for what's in setReplicasRequest
-		decoder := json.NewDecoder(r.Body)
-		decoder.DisallowUnknownFields()
-
-		err := decoder.Decode(&req)
+		err = validation.DecodeJSON(r, &req)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+		wait, err := parseWait(r)
 		if err != nil {
-			responses.ReturnJsonResponse(w, 400, &e.GenericError{Code: 400, Message: "Bad request"})
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+		if dryRun && wait > 0 {
+			responses.ReturnError(w, e.Validation("wait can't be used with dryRun, nothing rolls out"))
 			return
 		}
-		// Set the replicas
-		resp, err := setReplicas(kClient, rClient, namespace, deployment, req.ReplicaSize)
+		// Set the replicas, only if the client still has the latest version when it sent an If-Match
+		opts := scaleOptions{Actor: auth.FromRequest(r).String(), IfMatch: r.Header.Get("If-Match"), DryRun: dryRun, Wait: wait}
+		resp, etag, err := setReplicas(scales, target, store, replicaLimits, namespace, name, req.ReplicaSize, opts)
 		if err != nil {
-			if statusError, isStatus := err.(*errors.StatusError); isStatus {
-				responses.ReturnJsonResponse(w, int(statusError.ErrStatus.Code), &e.GenericError{Code: int(statusError.ErrStatus.Code), Message: fmt.Sprint(err)})
-			} else {
-				responses.ReturnJsonResponse(w, 500, &e.GenericError{Code: 500, Message: "Internal server error"})
-			}
+			responses.ReturnError(w, scaleProblem(err))
 			return
 		}
+		// Nothing changed on a dry run so there is no new version
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		responses.ReturnJsonResponse(w, 200, resp)
 	default:
-		responses.ReturnJsonResponse(w, 405, e.GenericError{Code: 405, Message: "method not allowed"})
+		responses.ReturnError(w, e.MethodNotAllowed())
 	}
 }
 
+// Turns an error from getting or setting replicas into the problem for the client, a resource the API server doesn't know is a 404 too
+func scaleProblem(err error) *e.Error {
+	if limitsError, isLimits := err.(*limits.Error); isLimits {
+		return e.PolicyViolation(map[string]interface{}{"violations": limitsError.Violations}, "%s", err)
+	}
+	if meta.IsNoMatchError(err) {
+		return e.NotFound("%s", err)
+	}
+
+	return e.From(err)
+}
+
+// Reads the dryRun query parameter of a scale request
+func parseDryRun(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("dryRun")
+	if value == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("dryRun must be true or false, got %q", value)
+	}
+
+	return dryRun, nil
+}
+
 // Parse incoming payload from client
 type setReplicasRequest struct {
 	ReplicaSize int32 `json:"replica_size"`
 }
 
+// How a scale request is carried out
+type scaleOptions struct {
+	// Who asked for the scale, recorded in the history
+	Actor string
+	// If-Match header of the request, empty to scale whatever changed since the client read the workload
+	IfMatch string
+	// Validates everything and reports what would happen without changing the workload or its state
+	DryRun bool
+	// Remembers the replicas the workload had so it can be woken up to them
+	Hibernate bool
+	// Forgets the replicas the workload had when it was hibernated
+	Wake bool
+	// How long to wait for the rollout after the scale, zero to return as soon as the scale is accepted
+	Wait time.Duration
+}
+
+// Names the workload in responses, deployments and StatefulSets keep the field they always had
+type workloadName struct {
+	Deployment  string `json:"deployment_name,omitempty"`
+	StatefulSet string `json:"statefulset_name,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
 // Response to client when GET request is made
 type getReplicasResponse struct {
-	Namespace       string `json:"namespace"`
-	Deployment      string `json:"deployment_name"`
-	CurrentReplicas int32  `json:"current_replicas"`
-	DesiredReplicas int32  `json:"desired_replicas"`
-	Drift           bool   `json:"state_drift"`
-	Code            int    `json:"http_status_code"`
+	Namespace string `json:"namespace"`
+	workloadName
+	CurrentReplicas    int32  `json:"current_replicas"`
+	DesiredReplicas    int32  `json:"desired_replicas"`
+	Drift              bool   `json:"state_drift"`
+	HibernatedReplicas *int32 `json:"hibernated_replicas,omitempty"`
+	Code               int    `json:"http_status_code"`
 }
 
 // Response to client when they make a POST request
 type setReplicasResponse struct {
-	Namespace         string `json:"namespace"`
-	Deployment        string `json:"deployment_name"`
-	CurrentReplicas   int32  `json:"current_replicas"`
-	DesiredReplicas   int32  `json:"desired_replicas"`
-	RequestedReplicas int32  `json:"requested_replicas"`
-	Drift             bool   `json:"state_drift"`
-	Code              int    `json:"http_status_code"`
-}
-
-// Value of the replicas key in Redis
-type redisValue struct {
-	DesiredReplicas int32 `json:"desired_replicas"`
-	CurrentReplicas int32 `json:"current_replicas"`
-	Drift           bool  `json:"state_drift"`
+	Namespace string `json:"namespace"`
+	workloadName
+	CurrentReplicas   int32 `json:"current_replicas"`
+	DesiredReplicas   int32 `json:"desired_replicas"`
+	RequestedReplicas int32 `json:"requested_replicas"`
+	Drift             bool  `json:"state_drift"`
+	DryRun            bool  `json:"dry_run,omitempty"`
+	// Only when the client waited for the rollout
+	Rollout *rolloutStatus `json:"rollout,omitempty"`
+	Code    int            `json:"http_status_code"`
+	// State from before the scale and the state it wrote, so a batch can put the previous one back
+	previous *state.Value
+	written  *state.Value
 }
 
-// Helper to form the key for state in Redis
-func genRedisKey(namespace, deployment string) string {
-	return fmt.Sprintf("%s-%s", namespace, deployment)
+// Puts the workload name into the response field of its kind
+func newWorkloadName(kind string, name string) workloadName {
+	switch kind {
+	case state.KindDeployment:
+		return workloadName{Deployment: name}
+	case state.KindStatefulSet:
+		return workloadName{StatefulSet: name}
+	default:
+		return workloadName{Resource: kind, Name: name}
+	}
 }
 
-// Gets replicas of a deployment and checks its state in Redis
-func getReplicas(kClient kubernetes.Interface, rClient *redis.Client, namespace string, deployment string) (*getReplicasResponse, error) {
-	defer e.NonFatal()
-
-	// Get the deployment and replicas
-	deployResp, err := kClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment, metav1.GetOptions{})
+// Gets replicas of a workload and checks its state in the state store, returns the ETag of what it found
+func getReplicas(target workloads, store state.StateStore, namespace string, name string) (*getReplicasResponse, string, error) {
+	// Get the workload and replicas from the informer cache
+	live, err := target.get(namespace, name)
 	// Catch k8s API specific errors
 	if err != nil {
 		if statusError, isStatus := err.(*errors.StatusError); isStatus {
-			return nil, statusError
+			return nil, "", statusError
 		} else {
-			return nil, err
+			return nil, "", err
 		}
 	}
 
-	// Get the redis key to set the state
-	redisKey := genRedisKey(namespace, deployment)
-	redisGetValue, keyExists, err := getState(rClient, redisKey)
-	if err != nil {
-		logger.Log.Errorf("error getting state for key %s from Redis: %s", redisKey, err)
-		return nil, err
-	}
-
-	var redisSetValue *redisValue
-	// Logic handling if this is the first time we've seen this deployment
-	if keyExists {
-		if redisGetValue.DesiredReplicas != *deployResp.Spec.Replicas {
-			logger.Log.Debugf("difference detected for deployment %s, k8s_replicas:%d, redis_replicas:%d", deployment, *deployResp.Spec.Replicas, redisGetValue.DesiredReplicas)
-			redisSetValue = &redisValue{DesiredReplicas: redisGetValue.DesiredReplicas, CurrentReplicas: *deployResp.Spec.Replicas, Drift: true}
-		} else {
-			// No need to set the key again if there is no drift, just return the current values
-			logger.Log.Debugf("desired replicas for %s match, returning k8s + redis data and not setting anything in Redis", redisKey)
-			resp := &getReplicasResponse{Code: 200, Namespace: namespace, Deployment: deployment, CurrentReplicas: *deployResp.Spec.Replicas, DesiredReplicas: redisGetValue.DesiredReplicas, Drift: redisGetValue.Drift}
-			return resp, nil
+	key := state.Key{Kind: target.kind(), Namespace: namespace, Name: name}
+	names := newWorkloadName(target.kind(), name)
+
+	// Reads and updates the state in one go so a POST in between can't be overwritten with the old desired replicas
+	var stored *state.Value
+	err = store.Update(context.Background(), key, func(current *state.Value) (*state.Value, error) {
+		observed := observeReplicas(key, current, live.Replicas)
+		if observed == nil {
+			// No need to set the state again if there is no drift, just return the current values
+			logger.Log.Debugf("desired replicas for %s match, returning k8s + stored data and not setting anything", key)
+			stored = current
+			return nil, nil
 		}
-	} else {
-		redisSetValue = &redisValue{DesiredReplicas: redisGetValue.DesiredReplicas, CurrentReplicas: *deployResp.Spec.Replicas, Drift: redisGetValue.Drift}
-	}
-
-	// Sends the update values to the Redis function
-	_, err = setState(rClient, redisKey, redisSetValue, *deployResp.Spec.Replicas)
+		stored = observed
+		return observed, nil
+	})
 	if err != nil {
-		logger.Log.Errorf("error setting state for key %s in Redis: %s", redisKey, err)
-		return nil, err
+		logger.Log.Errorf("error setting state for %s: %s", key, err)
+		return nil, "", err
 	}
 
-	resp := &getReplicasResponse{Code: 200, Namespace: namespace, Deployment: deployment, CurrentReplicas: *deployResp.Spec.Replicas,
-		DesiredReplicas: redisSetValue.DesiredReplicas, Drift: redisSetValue.Drift}
+	resp := &getReplicasResponse{Code: 200, Namespace: namespace, workloadName: names, CurrentReplicas: live.Replicas,
+		DesiredReplicas: stored.DesiredReplicas, Drift: stored.Drift, HibernatedReplicas: stored.HibernatedReplicas}
 
-	return resp, nil
+	return resp, computeETag(live.ResourceVersion, stored), nil
 }
 
-// Sets the replicas of a deployment and stores its state in Redis
-func setReplicas(kClient kubernetes.Interface, rClient *redis.Client, namespace string, deployment string, replicas int32) (*setReplicasResponse, error) {
-	defer e.NonFatal()
+// Works out the state of a workload from its live replicas, nil if the stored state doesn't need to change
+func observeReplicas(key state.Key, current *state.Value, liveReplicas int32) *state.Value {
+	switch {
+	// First time we've seen this workload so there is no desired state yet
+	case current == nil:
+		return &state.Value{DesiredReplicas: 0, CurrentReplicas: liveReplicas, Drift: true}
+	case current.DesiredReplicas != liveReplicas:
+		logger.Log.Debugf("difference detected for %s, k8s_replicas:%d, desired_replicas:%d", key, liveReplicas, current.DesiredReplicas)
+		observed := *current
+		observed.CurrentReplicas = liveReplicas
+		observed.Drift = true
+		return &observed
+	default:
+		return nil
+	}
+}
 
-	// Get the deployment and replicas for the current state
-	deployResp, err := kClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment, metav1.GetOptions{})
+// Sets the replicas of a workload and stores its state in the state store, returns the new ETag
+// With an IfMatch the scale only happens if it matches the ETag of the workload and its state, otherwise errPreconditionFailed
+// Scales that break the replica limits are rejected with a *limits.Error before anything happens
+func setReplicas(scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy, namespace string, name string, replicas int32, opts scaleOptions) (*setReplicasResponse, string, error) {
+	// Get the workload and replicas for the current state from the informer cache
+	live, err := target.get(namespace, name)
 	// Catch k8s API specific errors
 	if err != nil {
 		if statusError, isStatus := err.(*errors.StatusError); isStatus {
-			return nil, statusError
+			return nil, "", statusError
 		} else {
-			return nil, err
+			return nil, "", err
 		}
 	}
 
-	// Calls the k8s API and uses a PATCH to update the replicas of the deployment
-	patchReplicas := []byte(fmt.Sprintf(`{"spec":{"replicas": %d}}`, replicas))
-	_, err = kClient.AppsV1().Deployments(namespace).Patch(context.TODO(), deployment, types.MergePatchType, patchReplicas, metav1.PatchOptions{})
+	key := state.Key{Kind: target.kind(), Namespace: namespace, Name: name}
+	names := newWorkloadName(target.kind(), name)
+
+	err = replicaLimits.Check(target.resource(), namespace, name, live.Annotations, live.Replicas, replicas)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.DryRun {
+		resp, err := dryRunReplicas(scales, target, store, key, live, replicas, opts)
+		return resp, "", err
+	}
+
+	// Checks the If-Match and sets the state with updated values in one atomic update before patching,
+	// otherwise the watch event for our own patch could see the old desired replicas and flag it as drift
+	var previous, stateSetValue *state.Value
+	err = store.Update(context.Background(), key, func(current *state.Value) (*state.Value, error) {
+		if opts.IfMatch != "" && !etagMatches(opts.IfMatch, computeETag(live.ResourceVersion, current)) {
+			return nil, errPreconditionFailed
+		}
+		previous = current
+		stateSetValue = scaledState(current, live, replicas, opts)
+		return stateSetValue, nil
+	})
+	if err != nil {
+		if err != errPreconditionFailed {
+			logger.Log.Errorf("error setting state for %s: %s", key, err)
+		}
+		return nil, "", err
+	}
+
+	// Calls the k8s API and updates the replicas through the scale subresource
+	resourceVersion, err := scaleReplicas(scales, target, namespace, name, replicas, expectedReplicas(live, opts), false)
+	recordHistory(store, key, opts.Actor, live.Replicas, replicas, "", err)
 	// Catch k8s API specific errors
 	if err != nil {
+		// Put the previous state back since the workload was never changed
+		if rollbackErr := restoreState(store, key, previous, stateSetValue); rollbackErr != nil {
+			logger.Log.Errorf("error rolling back state for %s: %s", key, rollbackErr)
+		}
+
 		if statusError, isStatus := err.(*errors.StatusError); isStatus {
-			return nil, statusError
+			return nil, "", statusError
 		} else {
-			return nil, err
+			return nil, "", err
 		}
 	}
 
-	// Gets the deployment key from Redis
-	redisKey := genRedisKey(namespace, deployment)
-	redisGetValue, _, err := getState(rClient, redisKey)
-	if err != nil {
-		logger.Log.Errorf("error getting state for key %s from Redis: %s", redisKey, err)
-		return nil, err
+	// Nothing desired was stored yet the first time we see a workload
+	var desired int32
+	if previous != nil {
+		desired = previous.DesiredReplicas
+	}
+	resp := &setReplicasResponse{Code: 200, Namespace: namespace, workloadName: names, DesiredReplicas: desired,
+		RequestedReplicas: replicas, CurrentReplicas: live.Replicas, Drift: stateSetValue.Drift, previous: previous, written: stateSetValue}
+
+	// The workload changed again while rolling out, so report it and its version as they are now
+	if opts.Wait > 0 {
+		// The scale was applied even if the rollout couldn't be followed, so that only shows in the rollout status
+		rollout, rolled := waitForRollout(target, namespace, name, replicas, opts.Wait)
+		resp.Rollout = rollout
+		if rolled != nil {
+			resp.CurrentReplicas = rolled.Replicas
+			resourceVersion = rolled.ResourceVersion
+		}
 	}
 
-	redisSetValue := &redisValue{DesiredReplicas: replicas, CurrentReplicas: replicas, Drift: false}
+	return resp, computeETag(resourceVersion, stateSetValue), nil
+}
 
-	// Sets the redis key with updated values
-	_, err = setState(rClient, redisKey, redisSetValue, replicas)
+// Runs a scale request through the same checks and a server-side dry run, without writing the state or history
+func dryRunReplicas(scales scale.ScalesGetter, target workloads, store state.StateStore, key state.Key, live *liveWorkload, replicas int32, opts scaleOptions) (*setReplicasResponse, error) {
+	current, _, err := store.Get(context.Background(), key)
 	if err != nil {
-		logger.Log.Errorf("error setting state for key %s in Redis: %s", redisKey, err)
+		logger.Log.Errorf("error getting state for %s: %s", key, err)
 		return nil, err
 	}
+	if opts.IfMatch != "" && !etagMatches(opts.IfMatch, computeETag(live.ResourceVersion, current)) {
+		return nil, errPreconditionFailed
+	}
+
+	_, err = scaleReplicas(scales, target, key.Namespace, key.Name, replicas, expectedReplicas(live, opts), true)
+	if err != nil {
+		if statusError, isStatus := err.(*errors.StatusError); isStatus {
+			return nil, statusError
+		} else {
+			return nil, err
+		}
+	}
 
-	resp := &setReplicasResponse{Code: 200, Namespace: namespace, Deployment: deployment, DesiredReplicas: redisGetValue.DesiredReplicas,
-		RequestedReplicas: replicas, CurrentReplicas: *deployResp.Spec.Replicas, Drift: redisSetValue.Drift}
+	// The same response the scale would have returned
+	var desired int32
+	if current != nil {
+		desired = current.DesiredReplicas
+	}
+	resp := &setReplicasResponse{Code: 200, Namespace: key.Namespace, workloadName: newWorkloadName(key.Kind, key.Name), DesiredReplicas: desired,
+		RequestedReplicas: replicas, CurrentReplicas: live.Replicas, Drift: false, DryRun: true}
 
 	return resp, nil
 }
 
-// Gets State via the value of the Redis key
-func getState(rClient *redis.Client, redisKey string) (*redisValue, bool, error) {
-	var redisGetValues *redisValue
-
-	// Context for Redis connections
-	rCtx := context.Background()
-
-	// Check if the key exists, if not return back with false
-	keyExists := rClient.Exists(rCtx, redisKey).Val()
-	if keyExists == 0 {
-		logger.Log.Debugf("key %s does not exist in Redis, returning false", redisKey)
-		return &redisValue{0, 0, true}, false, nil
-	}
+// Puts back the state from before a scale, removing it again if there was none
+// Only the state the scale wrote is replaced, if another request changed the desired replicas since then theirs is kept
+func restoreState(store state.StateStore, key state.Key, previous *state.Value, written *state.Value) error {
+	return store.Update(context.Background(), key, func(current *state.Value) (*state.Value, error) {
+		if !sameDesired(current, written) {
+			return nil, nil
+		}
+		switch {
+		case previous != nil:
+			return previous, nil
+		case current != nil:
+			return state.Deleted, nil
+		default:
+			return nil, nil
+		}
+	})
+}
 
-	// Gets the existing key in Redis
-	rGet, err := rClient.Get(rCtx, redisKey).Result()
-	if err != nil {
-		logger.Log.Errorf("error getting key %s from Redis: %s", redisKey, err)
-		return nil, false, err
+// Checks two states ask for the same replicas, nil being no state
+// Drift and current replicas aren't compared since watch events update them while a scale is going on
+func sameDesired(a *state.Value, b *state.Value) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
 
-	logger.Log.Debugf("key %s found in Redis", redisKey)
+	return a.DesiredReplicas == b.DesiredReplicas && reflect.DeepEqual(a.HibernatedReplicas, b.HibernatedReplicas)
+}
 
-	// Unmarshal the value from Redis into the redisValue struct
-	err = json.Unmarshal([]byte(rGet), &redisGetValues)
-	if err != nil {
-		logger.Log.Errorf("error unmarshalling key %s from Redis: %s", redisKey, err)
-		return nil, false, err
+// Works out the state after a scale, keeping track of the replicas a hibernated workload wakes up to
+// Scaling a hibernated workload keeps the replicas it had when it was hibernated, waking it up forgets them
+func scaledState(current *state.Value, live *liveWorkload, replicas int32, opts scaleOptions) *state.Value {
+	next := &state.Value{DesiredReplicas: replicas, CurrentReplicas: replicas, Drift: false}
+	switch {
+	case opts.Wake:
+	case current != nil && current.HibernatedReplicas != nil:
+		next.HibernatedReplicas = current.HibernatedReplicas
+	case opts.Hibernate:
+		hibernated := live.Replicas
+		next.HibernatedReplicas = &hibernated
 	}
 
-	return redisGetValues, true, nil
+	return next
 }
 
-// Sets the state in Redis and passes in a redisValue for reference
-func setState(rClient *redis.Client, redisKey string, redisNewValue *redisValue, replicas int32) (*redisValue, error) {
-	redisSetValues := &redisValue{DesiredReplicas: redisNewValue.DesiredReplicas,
-		CurrentReplicas: replicas, Drift: redisNewValue.Drift}
+// The client saw the replicas in the informer cache, with an If-Match don't scale if they changed since
+func expectedReplicas(live *liveWorkload, opts scaleOptions) *int32 {
+	if opts.IfMatch == "" {
+		return nil
+	}
 
-	// Context for Redis connections
-	rCtx := context.Background()
+	return &live.Replicas
+}
 
-	logger.Log.Debugf("setting key %s in Redis", redisKey)
-	// Marshals the values to JSON
-	redisJson, err := json.Marshal(redisSetValues)
-	if err != nil {
-		logger.Log.Errorf("error marshalling key %s to Redis: %s", redisKey, err)
-		return nil, err
+// Records a scale action in the history, errors are only logged since the scale already happened
+func recordHistory(store state.StateStore, key state.Key, actor string, fromReplicas int32, toReplicas int32, reason string, scaleErr error) {
+	entry := &state.HistoryEntry{Timestamp: time.Now().UTC(), Actor: actor, FromReplicas: fromReplicas, ToReplicas: toReplicas, Result: state.ResultSuccess, Reason: reason}
+	if scaleErr != nil {
+		entry.Result = state.ResultFailure
+		entry.Error = scaleErr.Error()
 	}
 
-	// Sets the new values in Redis
-	_, err = rClient.Set(rCtx, redisKey, redisJson, 0).Result()
+	err := store.AppendHistory(context.Background(), key, entry)
 	if err != nil {
-		logger.Log.Errorf("error setting key %s in Redis: %s", redisKey, err)
-		return nil, err
+		logger.Log.Errorf("error recording history for %s: %s", key, err)
 	}
+}
 
-	return redisSetValues, nil
+// Removes the state of a workload from the state store
+func deleteState(store state.StateStore, key state.Key) error {
+	return store.Delete(context.Background(), key)
 }
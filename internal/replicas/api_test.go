@@ -1,13 +1,37 @@
 package replicas
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
 	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
 
-	"github.com/go-redis/redismock/v8"
+	"github.com/gorilla/mux"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/scale"
+	fakescale "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 // I don't like being dependent on the internal package, but
@@ -16,208 +40,950 @@ func init() {
 	logger.Setup(false)
 }
 
-// TODO: I would like to test the HTTP routing for /replicas, but I cannot
-// find an easy way to mock the redis client with two different states
-// and send it to the V1Replicas function.
+// Builds a fake clientset, a scale client and listers backed by it with a synced cache
+func newTestClients(t *testing.T, objects ...runtime.Object) (*testclient.Clientset, scale.ScalesGetter, Listers) {
+	fakeClientset := testclient.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(fakeClientset, 0)
+	listers := Listers{
+		Deployments:  factory.Apps().V1().Deployments().Lister(),
+		StatefulSets: factory.Apps().V1().StatefulSets().Lister(),
+	}
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+
+	return fakeClientset, dryRunScales{newTestScales(fakeClientset)}, listers
+}
+
+// The fake scale client drops the update options, so dry runs are answered here like the API server would
+type dryRunScales struct {
+	scale.ScalesGetter
+}
+
+func (s dryRunScales) Scales(namespace string) scale.ScaleInterface {
+	return dryRunScaleClient{s.ScalesGetter.Scales(namespace)}
+}
+
+type dryRunScaleClient struct {
+	scale.ScaleInterface
+}
+
+func (c dryRunScaleClient) Update(ctx context.Context, resource schema.GroupResource, scaleReq *autoscalingv1.Scale, opts metav1.UpdateOptions) (*autoscalingv1.Scale, error) {
+	if len(opts.DryRun) == 0 {
+		return c.ScaleInterface.Update(ctx, resource, scaleReq, opts)
+	}
+	// Still fails if the workload doesn't exist
+	if _, err := c.ScaleInterface.Get(ctx, resource, scaleReq.Name, metav1.GetOptions{}); err != nil {
+		return nil, err
+	}
+
+	return scaleReq, nil
+}
+
+// Builds a scale client reading and writing the replicas of the workloads in the fake clientset
+func newTestScales(fakeClientset *testclient.Clientset) *fakescale.FakeScaleClient {
+	scales := &fakescale.FakeScaleClient{}
+	scales.AddReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		replicas, err := testReplicas(fakeClientset, get.GetResource().Resource, get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: get.GetName(), Namespace: get.GetNamespace()},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+		}, nil
+	})
+	scales.AddReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update := action.(k8stesting.UpdateAction)
+		scaleReq := update.GetObject().(*autoscalingv1.Scale)
+		patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, scaleReq.Spec.Replicas))
+
+		var err error
+		switch update.GetResource().Resource {
+		case "deployments":
+			_, err = fakeClientset.AppsV1().Deployments(update.GetNamespace()).Patch(context.TODO(), scaleReq.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		case "statefulsets":
+			_, err = fakeClientset.AppsV1().StatefulSets(update.GetNamespace()).Patch(context.TODO(), scaleReq.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		case "replicasets":
+			_, err = fakeClientset.AppsV1().ReplicaSets(update.GetNamespace()).Patch(context.TODO(), scaleReq.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		default:
+			err = errors.NewNotFound(update.GetResource().GroupResource(), scaleReq.Name)
+		}
+
+		return true, scaleReq, err
+	})
+
+	return scales
+}
+
+// Reads spec.replicas of a workload in the fake clientset
+func testReplicas(fakeClientset *testclient.Clientset, resource string, namespace string, name string) (int32, error) {
+	switch resource {
+	case "deployments":
+		deployResp, err := fakeClientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return *deployResp.Spec.Replicas, nil
+	case "statefulsets":
+		statefulSet, err := fakeClientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return *statefulSet.Spec.Replicas, nil
+	case "replicasets":
+		replicaSet, err := fakeClientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return *replicaSet.Spec.Replicas, nil
+	default:
+		return 0, errors.NewNotFound(schema.GroupResource{Resource: resource}, name)
+	}
+}
+
+// Builds a request with a JSON body like clients send it, without a body when it is empty
+func newJSONRequest(method string, path string, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req
+}
+
+// Routes requests for deployments to V1Replicas the same way main does, so the namespace and deployment come from the path
+func replicasRouter(scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) *mux.Router {
+	router := mux.NewRouter()
+	for _, path := range []string{"/v1/replicas", "/v1/replicas/{namespace}", "/v1/replicas/{namespace}/{deployment}", "/v1/replicas/{namespace}/{deployment}/{extra:.*}"} {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			V1Replicas(w, r, scales, listers, store, replicaLimits)
+		})
+	}
+
+	return router
+}
+
+// Helper to build a deployment with a replica count
+func newTestDeployment(namespace string, name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+// Helper to build a StatefulSet with a replica count
+func newTestStatefulSet(namespace string, name string, replicas int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+}
 
-// Tests getting a deployment state object from Redis
+// Tests getting a deployment state object from the state store
 func TestGetState(t *testing.T) {
+	store := state.NewMemoryStore()
+	stored := &state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true}
+	if err := store.Set(context.TODO(), state.Key{Namespace: "namespace", Name: "replicas-deployment"}, stored); err != nil {
+		t.Fatal(err)
+	}
+
 	testCases := []struct {
 		name             string
 		description      string
-		expectSuccess    bool
-		redisKey         string
+		deployment       string
 		keyExists        bool
-		expectedResponse redisValue
+		expectedResponse *state.Value
 	}{
 		{
-			name:          "replicas-first-get",
-			description:   "This is the first time the server has seen the deployment, so it will return 0,0,false",
-			redisKey:      "namespace-first-replicas-deployment",
-			expectSuccess: true,
-			keyExists:     false,
-			expectedResponse: redisValue{
-				DesiredReplicas: 0,
-				CurrentReplicas: 0,
-				Drift:           true,
-			},
-		},
-
-		{
-			name:          "replicas-get-no-drift",
-			description:   "This key exists in Redis with a value",
-			redisKey:      "namespace-replicas-deployment",
-			expectSuccess: true,
-			keyExists:     true,
-			expectedResponse: redisValue{
-				DesiredReplicas: 4,
-				CurrentReplicas: 4,
-				Drift:           false,
-			},
-		},
-		{
-			name:          "replicas-get-with-drift",
-			description:   "This key exists in Redis with a value and has drift",
-			redisKey:      "namespace-drift-replicas-deployment",
-			expectSuccess: true,
-			keyExists:     true,
-			expectedResponse: redisValue{
-				DesiredReplicas: 4,
-				CurrentReplicas: 2,
-				Drift:           true,
-			},
-		},
-		{
-			name:          "replicas-get-drift-incorrect",
-			description:   "This key exists in Redis with a value and has drift but is not marked as having drift",
-			redisKey:      "namespace-drift-replicas-deployment-false",
-			expectSuccess: true,
-			keyExists:     true,
-			expectedResponse: redisValue{
-				DesiredReplicas: 4,
-				CurrentReplicas: 2,
-				Drift:           false,
-			},
+			name:        "replicas-first-get",
+			description: "This is the first time the server has seen the deployment, so there is no state",
+			deployment:  "first-replicas-deployment",
+			keyExists:   false,
+		},
+		{
+			name:             "replicas-get",
+			description:      "The stored state is returned as is",
+			deployment:       "replicas-deployment",
+			keyExists:        true,
+			expectedResponse: stored,
 		},
 	}
 
 	// Loop to run each test case
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
+			testResp, keyExists, err := store.Get(context.TODO(), state.Key{Namespace: "namespace", Name: test.deployment})
+			if err != nil {
+				t.Fatal(err)
+			}
 
-			// Init mock Redis client
-			db, mock := redismock.NewClientMock()
+			switch {
+			case !reflect.DeepEqual(testResp, test.expectedResponse):
+				t.Errorf("Fail: got %v want %v", testResp, test.expectedResponse)
+			case keyExists != test.keyExists:
+				t.Errorf("Key presence: got %v want %v", keyExists, test.keyExists)
+			default:
+				t.Logf("test passed %v", testResp)
+			}
+		})
+	}
+}
+
+// Tests a failed scale only puts the previous state back while the stored state is still the one it wrote
+func TestRestoreState(t *testing.T) {
+	previous := &state.Value{DesiredReplicas: 2, CurrentReplicas: 2}
+	written := &state.Value{DesiredReplicas: 4, CurrentReplicas: 4}
+
+	testCases := []struct {
+		name          string
+		previous      *state.Value
+		stored        *state.Value
+		expectedState *state.Value
+	}{
+		{name: "restored", previous: previous, stored: written, expectedState: previous},
+		{name: "drift-recorded", previous: previous, stored: &state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true}, expectedState: previous},
+		{name: "first-scale", stored: written},
+		{name: "scaled-since", previous: previous, stored: &state.Value{DesiredReplicas: 6, CurrentReplicas: 6}, expectedState: &state.Value{DesiredReplicas: 6, CurrentReplicas: 6}},
+		{name: "deleted-since", previous: previous},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			store := state.NewMemoryStore()
+			key := state.Key{Namespace: "namespace", Name: "restore-deployment"}
+			if test.stored != nil {
+				if err := store.Set(context.TODO(), key, test.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
 
-			// Handle the key exists or not and the appropriate Redis response
-			if !test.keyExists {
-				mock.ExpectExists(test.redisKey).RedisNil()
-			} else {
-				mock.ExpectExists(test.redisKey).SetVal(1)
+			if err := restoreState(store, key, test.previous, written); err != nil {
+				t.Fatal(err)
 			}
 
-			// To match we are marshalling to JSON as well
-			rGetJson, err := json.Marshal(test.expectedResponse)
+			storedState, exists, err := store.Get(context.TODO(), key)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			mock.ExpectGet(test.redisKey).SetVal(string(rGetJson))
+			switch {
+			case test.expectedState == nil && exists:
+				t.Errorf("Fail: got state %v want none", storedState)
+			case test.expectedState != nil && !reflect.DeepEqual(storedState, test.expectedState):
+				t.Errorf("Fail: got state %v want %v", storedState, test.expectedState)
+			default:
+				t.Logf("test passed %v", storedState)
+			}
+		})
+	}
+}
 
-			// Run the function with the mock client and stubbed data
-			testResp, key, err := getState(db, test.redisKey)
+// Tests the HTTP routing for /v1/replicas
+func TestV1Replicas(t *testing.T) {
+	testCases := []struct {
+		name           string
+		description    string
+		method         string
+		path           string
+		body           string
+		state          *state.Value
+		expectedCode   int
+		expectedState  *state.Value
+		expectedScaled int32
+	}{
+		{
+			name:           "get-first-time",
+			description:    "The first GET records the current replicas",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/test-deployment",
+			expectedCode:   200,
+			expectedState:  &state.Value{DesiredReplicas: 0, CurrentReplicas: 3, Drift: true},
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-with-drift",
+			description:    "A GET flags drift when the deployment doesn't match the desired replicas",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/test-deployment",
+			state:          &state.Value{DesiredReplicas: 5, CurrentReplicas: 5, Drift: false},
+			expectedCode:   200,
+			expectedState:  &state.Value{DesiredReplicas: 5, CurrentReplicas: 3, Drift: true},
+			expectedScaled: 3,
+		},
+		{
+			name:           "post-scale",
+			description:    "A POST patches the deployment and records the desired replicas",
+			method:         http.MethodPost,
+			path:           "/v1/replicas/test/test-deployment",
+			body:           `{"replica_size": 5}`,
+			expectedCode:   200,
+			expectedState:  &state.Value{DesiredReplicas: 5, CurrentReplicas: 5, Drift: false},
+			expectedScaled: 5,
+		},
+		{
+			name:           "post-bad-body",
+			description:    "Unknown fields are rejected",
+			method:         http.MethodPost,
+			path:           "/v1/replicas/test/test-deployment",
+			body:           `{"replicas": 5}`,
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-missing-deployment",
+			description:    "Deployments that don't exist return the k8s API error code",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/missing",
+			expectedCode:   404,
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-incomplete-path",
+			description:    "A namespace without a deployment is a bad request",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test",
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-trailing-path",
+			description:    "Anything after the deployment is a bad request instead of being ignored",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/test-deployment/extra",
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-trailing-slash",
+			description:    "A trailing slash is a bad request too",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/test-deployment/",
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "get-invalid-namespace",
+			description:    "Namespaces have to be DNS labels",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/Test_NS/test-deployment",
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "post-invalid-deployment",
+			description:    "Deployment names have to be DNS subdomains",
+			method:         http.MethodPost,
+			path:           "/v1/replicas/test/test_deployment",
+			body:           `{"replica_size": 5}`,
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "post-wrong-type",
+			description:    "replica_size has to be a number",
+			method:         http.MethodPost,
+			path:           "/v1/replicas/test/test-deployment",
+			body:           `{"replica_size": "5"}`,
+			expectedCode:   400,
+			expectedScaled: 3,
+		},
+		{
+			name:           "delete-not-allowed",
+			description:    "Only GET and POST are supported",
+			method:         http.MethodDelete,
+			path:           "/v1/replicas/test/test-deployment",
+			expectedCode:   405,
+			expectedScaled: 3,
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClientset, scales, listers := newTestClients(t, newTestDeployment("test", "test-deployment", 3))
+			store := state.NewMemoryStore()
+			key := state.Key{Namespace: "test", Name: "test-deployment"}
+			if test.state != nil {
+				if err := store.Set(context.TODO(), key, test.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := newJSONRequest(test.method, test.path, test.body)
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				replicasRouter(scales, listers, store, nil).ServeHTTP(w, r)
+			})
+			handler.ServeHTTP(rr, req)
+
+			storedState, _, err := store.Get(context.TODO(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deployResp, err := fakeClientset.AppsV1().Deployments("test").Get(context.TODO(), "test-deployment", metav1.GetOptions{})
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Test the input against the output of the function
-			// Also test if the correct values were returned if the key does not exist
 			switch {
-			case test.expectSuccess && !reflect.DeepEqual(testResp, &test.expectedResponse):
-				t.Errorf("Fail: got %v want %v",
-					testResp, &test.expectedResponse)
-			case test.keyExists && !key:
-				t.Errorf("Redis key was present but: got %v want %v", key, test.keyExists)
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			case test.expectedState != nil && !reflect.DeepEqual(storedState, test.expectedState):
+				t.Errorf("Fail: got state %v want %v", storedState, test.expectedState)
+			case *deployResp.Spec.Replicas != test.expectedScaled:
+				t.Errorf("Fail: got %d replicas want %d", *deployResp.Spec.Replicas, test.expectedScaled)
+			case rr.Code == 200 && !json.Valid(rr.Body.Bytes()):
+				t.Errorf("Fail: response is not valid JSON: %s", rr.Body.String())
 			default:
-				t.Logf("test passed %v", testResp)
+				t.Logf("test passed %v", rr.Code)
 			}
+		})
+	}
+}
 
-		},
-		)
+// Tests that the authorization policy is enforced per verb on the replicas endpoint
+func TestV1ReplicasAuthorization(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{
+		Subjects:    auth.Subjects{CommonNames: []string{"reader"}},
+		Namespaces:  []string{"test"},
+		Deployments: []string{"*"},
+		Verbs:       []string{auth.VerbRead},
+	}}}
+
+	testCases := []struct {
+		name         string
+		method       string
+		commonName   string
+		expectedCode int
+	}{
+		{name: "read-allowed", method: http.MethodGet, commonName: "reader", expectedCode: 200},
+		{name: "scale-denied", method: http.MethodPost, commonName: "reader", expectedCode: 403},
+		{name: "unknown-client", method: http.MethodGet, commonName: "mallory", expectedCode: 403},
 	}
 
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, scales, listers := newTestClients(t, newTestDeployment("test", "test-deployment", 1))
+			store := state.NewMemoryStore()
+			handler := auth.Middleware(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				replicasRouter(scales, listers, store, nil).ServeHTTP(w, r)
+			}))
+
+			req := newJSONRequest(test.method, "/v1/replicas/test/test-deployment", `{"replica_size": 2}`)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.commonName}}}}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			}
+		})
+	}
 }
 
-// Test for setting the state via Redis
-func TestSetState(t *testing.T) {
+// Tests the HTTP routing for /v1/replicas/{namespace}/statefulsets/{statefulset}
+// A deployment with the same name is there to make sure the two never share state
+func TestV1StatefulSetReplicas(t *testing.T) {
 	testCases := []struct {
-		name             string
-		description      string
-		expectSuccess    bool
-		redisKey         string
-		replicas         int32
-		expectedResponse redisValue
+		name               string
+		description        string
+		method             string
+		path               string
+		body               string
+		expectedCode       int
+		expectedState      *state.Value
+		expectedScaled     int32
+		expectedDeployment int32
 	}{
 		{
-			name:          "replicas-first-set",
-			description:   "This is the first time the server will set a key",
-			redisKey:      "namespace-first-replicas-deployment",
-			expectSuccess: true,
-			replicas:      4,
-			expectedResponse: redisValue{
-				DesiredReplicas: 4,
-				CurrentReplicas: 4,
-				Drift:           true,
-			},
+			name:           "get-first-time",
+			description:    "The first GET records the current replicas of the StatefulSet",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/statefulsets/shared-name",
+			expectedCode:   200,
+			expectedState:  &state.Value{DesiredReplicas: 0, CurrentReplicas: 2, Drift: true},
+			expectedScaled: 2,
+		},
+		{
+			name:           "post-scale",
+			description:    "A POST patches the StatefulSet and leaves the deployment alone",
+			method:         http.MethodPost,
+			path:           "/v1/replicas/test/statefulsets/shared-name",
+			body:           `{"replica_size": 4}`,
+			expectedCode:   200,
+			expectedState:  &state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedScaled: 4,
+		},
+		{
+			name:           "get-missing-statefulset",
+			description:    "StatefulSets that don't exist return the k8s API error code",
+			method:         http.MethodGet,
+			path:           "/v1/replicas/test/statefulsets/missing",
+			expectedCode:   404,
+			expectedScaled: 2,
 		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClientset, scales, listers := newTestClients(t, newTestDeployment("test", "shared-name", 3), newTestStatefulSet("test", "shared-name", 2))
+			store := state.NewMemoryStore()
+
+			router := mux.NewRouter()
+			router.HandleFunc("/v1/replicas/{namespace}/statefulsets/{statefulset}", func(w http.ResponseWriter, r *http.Request) {
+				V1StatefulSetReplicas(w, r, scales, listers, store, nil)
+			})
 
+			req := newJSONRequest(test.method, test.path, test.body)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			statefulSetState, _, err := store.Get(context.TODO(), state.Key{Kind: state.KindStatefulSet, Namespace: "test", Name: "shared-name"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, deploymentTracked, err := store.Get(context.TODO(), state.Key{Namespace: "test", Name: "shared-name"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			statefulSet, err := fakeClientset.AppsV1().StatefulSets("test").Get(context.TODO(), "shared-name", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deployResp, err := fakeClientset.AppsV1().Deployments("test").Get(context.TODO(), "shared-name", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			case test.expectedState != nil && !reflect.DeepEqual(statefulSetState, test.expectedState):
+				t.Errorf("Fail: got state %v want %v", statefulSetState, test.expectedState)
+			case *statefulSet.Spec.Replicas != test.expectedScaled:
+				t.Errorf("Fail: got %d replicas want %d", *statefulSet.Spec.Replicas, test.expectedScaled)
+			case deploymentTracked || *deployResp.Spec.Replicas != 3:
+				t.Errorf("Fail: the deployment with the same name was touched")
+			case rr.Code == 200 && !strings.Contains(rr.Body.String(), `"statefulset_name":"shared-name"`):
+				t.Errorf("Fail: response is missing the StatefulSet name: %s", rr.Body.String())
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
+
+// Tests the generic /v1/scale/{group}/{resource}/{namespace}/{name} endpoint
+func TestV1Scale(t *testing.T) {
+	testCases := []struct {
+		name           string
+		description    string
+		method         string
+		path           string
+		body           string
+		expectedCode   int
+		expectedKey    state.Key
+		expectedState  *state.Value
+		expectedScaled int32
+		expectedBody   string
+	}{
+		{
+			name:           "replicaset-scale",
+			description:    "Resources without an informer are scaled through the scale subresource and tracked under their resource and group",
+			method:         http.MethodPost,
+			path:           "/v1/scale/apps/replicasets/test/test-replicaset",
+			body:           `{"replica_size": 6}`,
+			expectedCode:   200,
+			expectedKey:    state.Key{Kind: "replicasets.apps", Namespace: "test", Name: "test-replicaset"},
+			expectedState:  &state.Value{DesiredReplicas: 6, CurrentReplicas: 6, Drift: false},
+			expectedScaled: 6,
+			expectedBody:   `"resource":"replicasets.apps","name":"test-replicaset"`,
+		},
+		{
+			name:           "replicaset-get",
+			description:    "The first GET records the current replicas read from the scale subresource",
+			method:         http.MethodGet,
+			path:           "/v1/scale/apps/replicasets/test/test-replicaset",
+			expectedCode:   200,
+			expectedKey:    state.Key{Kind: "replicasets.apps", Namespace: "test", Name: "test-replicaset"},
+			expectedState:  &state.Value{DesiredReplicas: 0, CurrentReplicas: 2, Drift: true},
+			expectedScaled: 2,
+		},
+		{
+			name:           "deployment-shares-state",
+			description:    "Deployments scaled here share their state with /v1/replicas",
+			method:         http.MethodPost,
+			path:           "/v1/scale/apps/deployments/test/test-deployment",
+			body:           `{"replica_size": 4}`,
+			expectedCode:   200,
+			expectedKey:    state.Key{Namespace: "test", Name: "test-deployment"},
+			expectedState:  &state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedScaled: 2,
+			expectedBody:   `"deployment_name":"test-deployment"`,
+		},
 		{
-			name:          "replicas-scale-down",
-			description:   "This will update a key with a new desired and current replicas value",
-			redisKey:      "namespace-replicas-scale-down",
-			expectSuccess: true,
-			replicas:      2,
-			expectedResponse: redisValue{
-				DesiredReplicas: 2,
-				CurrentReplicas: 2,
-				Drift:           false,
-			},
+			name:           "unknown-resource",
+			description:    "Resources that can't be scaled are not found",
+			method:         http.MethodGet,
+			path:           "/v1/scale/example.com/widgets/test/test-widget",
+			expectedCode:   404,
+			expectedScaled: 2,
 		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			replicas := int32(2)
+			replicaSet := &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-replicaset", Namespace: "test"},
+				Spec:       appsv1.ReplicaSetSpec{Replicas: &replicas},
+			}
+			fakeClientset, scales, listers := newTestClients(t, replicaSet, newTestDeployment("test", "test-deployment", 3))
+			store := state.NewMemoryStore()
+
+			router := mux.NewRouter()
+			router.HandleFunc("/v1/scale/{group}/{resource}/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+				V1Scale(w, r, scales, listers, store, nil)
+			})
 
+			req := newJSONRequest(test.method, test.path, test.body)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			storedState, _, err := store.Get(context.TODO(), test.expectedKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replicaSetResp, err := fakeClientset.AppsV1().ReplicaSets("test").Get(context.TODO(), "test-replicaset", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			case test.expectedState != nil && !reflect.DeepEqual(storedState, test.expectedState):
+				t.Errorf("Fail: got state %v want %v", storedState, test.expectedState)
+			case *replicaSetResp.Spec.Replicas != test.expectedScaled:
+				t.Errorf("Fail: got %d replicas want %d", *replicaSetResp.Spec.Replicas, test.expectedScaled)
+			case !strings.Contains(rr.Body.String(), test.expectedBody):
+				t.Errorf("Fail: response is missing %s: %s", test.expectedBody, rr.Body.String())
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
+
+// Tests GET hands out an ETag and POST with an If-Match only scales when it still matches
+func TestV1ReplicasIfMatch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		description    string
+		ifMatch        func(etag string) string
+		changeState    bool
+		expectedCode   int
+		expectedScaled int32
+	}{
 		{
-			name:          "replicas-scale-up",
-			description:   "This will update a key with a new desired and current replicas value",
-			redisKey:      "namespace-replicas-scale-up",
-			expectSuccess: true,
-			replicas:      6,
-			expectedResponse: redisValue{
-				DesiredReplicas: 6,
-				CurrentReplicas: 6,
-				Drift:           false,
-			},
+			name:           "matching",
+			description:    "The ETag from the GET lets the POST through",
+			ifMatch:        func(etag string) string { return etag },
+			expectedCode:   200,
+			expectedScaled: 5,
+		},
+		{
+			name:           "one-of-many",
+			description:    "Any ETag in the list can match",
+			ifMatch:        func(etag string) string { return `"stale", ` + etag },
+			expectedCode:   200,
+			expectedScaled: 5,
+		},
+		{
+			name:           "any",
+			description:    "* matches whatever version exists",
+			ifMatch:        func(etag string) string { return "*" },
+			expectedCode:   200,
+			expectedScaled: 5,
+		},
+		{
+			name:           "stale",
+			description:    "An old ETag is rejected without scaling",
+			ifMatch:        func(etag string) string { return `"stale"` },
+			expectedCode:   412,
+			expectedScaled: 3,
+		},
+		{
+			name:           "weak",
+			description:    "Weak ETags never match an If-Match",
+			ifMatch:        func(etag string) string { return "W/" + etag },
+			expectedCode:   412,
+			expectedScaled: 3,
+		},
+		{
+			name:           "state-changed",
+			description:    "Someone else setting the desired replicas after the GET invalidates the ETag",
+			ifMatch:        func(etag string) string { return etag },
+			changeState:    true,
+			expectedCode:   412,
+			expectedScaled: 3,
 		},
 	}
 
 	// Loop to run each test case
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("test", "test-deployment", 3)
+			deployment.ResourceVersion = "10"
+			fakeClientset, scales, listers := newTestClients(t, deployment)
+			store := state.NewMemoryStore()
+			key := state.Key{Namespace: "test", Name: "test-deployment"}
+			if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 3, CurrentReplicas: 3, Drift: false}); err != nil {
+				t.Fatal(err)
+			}
 
-			// Init mock Redis client
-			db, mock := redismock.NewClientMock()
+			getRR := httptest.NewRecorder()
+			replicasRouter(scales, listers, store, nil).ServeHTTP(getRR, httptest.NewRequest(http.MethodGet, "/v1/replicas/test/test-deployment", nil))
+			etag := getRR.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("Fail: GET returned no ETag")
+			}
 
-			var rSetValues redisValue
-			if !test.expectSuccess {
-				rSetValues = redisValue{DesiredReplicas: test.replicas, CurrentReplicas: test.replicas, Drift: false}
-			} else {
-				rSetValues = test.expectedResponse
+			if test.changeState {
+				if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 4, CurrentReplicas: 3, Drift: true}); err != nil {
+					t.Fatal(err)
+				}
 			}
 
-			// To match we are marshalling to JSON as well
-			rSetJson, err := json.Marshal(rSetValues)
+			req := newJSONRequest(http.MethodPost, "/v1/replicas/test/test-deployment", `{"replica_size": 5}`)
+			req.Header.Set("If-Match", test.ifMatch(etag))
+			rr := httptest.NewRecorder()
+			replicasRouter(scales, listers, store, nil).ServeHTTP(rr, req)
+
+			replicas, err := testReplicas(fakeClientset, "deployments", "test", "test-deployment")
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			mock.ExpectSet(test.redisKey, rSetJson, 0).SetVal("")
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case replicas != test.expectedScaled:
+				t.Errorf("Fail: got %d replicas want %d", replicas, test.expectedScaled)
+			case rr.Code == 200 && (rr.Header().Get("ETag") == "" || rr.Header().Get("ETag") == etag):
+				t.Errorf("Fail: got ETag %q after scaling, want a new one", rr.Header().Get("ETag"))
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
+
+// Tests a dry run validates the request without touching the deployment, its state or its history
+func TestV1ReplicasDryRun(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{
+		Subjects:    auth.Subjects{CommonNames: []string{"deployer"}},
+		Namespaces:  []string{"test"},
+		Deployments: []string{"*"},
+		Verbs:       []string{auth.VerbScale},
+	}}}
+
+	testCases := []struct {
+		name             string
+		description      string
+		path             string
+		commonName       string
+		expectedCode     int
+		expectedResponse *setReplicasResponse
+	}{
+		{
+			name:         "dry-run",
+			description:  "Returns what the scale would do",
+			path:         "/v1/replicas/test/test-deployment?dryRun=true",
+			expectedCode: 200,
+			expectedResponse: &setReplicasResponse{Code: 200, Namespace: "test", workloadName: workloadName{Deployment: "test-deployment"},
+				CurrentReplicas: 3, DesiredReplicas: 3, RequestedReplicas: 5, DryRun: true},
+		},
+		{
+			name:         "missing-deployment",
+			description:  "Fails the same way the scale would",
+			path:         "/v1/replicas/test/missing-deployment?dryRun=true",
+			expectedCode: 404,
+		},
+		{
+			name:         "unauthorized",
+			description:  "Authorization still applies",
+			path:         "/v1/replicas/test/test-deployment?dryRun=true",
+			commonName:   "mallory",
+			expectedCode: 403,
+		},
+		{
+			name:         "bad-value",
+			description:  "dryRun must be a boolean",
+			path:         "/v1/replicas/test/test-deployment?dryRun=maybe",
+			expectedCode: 400,
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClientset, scales, listers := newTestClients(t, newTestDeployment("test", "test-deployment", 3))
+			store := state.NewMemoryStore()
+			key := state.Key{Namespace: "test", Name: "test-deployment"}
+			before := &state.Value{DesiredReplicas: 3, CurrentReplicas: 3, Drift: false}
+			if err := store.Set(context.TODO(), key, before); err != nil {
+				t.Fatal(err)
+			}
+
+			handler := auth.Middleware(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				replicasRouter(scales, listers, store, nil).ServeHTTP(w, r)
+			}))
 
-			// Run the function with the mock client and stubbed data
-			testResp, err := setState(db, test.redisKey, &test.expectedResponse, test.replicas)
+			commonName := test.commonName
+			if commonName == "" {
+				commonName = "deployer"
+			}
+			req := newJSONRequest(http.MethodPost, test.path, `{"replica_size": 5}`)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: commonName}}}}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			replicas, err := testReplicas(fakeClientset, "deployments", "test", "test-deployment")
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, _, err := store.Get(context.TODO(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history, total, err := store.History(context.TODO(), key, 0, 10)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Test the input against the output of the function
+			var resp *setReplicasResponse
+			if test.expectedResponse != nil {
+				resp = &setReplicasResponse{}
+				if err := json.Unmarshal(rr.Body.Bytes(), resp); err != nil {
+					t.Fatal(err)
+				}
+			}
+
 			switch {
-			case test.expectSuccess && !reflect.DeepEqual(testResp, &test.expectedResponse):
-				t.Errorf("Fail: got %v want %v",
-					testResp, &test.expectedResponse)
-			case !test.expectSuccess && reflect.DeepEqual(testResp, &test.expectedResponse):
-				t.Errorf("Fail: got %v want %v", testResp, test.expectSuccess)
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case !reflect.DeepEqual(resp, test.expectedResponse):
+				t.Errorf("Fail: got response %+v want %+v", resp, test.expectedResponse)
+			case replicas != 3:
+				t.Errorf("Fail: got %d replicas want the deployment untouched", replicas)
+			case !reflect.DeepEqual(after, before):
+				t.Errorf("Fail: got state %v want it untouched", after)
+			case total != 0:
+				t.Errorf("Fail: got history %v want none", history)
 			default:
-				t.Logf("test passed %v", testResp)
+				t.Logf("test passed %v", rr.Code)
 			}
+		})
+	}
+}
+
+// Tests scales breaking the replica limits are rejected with the limits they broke and nothing changes
+func TestV1ReplicasLimits(t *testing.T) {
+	maxReplicas := int32(10)
+	policy := &limits.Policy{Rules: []limits.Rule{{Name: "test", Namespaces: []string{"test"}, MaxReplicas: &maxReplicas}}}
 
+	testCases := []struct {
+		name               string
+		description        string
+		body               string
+		annotations        map[string]string
+		expectedCode       int
+		expectedViolations []string
+		expectedScaled     int32
+	}{
+		{
+			name:           "allowed",
+			description:    "Scales within the limits go through",
+			body:           `{"replica_size": 5}`,
+			expectedCode:   200,
+			expectedScaled: 5,
+		},
+		{
+			name:               "negative",
+			description:        "Negative replicas are never allowed",
+			body:               `{"replica_size": -1}`,
+			expectedCode:       422,
+			expectedViolations: []string{limits.LimitMinReplicas},
+			expectedScaled:     3,
+		},
+		{
+			name:               "above-rule",
+			description:        "The limits file caps the replicas",
+			body:               `{"replica_size": 100000}`,
+			expectedCode:       422,
+			expectedViolations: []string{limits.LimitMaxReplicas},
+			expectedScaled:     3,
+		},
+		{
+			name:               "annotations",
+			description:        "Annotations on the deployment apply on top of the limits file",
+			body:               `{"replica_size": 9}`,
+			annotations:        map[string]string{limits.AnnotationMaxStep: "2"},
+			expectedCode:       422,
+			expectedViolations: []string{limits.LimitMaxStep},
+			expectedScaled:     3,
 		},
-		)
 	}
 
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("test", "test-deployment", 3)
+			deployment.Annotations = test.annotations
+			fakeClientset, scales, listers := newTestClients(t, deployment)
+			store := state.NewMemoryStore()
+
+			req := newJSONRequest(http.MethodPost, "/v1/replicas/test/test-deployment", test.body)
+			rr := httptest.NewRecorder()
+			replicasRouter(scales, listers, store, policy).ServeHTTP(rr, req)
+
+			replicas, err := testReplicas(fakeClientset, "deployments", "test", "test-deployment")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resp struct {
+				Type       string             `json:"type"`
+				Violations []limits.Violation `json:"violations"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var violations []string
+			for _, violation := range resp.Violations {
+				violations = append(violations, violation.Limit)
+			}
+
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case !reflect.DeepEqual(violations, test.expectedViolations):
+				t.Errorf("Fail: got violations %v want %v", violations, test.expectedViolations)
+			case violations != nil && resp.Type != e.KindPolicyViolation.Type:
+				t.Errorf("Fail: got type %q want %q", resp.Type, e.KindPolicyViolation.Type)
+			case replicas != test.expectedScaled:
+				t.Errorf("Fail: got %d replicas want %d", replicas, test.expectedScaled)
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
 }
@@ -0,0 +1,429 @@
+package replicas
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	// Kubernetes packages
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/scale"
+)
+
+// Most deployments a single batch can scale, bigger batches have to be split up
+const maxBatchItems = 500
+
+// How many deployments of a batch are scaled at the same time
+const batchConcurrency = 10
+
+// Largest relative change of a batch, far more replicas than any cluster runs
+const maxReplicaChange = 1000000
+
+// What happened to each deployment of a batch
+const (
+	// The deployment was scaled
+	batchScaled = "scaled"
+	// The dry run of the scale passed
+	batchValidated = "validated"
+	// The scale failed, see the error
+	batchFailed = "failed"
+	// Not scaled because another deployment of an atomic batch failed
+	batchSkipped = "skipped"
+	// Scaled and then scaled back because another deployment of an atomic batch failed
+	batchRolledBack = "rolled_back"
+	// Scaled but scaling it back failed, see the error
+	batchRollbackFailed = "rollback_failed"
+	// Nothing to do, like hibernating a deployment that is already hibernated
+	batchUnchanged = "unchanged"
+)
+
+// Parse incoming payload from client, deployments are picked by a selector in a namespace or listed one by one
+type batchRequest struct {
+	Namespace     string          `json:"namespace"`
+	Selector      string          `json:"selector"`
+	Deployments   []batchWorkload `json:"deployments"`
+	ReplicaSize   *int32          `json:"replica_size"`
+	ReplicaChange *int32          `json:"replica_change"`
+	Atomic        bool            `json:"atomic"`
+	// Parsed from Selector when the request is validated
+	parsed labels.Selector
+}
+
+// A deployment in a batch request
+type batchWorkload struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment_name"`
+}
+
+// Response to client for a batch, with the result for every deployment
+type batchResponse struct {
+	Items      []batchItemResult `json:"items"`
+	Succeeded  int               `json:"succeeded"`
+	Failed     int               `json:"failed"`
+	RolledBack bool              `json:"rolled_back,omitempty"`
+	DryRun     bool              `json:"dry_run,omitempty"`
+	Code       int               `json:"http_status_code"`
+}
+
+// Result of scaling a single deployment of a batch
+type batchItemResult struct {
+	Namespace         string             `json:"namespace"`
+	Deployment        string             `json:"deployment_name"`
+	Result            string             `json:"result"`
+	CurrentReplicas   int32              `json:"current_replicas"`
+	RequestedReplicas int32              `json:"requested_replicas"`
+	Code              int                `json:"http_status_code"`
+	ErrorType         string             `json:"error_type,omitempty"`
+	Error             string             `json:"error,omitempty"`
+	Violations        []limits.Violation `json:"violations,omitempty"`
+	// State from before the scale and the state it wrote, for rolling back an atomic batch
+	previous *state.Value
+	written  *state.Value
+}
+
+// Records why a deployment of a batch failed
+func (result *batchItemResult) fail(err error) {
+	problem := scaleProblem(err)
+	result.Result = batchFailed
+	result.Code = problem.Status
+	result.ErrorType = problem.Kind.Type
+	result.Error = problem.Detail
+	if limitsError, isLimits := err.(*limits.Error); isLimits {
+		result.Violations = limitsError.Violations
+	}
+}
+
+// Handles the /v1/replicas:batch endpoint, scaling many deployments at once
+func V1ReplicasBatch(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) {
+	switch r.Method {
+	case http.MethodPost:
+		var req batchRequest
+		err := validation.DecodeJSON(r, &req)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		err = req.validate()
+		if err != nil {
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+
+		items, err := req.workloads(listers)
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error listing deployments for a batch: %w", err))
+			return
+		}
+		if len(items) > maxBatchItems {
+			responses.ReturnError(w, e.Validation("batch matches %d deployments, at most %d can be scaled at once", len(items), maxBatchItems))
+			return
+		}
+
+		resp := runBatch(r, scales, deploymentWorkloads{listers.Deployments}, store, replicaLimits, &req, items, dryRun)
+		responses.ReturnJsonResponse(w, resp.Code, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Checks the request picks deployments one way and replicas one way
+func (req *batchRequest) validate() error {
+	switch {
+	case req.Selector != "" && len(req.Deployments) > 0:
+		return fmt.Errorf("use either selector or deployments, not both")
+	case req.Selector != "" && req.Namespace == "":
+		return fmt.Errorf("selector needs a namespace")
+	case req.Selector == "" && len(req.Deployments) == 0:
+		return fmt.Errorf("selector or deployments is required")
+	case (req.ReplicaSize == nil) == (req.ReplicaChange == nil):
+		return fmt.Errorf("use exactly one of replica_size or replica_change")
+	case req.ReplicaChange != nil && (*req.ReplicaChange > maxReplicaChange || *req.ReplicaChange < -maxReplicaChange):
+		return fmt.Errorf("replica_change must be between %d and %d, got %d", -maxReplicaChange, maxReplicaChange, *req.ReplicaChange)
+	}
+
+	for i, item := range req.Deployments {
+		if err := validation.NamespacedName(item.Namespace, "deployment_name", item.Deployment); err != nil {
+			return fmt.Errorf("deployments[%d]: %s", i, err)
+		}
+	}
+	if req.Namespace != "" {
+		if err := validation.Namespace(req.Namespace); err != nil {
+			return err
+		}
+	}
+
+	if req.Selector != "" {
+		selector, err := labels.Parse(req.Selector)
+		if err != nil {
+			return fmt.Errorf("invalid selector: %s", err)
+		}
+		req.parsed = selector
+	}
+
+	return nil
+}
+
+// Lists the deployments the batch applies to, sorted so results come back in a stable order
+func (req *batchRequest) workloads(listers Listers) ([]batchWorkload, error) {
+	if req.parsed == nil {
+		// Scaling the same deployment twice in one batch would race with itself
+		seen := map[batchWorkload]bool{}
+		items := []batchWorkload{}
+		for _, item := range req.Deployments {
+			if !seen[item] {
+				seen[item] = true
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+
+	deployList, err := listers.Deployments.Deployments(req.Namespace).List(req.parsed)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]batchWorkload, 0, len(deployList))
+	for _, deployment := range deployList {
+		items = append(items, batchWorkload{Namespace: deployment.Namespace, Deployment: deployment.Name})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deployment < items[j].Deployment })
+
+	return items, nil
+}
+
+// Scales every deployment of a batch, an atomic batch is dry run first and rolled back if a scale still fails
+func runBatch(r *http.Request, scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy,
+	req *batchRequest, items []batchWorkload, dryRun bool) *batchResponse {
+	resp := &batchResponse{DryRun: dryRun}
+	opts := scaleOptions{Actor: auth.FromRequest(r).String(), DryRun: dryRun}
+
+	// Catch what would fail before scaling anything
+	if req.Atomic && !dryRun {
+		validated := forEachItem(items, func(item batchWorkload) batchItemResult {
+			return scaleBatchItem(r, scales, target, store, replicaLimits, req, item, scaleOptions{Actor: opts.Actor, DryRun: true})
+		})
+		if countResults(validated, batchFailed) > 0 {
+			for i := range validated {
+				if validated[i].Result != batchFailed {
+					validated[i].Result = batchSkipped
+				}
+			}
+			return finishBatch(resp, validated)
+		}
+	}
+
+	results := forEachItem(items, func(item batchWorkload) batchItemResult {
+		return scaleBatchItem(r, scales, target, store, replicaLimits, req, item, opts)
+	})
+
+	if req.Atomic && !dryRun && countResults(results, batchFailed) > 0 {
+		resp.RolledBack = true
+		forEachResult(results, func(result *batchItemResult) {
+			if result.Result != batchScaled {
+				return
+			}
+			err := rollbackItem(scales, target, store, opts.Actor, result)
+			if err != nil {
+				logger.Log.Errorf("error rolling back %s/%s: %s", result.Namespace, result.Deployment, err)
+				result.Result = batchRollbackFailed
+				result.Error = fmt.Sprintf("rolling back to %d replicas failed: %s", result.CurrentReplicas, scaleProblem(err).Detail)
+				return
+			}
+			result.Result = batchRolledBack
+		})
+	}
+
+	return finishBatch(resp, results)
+}
+
+// Puts a deployment of an atomic batch back the way it was, its state included, and records the scale back in the history
+// Limits don't apply to putting back what was there before, but it is only scaled back if nothing else scaled it since
+func rollbackItem(scales scale.ScalesGetter, target workloads, store state.StateStore, actor string, result *batchItemResult) error {
+	key := state.Key{Kind: target.kind(), Namespace: result.Namespace, Name: result.Deployment}
+
+	// The state goes back first so the watch event of the scale back isn't flagged as drift
+	if err := restoreState(store, key, result.previous, result.written); err != nil {
+		logger.Log.Errorf("error rolling back state for %s: %s", key, err)
+	}
+
+	_, err := scaleReplicas(scales, target, result.Namespace, result.Deployment, result.CurrentReplicas, &result.RequestedReplicas, false)
+	recordHistory(store, key, actor, result.RequestedReplicas, result.CurrentReplicas, state.ReasonRollback, err)
+	if err != nil {
+		// The deployment is still scaled, so is the state again unless something else wrote it in between
+		if rollbackErr := restoreState(store, key, result.written, result.previous); rollbackErr != nil {
+			logger.Log.Errorf("error restoring state for %s: %s", key, rollbackErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Scales a single deployment of a batch, the client has to be allowed to scale each one
+func scaleBatchItem(r *http.Request, scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy,
+	req *batchRequest, item batchWorkload, opts scaleOptions) batchItemResult {
+	if result, ok := authorizeItem(r, target, item); !ok {
+		return result
+	}
+
+	return scaleItem(scales, target, store, replicaLimits, item, opts, func(live *liveWorkload) int32 {
+		return batchReplicas(req, live.Replicas)
+	})
+}
+
+// Checks the client is allowed to scale a deployment of a batch, the result is the failure to report if not
+func authorizeItem(r *http.Request, target workloads, item batchWorkload) (batchItemResult, bool) {
+	allowed, err := auth.Authorized(r, auth.VerbScale, target.resource(), item.Namespace, item.Deployment)
+	if err == nil && allowed {
+		return batchItemResult{}, true
+	}
+
+	result := batchItemResult{Namespace: item.Namespace, Deployment: item.Deployment}
+	if err != nil {
+		result.fail(err)
+		return result, false
+	}
+	result.fail(e.Forbidden("%s is not allowed to scale %s %s/%s", auth.FromRequest(r), target.resource(), item.Namespace, item.Deployment))
+
+	return result, false
+}
+
+// Scales a single deployment to the replicas worked out from its live replicas and reports how it went
+func scaleItem(scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy,
+	item batchWorkload, opts scaleOptions, replicasFor func(live *liveWorkload) int32) batchItemResult {
+	result := batchItemResult{Namespace: item.Namespace, Deployment: item.Deployment}
+
+	live, err := target.get(item.Namespace, item.Deployment)
+	if err != nil {
+		result.fail(err)
+		return result
+	}
+	result.CurrentReplicas = live.Replicas
+	result.RequestedReplicas = replicasFor(live)
+
+	resp, _, err := setReplicas(scales, target, store, replicaLimits, item.Namespace, item.Deployment, result.RequestedReplicas, opts)
+	if err != nil {
+		result.fail(err)
+		return result
+	}
+
+	// Rollbacks go back to the replicas the scale actually started from
+	result.CurrentReplicas = resp.CurrentReplicas
+	result.previous, result.written = resp.previous, resp.written
+	result.Code = 200
+	result.Result = batchScaled
+	if opts.DryRun {
+		result.Result = batchValidated
+	}
+
+	return result
+}
+
+// Works out the replicas a deployment of a batch is scaled to
+// The sum can't wrap around, it stops at the most replicas there can be and the limits reject it from there
+func batchReplicas(req *batchRequest, current int32) int32 {
+	if req.ReplicaSize != nil {
+		return *req.ReplicaSize
+	}
+
+	replicas := int64(current) + int64(*req.ReplicaChange)
+	if replicas > math.MaxInt32 {
+		return math.MaxInt32
+	}
+
+	return int32(replicas)
+}
+
+// Runs fn for every item with at most batchConcurrency at once, keeping the results in the same order as the items
+func forEachItem(items []batchWorkload, fn func(item batchWorkload) batchItemResult) []batchItemResult {
+	results := make([]batchItemResult, len(items))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item batchWorkload) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = batchItemResult{Namespace: item.Namespace, Deployment: item.Deployment}
+			defer recoverItem(&results[i])
+			results[i] = fn(item)
+		}(i, item)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Runs fn for every result with at most batchConcurrency at once
+func forEachResult(results []batchItemResult, fn func(result *batchItemResult)) {
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *batchItemResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer recoverItem(result)
+			fn(result)
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// Turns a panic while handling a deployment of a batch into a failed result
+// The recovery middleware only covers the goroutine of the handler, a panic in any other takes the whole server down
+func recoverItem(result *batchItemResult) {
+	err := recover()
+	if err == nil {
+		return
+	}
+
+	logger.Log.Errorf("recovered from panic handling %s/%s in a batch: %v\n%s", result.Namespace, result.Deployment, err, debug.Stack())
+	result.fail(e.Internal(fmt.Errorf("panic: %v", err)))
+}
+
+// Counts the deployments of a batch that ended up with any of the results
+func countResults(results []batchItemResult, wanted ...string) int {
+	count := 0
+	for _, result := range results {
+		for _, want := range wanted {
+			if result.Result == want {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// Fills in the totals, a batch where anything didn't go through is a 207 since the client has to look at every result
+func finishBatch(resp *batchResponse, results []batchItemResult) *batchResponse {
+	resp.Items = results
+	resp.Failed = countResults(results, batchFailed, batchRollbackFailed)
+	resp.Succeeded = countResults(results, batchScaled, batchValidated, batchUnchanged)
+	resp.Code = 200
+	if resp.Succeeded < len(results) {
+		resp.Code = 207
+	}
+
+	return resp
+}
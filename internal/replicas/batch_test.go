@@ -0,0 +1,253 @@
+package replicas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/limits"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakescale "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Tests scaling many deployments at once, with and without all-or-nothing
+func TestV1ReplicasBatch(t *testing.T) {
+	testCases := []struct {
+		name            string
+		description     string
+		path            string
+		body            string
+		failUpdate      string
+		expectedCode    int
+		expectedResults map[string]string
+		expectedScaled  map[string]int32
+	}{
+		{
+			name:            "selector",
+			description:     "Scales every deployment matching the selector in the namespace",
+			body:            `{"namespace": "test", "selector": "app=web", "replica_size": 4}`,
+			expectedCode:    200,
+			expectedResults: map[string]string{"web-a": batchScaled, "web-b": batchScaled},
+			expectedScaled:  map[string]int32{"web-a": 4, "web-b": 4, "worker": 2},
+		},
+		{
+			name:            "list-relative",
+			description:     "Adds replicas to the listed deployments",
+			body:            `{"deployments": [{"namespace": "test", "deployment_name": "web-a"}, {"namespace": "test", "deployment_name": "worker"}], "replica_change": 2}`,
+			expectedCode:    200,
+			expectedResults: map[string]string{"web-a": batchScaled, "worker": batchScaled},
+			expectedScaled:  map[string]int32{"web-a": 3, "web-b": 3, "worker": 4},
+		},
+		{
+			name:            "partial-failure",
+			description:     "Without atomic the deployments that can be scaled are",
+			body:            `{"namespace": "test", "selector": "app=web", "replica_size": 5}`,
+			expectedCode:    207,
+			expectedResults: map[string]string{"web-a": batchScaled, "web-b": batchFailed},
+			expectedScaled:  map[string]int32{"web-a": 5, "web-b": 3, "worker": 2},
+		},
+		{
+			name:            "atomic-validation-failure",
+			description:     "An atomic batch doesn't scale anything if a dry run fails",
+			body:            `{"namespace": "test", "selector": "app=web", "replica_size": 5, "atomic": true}`,
+			expectedCode:    207,
+			expectedResults: map[string]string{"web-a": batchSkipped, "web-b": batchFailed},
+			expectedScaled:  map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:            "atomic-rollback",
+			description:     "An atomic batch scales back what it scaled when a scale fails after the dry run passed",
+			body:            `{"namespace": "test", "selector": "app=web", "replica_size": 2, "atomic": true}`,
+			failUpdate:      "web-b",
+			expectedCode:    207,
+			expectedResults: map[string]string{"web-a": batchRolledBack, "web-b": batchFailed},
+			expectedScaled:  map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:            "dry-run",
+			description:     "A dry run validates every deployment without scaling",
+			path:            "/v1/replicas:batch?dryRun=true",
+			body:            `{"namespace": "test", "selector": "app=web", "replica_size": 2}`,
+			expectedCode:    200,
+			expectedResults: map[string]string{"web-a": batchValidated, "web-b": batchValidated},
+			expectedScaled:  map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:            "missing-deployment",
+			description:     "Listed deployments that don't exist fail on their own",
+			body:            `{"deployments": [{"namespace": "test", "deployment_name": "missing"}], "replica_size": 2}`,
+			expectedCode:    207,
+			expectedResults: map[string]string{"missing": batchFailed},
+			expectedScaled:  map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:           "both-pickers",
+			description:    "Deployments are picked either by selector or by list",
+			body:           `{"namespace": "test", "selector": "app=web", "deployments": [{"namespace": "test", "deployment_name": "worker"}], "replica_size": 2}`,
+			expectedCode:   400,
+			expectedScaled: map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:           "both-sizes",
+			description:    "Replicas are either absolute or relative",
+			body:           `{"namespace": "test", "selector": "app=web", "replica_size": 2, "replica_change": 1}`,
+			expectedCode:   400,
+			expectedScaled: map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:           "huge-change",
+			description:    "A relative change that would overflow the replicas is rejected up front",
+			body:           `{"namespace": "test", "selector": "app=web", "replica_change": 2147483647}`,
+			expectedCode:   400,
+			expectedScaled: map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+		{
+			name:           "bad-selector",
+			description:    "The selector has to parse",
+			body:           `{"namespace": "test", "selector": "app in web", "replica_size": 2}`,
+			expectedCode:   400,
+			expectedScaled: map[string]int32{"web-a": 1, "web-b": 3, "worker": 2},
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			webA := newTestDeployment("test", "web-a", 1)
+			webA.Labels = map[string]string{"app": "web"}
+			webB := newTestDeployment("test", "web-b", 3)
+			webB.Labels = map[string]string{"app": "web"}
+			webB.Annotations = map[string]string{limits.AnnotationMaxReplicas: "4"}
+			fakeClientset, scales, listers := newTestClients(t, webA, webB, newTestDeployment("test", "worker", 2))
+			if test.failUpdate != "" {
+				scales.(dryRunScales).ScalesGetter.(*fakescale.FakeScaleClient).PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					if action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale).Name == test.failUpdate {
+						return true, nil, errors.NewInternalError(fmt.Errorf("etcd is on fire"))
+					}
+					return false, nil, nil
+				})
+			}
+			store := state.NewMemoryStore()
+
+			path := test.path
+			if path == "" {
+				path = "/v1/replicas:batch"
+			}
+			req := newJSONRequest(http.MethodPost, path, test.body)
+			rr := httptest.NewRecorder()
+			V1ReplicasBatch(rr, req, scales, listers, store, nil)
+
+			var resp batchResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var results map[string]string
+			for _, item := range resp.Items {
+				if results == nil {
+					results = map[string]string{}
+				}
+				results[item.Deployment] = item.Result
+			}
+			scaled := map[string]int32{}
+			for name := range test.expectedScaled {
+				replicas, err := testReplicas(fakeClientset, "deployments", "test", name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				scaled[name] = replicas
+			}
+
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case !reflect.DeepEqual(results, test.expectedResults):
+				t.Errorf("Fail: got results %v want %v", results, test.expectedResults)
+			case !reflect.DeepEqual(scaled, test.expectedScaled):
+				t.Errorf("Fail: got replicas %v want %v", scaled, test.expectedScaled)
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
+
+// Tests rolling back an atomic batch puts the previous state back as it was and records the scale back as a rollback
+func TestV1ReplicasBatchRollbackState(t *testing.T) {
+	webA := newTestDeployment("test", "web-a", 1)
+	webA.Labels = map[string]string{"app": "web"}
+	webB := newTestDeployment("test", "web-b", 3)
+	webB.Labels = map[string]string{"app": "web"}
+	_, scales, listers := newTestClients(t, webA, webB)
+	scales.(dryRunScales).ScalesGetter.(*fakescale.FakeScaleClient).PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale).Name == "web-b" {
+			return true, nil, errors.NewInternalError(fmt.Errorf("etcd is on fire"))
+		}
+		return false, nil, nil
+	})
+
+	store := state.NewMemoryStore()
+	key := state.Key{Namespace: "test", Name: "web-a"}
+	hibernated := int32(4)
+	previous := &state.Value{DesiredReplicas: 2, CurrentReplicas: 1, Drift: true, HibernatedReplicas: &hibernated}
+	if err := store.Set(context.TODO(), key, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	req := newJSONRequest(http.MethodPost, "/v1/replicas:batch", `{"namespace": "test", "selector": "app=web", "replica_size": 2, "atomic": true}`)
+	rr := httptest.NewRecorder()
+	V1ReplicasBatch(rr, req, scales, listers, store, nil)
+
+	storedState, _, err := store.Get(context.TODO(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, total, err := store.History(context.TODO(), key, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case rr.Code != 207:
+		t.Errorf("handler returned wrong status code: got %v want 207: %s", rr.Code, rr.Body.String())
+	case !reflect.DeepEqual(storedState, previous):
+		t.Errorf("Fail: got state %v want %v", storedState, previous)
+	case total != 2:
+		t.Errorf("Fail: got %d history entries want the scale and its rollback", total)
+	case entries[0].Reason != state.ReasonRollback || entries[0].FromReplicas != 2 || entries[0].ToReplicas != 1 || entries[0].Result != state.ResultSuccess:
+		t.Errorf("Fail: got newest history entry %+v want a successful rollback from 2 to 1", entries[0])
+	case entries[1].Reason != "":
+		t.Errorf("Fail: got reason %q on the scale want none", entries[1].Reason)
+	default:
+		t.Logf("test passed %v", storedState)
+	}
+}
+
+// Tests a panic while scaling one deployment of a batch fails only that deployment
+func TestForEachItemPanic(t *testing.T) {
+	items := []batchWorkload{{Namespace: "test", Deployment: "web-a"}, {Namespace: "test", Deployment: "web-b"}}
+
+	results := forEachItem(items, func(item batchWorkload) batchItemResult {
+		if item.Deployment == "web-b" {
+			panic("boom")
+		}
+		return batchItemResult{Namespace: item.Namespace, Deployment: item.Deployment, Result: batchScaled, Code: 200}
+	})
+	forEachResult(results[:1], func(result *batchItemResult) {
+		panic("boom")
+	})
+
+	for _, result := range results {
+		if result.Result != batchFailed || result.Code != http.StatusInternalServerError || result.Namespace != "test" {
+			t.Errorf("Fail: got %+v want a failed internal error", result)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package replicas
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	// internal packages
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+)
+
+// Returned when the If-Match of a request doesn't match the workload anymore, sent to the client as a 412
+var errPreconditionFailed = e.PreconditionFailed("the workload or its state changed since it was read, GET it again for a new ETag")
+
+// Builds a strong ETag from the resourceVersion of the workload and its state in the state store
+// Any change to either, including status updates by Kubernetes controllers, gives a new ETag
+func computeETag(resourceVersion string, value *state.Value) string {
+	stored := "none"
+	if value != nil {
+		stored = fmt.Sprintf("%d/%d/%t", value.DesiredReplicas, value.CurrentReplicas, value.Drift)
+		if value.HibernatedReplicas != nil {
+			stored += fmt.Sprintf("/%d", *value.HibernatedReplicas)
+		}
+	}
+	sum := sha256.Sum256([]byte(resourceVersion + "|" + stored))
+
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// Checks an If-Match header against the current ETag with the strong comparison RFC 7232 asks for
+// "*" matches anything that exists and weak ETags never match
+func etagMatches(ifMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,147 @@
+package replicas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	// Kubernetes packages
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/scale"
+
+	"github.com/gorilla/mux"
+)
+
+// Handles the /v1/namespaces/{namespace}:hibernate endpoint, scaling every deployment in the namespace to 0
+// The replicas each deployment had are kept in the state store for :wake
+func V1NamespaceHibernate(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy) {
+	serveNamespace(w, r, listers, func(target workloads, item batchWorkload, dryRun bool) batchItemResult {
+		return hibernateItem(r, scales, target, store, replicaLimits, item, dryRun)
+	})
+}
+
+// Handles the /v1/namespaces/{namespace}:wake endpoint, scaling every hibernated deployment in the namespace back up
+func V1NamespaceWake(w http.ResponseWriter, r *http.Request, scales scale.ScalesGetter, listers Listers, store state.StateStore) {
+	serveNamespace(w, r, listers, func(target workloads, item batchWorkload, dryRun bool) batchItemResult {
+		return wakeItem(r, scales, target, store, item, dryRun)
+	})
+}
+
+// Runs a hibernate or wake on every deployment in the namespace like a batch
+func serveNamespace(w http.ResponseWriter, r *http.Request, listers Listers, fn func(target workloads, item batchWorkload, dryRun bool) batchItemResult) {
+	switch r.Method {
+	case http.MethodPost:
+		dryRun, err := parseDryRun(r)
+		if err != nil {
+			responses.ReturnError(w, e.Validation("%s", err))
+			return
+		}
+
+		namespace := mux.Vars(r)["namespace"]
+		if err := validation.Namespace(namespace); err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		deployList, err := listers.Deployments.Deployments(namespace).List(labels.Everything())
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error listing deployments in %s: %w", namespace, err))
+			return
+		}
+		items := make([]batchWorkload, 0, len(deployList))
+		for _, deployment := range deployList {
+			items = append(items, batchWorkload{Namespace: deployment.Namespace, Deployment: deployment.Name})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Deployment < items[j].Deployment })
+
+		target := deploymentWorkloads{listers.Deployments}
+		results := forEachItem(items, func(item batchWorkload) batchItemResult {
+			return fn(target, item, dryRun)
+		})
+		resp := finishBatch(&batchResponse{DryRun: dryRun}, results)
+		responses.ReturnJsonResponse(w, resp.Code, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Scales a deployment to 0 and remembers its replicas, a deployment that is already hibernated keeps the replicas it had
+// A hibernated deployment that was scaled up since is scaled to 0 again, it still wakes up to the replicas from the first time
+func hibernateItem(r *http.Request, scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy,
+	item batchWorkload, dryRun bool) batchItemResult {
+	if result, ok := authorizeItem(r, target, item); !ok {
+		return result
+	}
+
+	current, result, ok := hibernatedState(store, target, item)
+	if !ok {
+		return result
+	}
+	if current != nil && current.HibernatedReplicas != nil {
+		live, err := target.get(item.Namespace, item.Deployment)
+		if err != nil {
+			result.fail(err)
+			return result
+		}
+		if live.Replicas == 0 {
+			result.Result = batchUnchanged
+			result.RequestedReplicas = current.DesiredReplicas
+			return result
+		}
+	}
+
+	opts := scaleOptions{Actor: auth.FromRequest(r).String(), DryRun: dryRun, Hibernate: true}
+	return scaleItem(scales, target, store, replicaLimits, item, opts, func(live *liveWorkload) int32 {
+		return 0
+	})
+}
+
+// Scales a hibernated deployment back to the replicas it had, deployments that aren't hibernated are left alone
+// The replica limits don't apply since this only puts back what was there before
+func wakeItem(r *http.Request, scales scale.ScalesGetter, target workloads, store state.StateStore, item batchWorkload, dryRun bool) batchItemResult {
+	if result, ok := authorizeItem(r, target, item); !ok {
+		return result
+	}
+
+	current, result, ok := hibernatedState(store, target, item)
+	if !ok {
+		return result
+	}
+	if current == nil || current.HibernatedReplicas == nil {
+		result.Result = batchUnchanged
+		if current != nil {
+			result.CurrentReplicas = current.CurrentReplicas
+			result.RequestedReplicas = current.DesiredReplicas
+		}
+		return result
+	}
+
+	opts := scaleOptions{Actor: auth.FromRequest(r).String(), DryRun: dryRun, Wake: true}
+	return scaleItem(scales, target, store, nil, item, opts, func(live *liveWorkload) int32 {
+		return *current.HibernatedReplicas
+	})
+}
+
+// Gets the state of a deployment to check if it is hibernated, the result is the failure to report if that fails
+func hibernatedState(store state.StateStore, target workloads, item batchWorkload) (*state.Value, batchItemResult, bool) {
+	result := batchItemResult{Namespace: item.Namespace, Deployment: item.Deployment, Code: 200}
+
+	key := state.Key{Kind: target.kind(), Namespace: item.Namespace, Name: item.Deployment}
+	current, _, err := store.Get(context.Background(), key)
+	if err != nil {
+		logger.Log.Errorf("error getting state for %s: %s", key, err)
+		result.fail(err)
+		return nil, result, false
+	}
+
+	return current, result, true
+}
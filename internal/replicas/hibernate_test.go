@@ -0,0 +1,197 @@
+package replicas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/limits"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/gorilla/mux"
+)
+
+// Tests hibernating a namespace and waking it up again step by step
+func TestV1NamespaceHibernateAndWake(t *testing.T) {
+	steps := []struct {
+		name               string
+		description        string
+		path               string
+		expectedCode       int
+		expectedResults    map[string]string
+		expectedReplicas   map[string]int32
+		expectedHibernated map[string]int32
+	}{
+		{
+			name:               "hibernate-dry-run",
+			description:        "A dry run doesn't scale or remember anything",
+			path:               "/v1/namespaces/dev:hibernate?dryRun=true",
+			expectedCode:       207,
+			expectedResults:    map[string]string{"web": batchValidated, "worker": batchValidated, "pinned": batchFailed},
+			expectedReplicas:   map[string]int32{"web": 3, "worker": 0, "pinned": 2},
+			expectedHibernated: map[string]int32{},
+		},
+		{
+			name:               "hibernate",
+			description:        "Every deployment goes to 0 and remembers its replicas, unless its limits don't allow it",
+			path:               "/v1/namespaces/dev:hibernate",
+			expectedCode:       207,
+			expectedResults:    map[string]string{"web": batchScaled, "worker": batchScaled, "pinned": batchFailed},
+			expectedReplicas:   map[string]int32{"web": 0, "worker": 0, "pinned": 2},
+			expectedHibernated: map[string]int32{"web": 3, "worker": 0},
+		},
+		{
+			name:               "hibernate-again",
+			description:        "Hibernating twice doesn't forget the replicas from the first time",
+			path:               "/v1/namespaces/dev:hibernate",
+			expectedCode:       207,
+			expectedResults:    map[string]string{"web": batchUnchanged, "worker": batchUnchanged, "pinned": batchFailed},
+			expectedReplicas:   map[string]int32{"web": 0, "worker": 0, "pinned": 2},
+			expectedHibernated: map[string]int32{"web": 3, "worker": 0},
+		},
+		{
+			name:               "wake",
+			description:        "Hibernated deployments go back to the replicas they had",
+			path:               "/v1/namespaces/dev:wake",
+			expectedCode:       200,
+			expectedResults:    map[string]string{"web": batchScaled, "worker": batchScaled, "pinned": batchUnchanged},
+			expectedReplicas:   map[string]int32{"web": 3, "worker": 0, "pinned": 2},
+			expectedHibernated: map[string]int32{},
+		},
+		{
+			name:               "wake-again",
+			description:        "Nothing is hibernated anymore",
+			path:               "/v1/namespaces/dev:wake",
+			expectedCode:       200,
+			expectedResults:    map[string]string{"web": batchUnchanged, "worker": batchUnchanged, "pinned": batchUnchanged},
+			expectedReplicas:   map[string]int32{"web": 3, "worker": 0, "pinned": 2},
+			expectedHibernated: map[string]int32{},
+		},
+	}
+
+	pinned := newTestDeployment("dev", "pinned", 2)
+	pinned.Annotations = map[string]string{limits.AnnotationMinReplicas: "1"}
+	fakeClientset, scales, listers := newTestClients(t, newTestDeployment("dev", "web", 3), newTestDeployment("dev", "worker", 0), pinned,
+		newTestDeployment("other", "web", 2))
+	store := state.NewMemoryStore()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/namespaces/{namespace:[a-z0-9-]+}:hibernate", func(w http.ResponseWriter, r *http.Request) {
+		V1NamespaceHibernate(w, r, scales, listers, store, nil)
+	})
+	router.HandleFunc("/v1/namespaces/{namespace:[a-z0-9-]+}:wake", func(w http.ResponseWriter, r *http.Request) {
+		V1NamespaceWake(w, r, scales, listers, store)
+	})
+
+	// The steps build on each other so they share the clients and the store
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, step.path, nil))
+
+			var resp batchResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			results := map[string]string{}
+			for _, item := range resp.Items {
+				results[item.Deployment] = item.Result
+			}
+			replicas := map[string]int32{}
+			hibernated := map[string]int32{}
+			for name := range step.expectedReplicas {
+				live, err := testReplicas(fakeClientset, "deployments", "dev", name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				replicas[name] = live
+				waitForLister(t, listers, "dev", name, live)
+				value, _, err := store.Get(context.TODO(), state.Key{Namespace: "dev", Name: name})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if value != nil && value.HibernatedReplicas != nil {
+					hibernated[name] = *value.HibernatedReplicas
+				}
+			}
+			other, err := testReplicas(fakeClientset, "deployments", "other", "web")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case rr.Code != step.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, step.expectedCode, rr.Body.String())
+			case !reflect.DeepEqual(results, step.expectedResults):
+				t.Errorf("Fail: got results %v want %v", results, step.expectedResults)
+			case !reflect.DeepEqual(replicas, step.expectedReplicas):
+				t.Errorf("Fail: got replicas %v want %v", replicas, step.expectedReplicas)
+			case !reflect.DeepEqual(hibernated, step.expectedHibernated):
+				t.Errorf("Fail: got hibernated replicas %v want %v", hibernated, step.expectedHibernated)
+			case other != 2:
+				t.Errorf("Fail: got %d replicas in another namespace want 2", other)
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
+
+// Tests a hibernated deployment that was scaled up since is scaled to 0 again and still wakes up to its first replicas
+func TestV1NamespaceHibernateAfterScaleUp(t *testing.T) {
+	fakeClientset, scales, listers := newTestClients(t, newTestDeployment("dev", "web", 3))
+	store := state.NewMemoryStore()
+	target := deploymentWorkloads{listers.Deployments}
+	item := batchWorkload{Namespace: "dev", Deployment: "web"}
+	hibernate := func() batchItemResult {
+		req := httptest.NewRequest(http.MethodPost, "/v1/namespaces/dev:hibernate", nil)
+		return hibernateItem(req, scales, target, store, nil, item, false)
+	}
+
+	if result := hibernate(); result.Result != batchScaled {
+		t.Fatalf("Fail: got result %s hibernating want %s: %s", result.Result, batchScaled, result.Error)
+	}
+	waitForLister(t, listers, "dev", "web", 0)
+	if _, _, err := setReplicas(scales, target, store, nil, "dev", "web", 5, scaleOptions{Actor: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForLister(t, listers, "dev", "web", 5)
+
+	result := hibernate()
+	replicas, err := testReplicas(fakeClientset, "deployments", "dev", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _, err := store.Get(context.TODO(), state.Key{Namespace: "dev", Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case result.Result != batchScaled:
+		t.Errorf("Fail: got result %s want %s", result.Result, batchScaled)
+	case replicas != 0:
+		t.Errorf("Fail: got %d replicas want 0", replicas)
+	case value.HibernatedReplicas == nil || *value.HibernatedReplicas != 3:
+		t.Errorf("Fail: got state %v want to wake up to 3 replicas", value)
+	default:
+		t.Logf("test passed %v", value)
+	}
+}
+
+// Waits for the informer cache to see the replicas of a deployment, hibernating reads them from there
+func waitForLister(t *testing.T, listers Listers, namespace string, name string, replicas int32) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		deployment, err := listers.Deployments.Deployments(namespace).Get(name)
+		if err == nil && specReplicas(deployment.Spec.Replicas) == replicas {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("informer cache never saw %d replicas for %s/%s", replicas, namespace, name)
+}
@@ -0,0 +1,110 @@
+package replicas
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// Page size limits for the history endpoint
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// Response to client when they request the history of a workload
+type getHistoryResponse struct {
+	Namespace string `json:"namespace"`
+	workloadName
+	History []state.HistoryEntry `json:"history"`
+	Total   int                  `json:"total"`
+	Offset  int                  `json:"offset"`
+	Limit   int                  `json:"limit"`
+	Code    int                  `json:"http_status_code"`
+}
+
+// Handles the /v1/replicas/{namespace}/{deployment}/history, /v1/replicas/{namespace}/statefulsets/{statefulset}/history
+// and /v1/scale/{group}/{resource}/{namespace}/{name}/history endpoints
+func V1ReplicasHistory(w http.ResponseWriter, r *http.Request, store state.StateStore) {
+	vars := mux.Vars(r)
+	// The history is only read from the state store, so the workloads are just used to name the key
+	var target workloads = deploymentWorkloads{}
+	name := vars["deployment"]
+	if statefulSet, ok := vars["statefulset"]; ok {
+		target, name = statefulSetWorkloads{}, statefulSet
+	} else if resource, ok := vars["resource"]; ok {
+		target, name = Listers{}.forResource(nil, vars["group"], resource), vars["name"]
+	}
+	key := state.Key{Kind: target.kind(), Namespace: vars["namespace"], Name: name}
+	resource := target.resource()
+	if err := validation.NamespacedName(key.Namespace, "name", key.Name); err != nil {
+		responses.ReturnError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		allowed, err := auth.Authorized(r, auth.VerbRead, resource, key.Namespace, key.Name)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to read %s %s/%s", auth.FromRequest(r), resource, key.Namespace, key.Name))
+			return
+		}
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			responses.ReturnError(w, e.Validation("offset must be a non-negative number"))
+			return
+		}
+		limit, err := queryInt(r, "limit", defaultHistoryLimit)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			responses.ReturnError(w, e.Validation("limit must be between 1 and %d", maxHistoryLimit))
+			return
+		}
+
+		resp, err := getHistory(store, key, offset, limit)
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		responses.ReturnJsonResponse(w, 200, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Gets a page of the scale history of a workload, newest first
+func getHistory(store state.StateStore, key state.Key, offset int, limit int) (*getHistoryResponse, error) {
+	entries, total, err := store.History(context.Background(), key, offset, limit)
+	if err != nil {
+		logger.Log.Errorf("error getting history for %s: %s", key, err)
+		return nil, err
+	}
+
+	resp := &getHistoryResponse{Code: 200, Namespace: key.Namespace, workloadName: newWorkloadName(key.Kind, key.Name), History: entries,
+		Total: total, Offset: offset, Limit: limit}
+
+	return resp, nil
+}
+
+// Helper to read an optional integer query parameter
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(raw)
+}
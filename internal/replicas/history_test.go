@@ -0,0 +1,105 @@
+package replicas
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/gorilla/mux"
+)
+
+// Tests that scaling through the API shows up in the history endpoint
+func TestV1ReplicasHistory(t *testing.T) {
+	_, scales, listers := newTestClients(t, newTestDeployment("test", "test-deployment", 3))
+	store := state.NewMemoryStore()
+
+	// Route like main does so the mux vars are set
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/replicas/{namespace}/{deployment}/history", func(w http.ResponseWriter, r *http.Request) {
+		V1ReplicasHistory(w, r, store)
+	})
+	router.HandleFunc("/v1/replicas/{namespace}/{deployment}", func(w http.ResponseWriter, r *http.Request) {
+		replicasRouter(scales, listers, store, nil).ServeHTTP(w, r)
+	})
+
+	// Scale twice, the lister doesn't see the first patch so both come from 3
+	for _, body := range []string{`{"replica_size": 5}`, `{"replica_size": 0}`} {
+		req := newJSONRequest(http.MethodPost, "/v1/replicas/test/test-deployment", body)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("scale returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedCode  int
+		expectedTotal int
+		expectedTo    []int32
+	}{
+		{
+			name:          "history-all",
+			expectedCode:  200,
+			expectedTotal: 2,
+			expectedTo:    []int32{0, 5},
+		},
+		{
+			name:          "history-paged",
+			query:         "?offset=1&limit=1",
+			expectedCode:  200,
+			expectedTotal: 2,
+			expectedTo:    []int32{5},
+		},
+		{
+			name:         "history-bad-limit",
+			query:        "?limit=0",
+			expectedCode: 400,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/v1/replicas/test/test-deployment/history"+test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp getHistoryResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			var to []int32
+			for _, entry := range resp.History {
+				to = append(to, entry.ToReplicas)
+				if entry.Actor != "anonymous" || entry.FromReplicas != 3 || entry.Result != state.ResultSuccess {
+					t.Errorf("Fail: unexpected history entry %v", entry)
+				}
+			}
+
+			switch {
+			case resp.Total != test.expectedTotal:
+				t.Errorf("Fail: got total %d want %d", resp.Total, test.expectedTotal)
+			case !reflect.DeepEqual(to, test.expectedTo):
+				t.Errorf("Fail: got %v want %v", to, test.expectedTo)
+			default:
+				t.Logf("test passed %v", resp.History)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package replicas
+
+import (
+	"context"
+	"time"
+
+	// internal packages
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	// Kubernetes packages
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/scale"
+)
+
+// Modes the reconciler can run in for a workload
+const (
+	// Patches the workload back to the desired replicas in the state store
+	ReconcileEnforce = "enforce"
+	// Only records the drift in the state store and logs it
+	ReconcileReport = "report"
+	// Skips the workload entirely
+	ReconcileDisabled = "disabled"
+)
+
+// Annotation on a deployment or StatefulSet that overrides the default reconcile mode
+const reconcileModeAnnotation = "kube-server/reconcile-mode"
+
+// Actor recorded in the history when the reconciler scales a workload
+const reconcilerActor = "kube-server/reconciler"
+
+// Checks if the mode is one the reconciler understands
+func ValidReconcileMode(mode string) bool {
+	switch mode {
+	case ReconcileEnforce, ReconcileReport, ReconcileDisabled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Result of reconciling a single workload
+type reconcileResult struct {
+	Mode    string
+	Drift   bool
+	Patched bool
+}
+
+// Reconciles every tracked workload on an interval until the context is cancelled
+func RunReconciler(ctx context.Context, scales scale.ScalesGetter, listers Listers, store state.StateStore, interval time.Duration, defaultMode string) {
+	logger.Log.Infof("Starting drift reconciler every %s with default mode %s", interval, defaultMode)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Stopping drift reconciler")
+			return
+		case <-ticker.C:
+			reconcileAll(ctx, scales, listers, store, defaultMode)
+		}
+	}
+}
+
+// Walks every tracked workload once
+func reconcileAll(ctx context.Context, scales scale.ScalesGetter, listers Listers, store state.StateStore, defaultMode string) {
+	// A panic here would otherwise take the whole server down with the goroutine
+	defer e.NonFatal()
+
+	keys, err := store.List(ctx)
+	if err != nil {
+		logger.Log.Errorf("error listing tracked workloads: %s", err)
+		return
+	}
+
+	for _, key := range keys {
+		target, err := listers.forKind(scales, key.Kind)
+		if err != nil {
+			logger.Log.Errorf("error reconciling %s: %s", key, err)
+			continue
+		}
+		_, err = reconcileWorkload(ctx, scales, target, store, key, defaultMode)
+		if err != nil {
+			logger.Log.Errorf("error reconciling %s: %s", key, err)
+		}
+	}
+}
+
+// Compares the desired replicas in the state store against the live workload and enforces or reports the drift
+func reconcileWorkload(ctx context.Context, scales scale.ScalesGetter, target workloads, store state.StateStore, key state.Key, defaultMode string) (*reconcileResult, error) {
+	live, err := target.get(key.Namespace, key.Name)
+	if err != nil {
+		// The workload is gone so there is nothing left to reconcile
+		if errors.IsNotFound(err) {
+			logger.Log.Infof("%s no longer exists, removing its state", key)
+			return &reconcileResult{Mode: ReconcileDisabled}, deleteState(store, key)
+		}
+		return nil, err
+	}
+
+	// The annotation on the workload wins over the server default
+	mode := defaultMode
+	if annotation, ok := live.Annotations[reconcileModeAnnotation]; ok {
+		if ValidReconcileMode(annotation) {
+			mode = annotation
+		} else {
+			logger.Log.Warnf("invalid %s annotation %q on %s, using %s", reconcileModeAnnotation, annotation, key, mode)
+		}
+	}
+
+	result := &reconcileResult{Mode: mode}
+	if mode == ReconcileDisabled {
+		return result, nil
+	}
+
+	stored, keyExists, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// Nobody has asked for a replica count yet so there is nothing desired to compare against
+	if !keyExists {
+		return result, nil
+	}
+
+	if mode == ReconcileEnforce && stored.DesiredReplicas != live.Replicas {
+		desired := stored.DesiredReplicas
+		logger.Log.Infof("enforcing %d replicas on %s, found %d", desired, key, live.Replicas)
+		_, err = scaleReplicas(scales, target, key.Namespace, key.Name, desired, nil, false)
+		recordHistory(store, key, reconcilerActor, live.Replicas, desired, "", err)
+		if err != nil {
+			return nil, err
+		}
+		// A scale request may have changed the desired replicas since they were read, leave its state alone
+		// and let the next run enforce the new replicas
+		err = store.Update(ctx, key, func(current *state.Value) (*state.Value, error) {
+			if current == nil || current.DesiredReplicas != desired {
+				return nil, nil
+			}
+			enforced := *current
+			enforced.CurrentReplicas = desired
+			enforced.Drift = false
+			return &enforced, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Drift = true
+		result.Patched = true
+		return result, nil
+	}
+
+	result.Drift, err = recordDrift(ctx, store, key, live.Replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Records in the state store whether the live replicas have drifted from the desired replicas, only writing when something changed
+// The state is read and written in one update so a scale request in between isn't overwritten, untracked workloads are left alone
+func recordDrift(ctx context.Context, store state.StateStore, key state.Key, liveReplicas int32) (bool, error) {
+	var drift, changed bool
+	var desired int32
+	err := store.Update(ctx, key, func(current *state.Value) (*state.Value, error) {
+		drift, changed = false, false
+		if current == nil {
+			return nil, nil
+		}
+		desired = current.DesiredReplicas
+		drift = desired != liveReplicas
+		changed = drift != current.Drift || current.CurrentReplicas != liveReplicas
+		if !changed {
+			return nil, nil
+		}
+		observed := *current
+		observed.Drift = drift
+		observed.CurrentReplicas = liveReplicas
+		return &observed, nil
+	})
+	if err != nil || !changed {
+		return drift, err
+	}
+
+	if drift {
+		logger.Log.Warnf("drift detected on %s, desired_replicas:%d, k8s_replicas:%d", key, desired, liveReplicas)
+	} else {
+		logger.Log.Infof("drift resolved on %s, replicas:%d", key, liveReplicas)
+	}
+
+	return drift, nil
+}
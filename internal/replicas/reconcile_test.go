@@ -0,0 +1,226 @@
+package replicas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Tests reconciling a single deployment against its state in the state store
+func TestReconcileDeployment(t *testing.T) {
+	testCases := []struct {
+		name             string
+		description      string
+		defaultMode      string
+		annotations      map[string]string
+		liveReplicas     int32
+		keyExists        bool
+		state            state.Value
+		expectedState    *state.Value
+		expectedReplicas int32
+		expectedResult   reconcileResult
+	}{
+		{
+			name:             "enforce-drift",
+			description:      "Drift is patched back to the desired replicas when the annotation enforces it",
+			defaultMode:      ReconcileReport,
+			annotations:      map[string]string{reconcileModeAnnotation: ReconcileEnforce},
+			liveReplicas:     2,
+			keyExists:        true,
+			state:            state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedState:    &state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedReplicas: 4,
+			expectedResult:   reconcileResult{Mode: ReconcileEnforce, Drift: true, Patched: true},
+		},
+		{
+			name:             "report-drift",
+			description:      "Drift is only recorded in the state store in report mode",
+			defaultMode:      ReconcileReport,
+			liveReplicas:     2,
+			keyExists:        true,
+			state:            state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedState:    &state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true},
+			expectedReplicas: 2,
+			expectedResult:   reconcileResult{Mode: ReconcileReport, Drift: true},
+		},
+		{
+			name:             "report-drift-already-recorded",
+			description:      "Known drift is left as it is",
+			defaultMode:      ReconcileReport,
+			liveReplicas:     2,
+			keyExists:        true,
+			state:            state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true},
+			expectedState:    &state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true},
+			expectedReplicas: 2,
+			expectedResult:   reconcileResult{Mode: ReconcileReport, Drift: true},
+		},
+		{
+			name:             "drift-fixed-by-hand",
+			description:      "The drift flag is cleared once the deployment matches again",
+			defaultMode:      ReconcileEnforce,
+			liveReplicas:     4,
+			keyExists:        true,
+			state:            state.Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true},
+			expectedState:    &state.Value{DesiredReplicas: 4, CurrentReplicas: 4, Drift: false},
+			expectedReplicas: 4,
+			expectedResult:   reconcileResult{Mode: ReconcileEnforce},
+		},
+		{
+			name:             "untracked-state",
+			description:      "Nothing happens when there is no desired state stored",
+			defaultMode:      ReconcileEnforce,
+			liveReplicas:     3,
+			keyExists:        false,
+			expectedReplicas: 3,
+			expectedResult:   reconcileResult{Mode: ReconcileEnforce},
+		},
+		{
+			name:             "disabled-annotation",
+			description:      "The annotation can opt a deployment out of reconciling",
+			defaultMode:      ReconcileEnforce,
+			annotations:      map[string]string{reconcileModeAnnotation: ReconcileDisabled},
+			liveReplicas:     2,
+			expectedReplicas: 2,
+			expectedResult:   reconcileResult{Mode: ReconcileDisabled},
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			deploy := newTestDeployment("test", "test-deployment", test.liveReplicas)
+			deploy.Annotations = test.annotations
+			fakeClientset, scales, listers := newTestClients(t, deploy)
+
+			key := state.Key{Namespace: deploy.Namespace, Name: deploy.Name}
+			store := state.NewMemoryStore()
+			if test.keyExists {
+				if err := store.Set(context.TODO(), key, &test.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := reconcileWorkload(context.TODO(), scales, deploymentWorkloads{listers.Deployments}, store, key, test.defaultMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deployResp, err := fakeClientset.AppsV1().Deployments(deploy.Namespace).Get(context.TODO(), deploy.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			storedState, _, err := store.Get(context.TODO(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case *result != test.expectedResult:
+				t.Errorf("Fail: got %v want %v", *result, test.expectedResult)
+			case *deployResp.Spec.Replicas != test.expectedReplicas:
+				t.Errorf("Fail: got %d replicas want %d", *deployResp.Spec.Replicas, test.expectedReplicas)
+			case test.keyExists && !reflect.DeepEqual(storedState, test.expectedState):
+				t.Errorf("Fail: got state %v want %v", storedState, test.expectedState)
+			default:
+				t.Logf("test passed %v", result)
+			}
+		})
+	}
+}
+
+// State store where a scale request stores new desired replicas right after the reconciler reads the state
+type racingStore struct {
+	*state.MemoryStore
+	raced *state.Value
+}
+
+func (s *racingStore) Get(ctx context.Context, key state.Key) (*state.Value, bool, error) {
+	value, exists, err := s.MemoryStore.Get(ctx, key)
+	if err == nil && s.raced != nil {
+		err = s.MemoryStore.Set(ctx, key, s.raced)
+		s.raced = nil
+	}
+
+	return value, exists, err
+}
+
+// Tests the reconciler doesn't overwrite desired replicas a scale request stored while it was enforcing
+func TestReconcileConcurrentScale(t *testing.T) {
+	deploy := newTestDeployment("test", "test-deployment", 2)
+	deploy.Annotations = map[string]string{reconcileModeAnnotation: ReconcileEnforce}
+	_, scales, listers := newTestClients(t, deploy)
+	key := state.Key{Namespace: "test", Name: "test-deployment"}
+	raced := &state.Value{DesiredReplicas: 6, CurrentReplicas: 6}
+	store := &racingStore{MemoryStore: state.NewMemoryStore(), raced: raced}
+	if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 4, CurrentReplicas: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := reconcileWorkload(context.TODO(), scales, deploymentWorkloads{listers.Deployments}, store, key, ReconcileReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedState, _, err := store.MemoryStore.Get(context.TODO(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(storedState, raced) {
+		t.Errorf("Fail: got state %v want %v", storedState, raced)
+	}
+}
+
+// Tests that a deleted deployment has its state removed
+func TestReconcileDeletedDeployment(t *testing.T) {
+	_, scales, listers := newTestClients(t)
+	key := state.Key{Namespace: "test", Name: "gone"}
+	store := state.NewMemoryStore()
+	if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 1, CurrentReplicas: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := reconcileWorkload(context.TODO(), scales, deploymentWorkloads{listers.Deployments}, store, key, ReconcileEnforce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Fail: got %v want no tracked deployments", keys)
+	}
+}
+
+// Tests that reconciling every tracked workload enforces StatefulSets under their own key
+func TestReconcileAllStatefulSet(t *testing.T) {
+	fakeClientset, scales, listers := newTestClients(t, newTestDeployment("test", "shared-name", 2), newTestStatefulSet("test", "shared-name", 2))
+	key := state.Key{Kind: state.KindStatefulSet, Namespace: "test", Name: "shared-name"}
+	store := state.NewMemoryStore()
+	if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 5, CurrentReplicas: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileAll(context.TODO(), scales, listers, store, ReconcileEnforce)
+
+	statefulSet, err := fakeClientset.AppsV1().StatefulSets("test").Get(context.TODO(), "shared-name", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployResp, err := fakeClientset.AppsV1().Deployments("test").Get(context.TODO(), "shared-name", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case *statefulSet.Spec.Replicas != 5:
+		t.Errorf("Fail: got %d StatefulSet replicas want 5", *statefulSet.Spec.Replicas)
+	case *deployResp.Spec.Replicas != 2:
+		t.Errorf("Fail: got %d deployment replicas want 2", *deployResp.Spec.Replicas)
+	}
+}
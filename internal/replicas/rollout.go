@@ -0,0 +1,193 @@
+package replicas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	// Kubernetes packages
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Longest a scale request can wait for its rollout, the server write timeout has to allow for it
+const MaxRolloutWait = 5 * time.Minute
+
+// How long a scale request waits for its rollout without a timeout
+const defaultRolloutWait = time.Minute
+
+// How often the informer cache is checked while waiting for a rollout
+const rolloutPollInterval = 500 * time.Millisecond
+
+// Reason the deployment controller gives when a rollout stops progressing, it isn't exported outside k8s.io/kubernetes
+const progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+// How waiting for a rollout ended
+const (
+	rolloutComplete                 = "complete"
+	rolloutTimeout                  = "timeout"
+	rolloutProgressDeadlineExceeded = "progress_deadline_exceeded"
+	rolloutUnknown                  = "unknown"
+)
+
+// Progress of the pods of a workload after a scale
+// Resources without an informer only report the replicas of their scale subresource
+type rolloutStatus struct {
+	Status            string            `json:"status,omitempty"`
+	Replicas          int32             `json:"replicas"`
+	ReadyReplicas     *int32            `json:"ready_replicas,omitempty"`
+	AvailableReplicas *int32            `json:"available_replicas,omitempty"`
+	UpdatedReplicas   *int32            `json:"updated_replicas,omitempty"`
+	Condition         *rolloutCondition `json:"condition,omitempty"`
+	// Why the rollout couldn't be followed when the status is unknown
+	Error string `json:"error,omitempty"`
+}
+
+// Progressing condition of a deployment
+type rolloutCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Set once the server shuts down, rollouts still being waited for are reported as they are then
+var waitsStopped int32
+
+// Stops every wait for a rollout, so requests with wait=true finish before the server shuts down
+func StopWaiting() {
+	atomic.StoreInt32(&waitsStopped, 1)
+}
+
+// Reads the wait and timeout query parameters of a scale request, zero if it shouldn't wait
+func parseWait(r *http.Request) (time.Duration, error) {
+	query := r.URL.Query()
+	value, timeout := query.Get("wait"), query.Get("timeout")
+	if value == "" {
+		if timeout != "" {
+			return 0, fmt.Errorf("timeout can only be used with wait=true")
+		}
+		return 0, nil
+	}
+
+	wait, err := strconv.ParseBool(value)
+	if err != nil {
+		return 0, fmt.Errorf("wait must be true or false, got %q", value)
+	}
+	if !wait {
+		return 0, nil
+	}
+	if timeout == "" {
+		return defaultRolloutWait, nil
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil || duration <= 0 || duration > MaxRolloutWait {
+		return 0, fmt.Errorf("timeout must be a duration between 0s and %s like 120s, got %q", MaxRolloutWait, timeout)
+	}
+
+	return duration, nil
+}
+
+// Waits until the ready replicas of a workload match the replicas it was scaled to, its rollout stops progressing or the timeout is hit
+// Returns how the rollout ended with the workload as it was then
+// When the workload can't be read anymore the rollout is unknown, with the progress and workload seen last if there were any
+func waitForRollout(target workloads, namespace string, name string, replicas int32, timeout time.Duration) (*rolloutStatus, *liveWorkload) {
+	logger.Log.Infof("Waiting up to %s for %s %s/%s to roll out %d replicas", timeout, target.kind(), namespace, name, replicas)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	var last *liveWorkload
+	for {
+		live, err := target.get(namespace, name)
+		if err != nil {
+			logger.Log.Errorf("error waiting for the rollout of %s %s/%s: %s", target.kind(), namespace, name, err)
+			rollout := rolloutStatus{}
+			if last != nil {
+				rollout = last.Rollout
+			}
+			rollout.Status = rolloutUnknown
+			rollout.Error = err.Error()
+			return &rollout, last
+		}
+		last = live
+
+		rollout := live.Rollout
+		switch {
+		case rolledOut(live, replicas):
+			rollout.Status = rolloutComplete
+			return &rollout, live
+		case stalled(live, replicas):
+			logger.Log.Errorf("rollout of %s %s/%s exceeded its progress deadline", target.kind(), namespace, name)
+			rollout.Status = rolloutProgressDeadlineExceeded
+			return &rollout, live
+		}
+
+		if atomic.LoadInt32(&waitsStopped) == 1 {
+			logger.Log.Errorf("stopped waiting for %s %s/%s to roll out since the server is shutting down", target.kind(), namespace, name)
+			rollout.Status = rolloutTimeout
+			return &rollout, live
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Errorf("timed out after %s waiting for %s %s/%s to roll out", timeout, target.kind(), namespace, name)
+			rollout.Status = rolloutTimeout
+			return &rollout, live
+		case <-ticker.C:
+		}
+	}
+}
+
+// Checks the controller has caught up with the scale, the cache may still have the workload from before it
+func observed(live *liveWorkload, replicas int32) bool {
+	return live.Replicas == replicas && live.ObservedGeneration >= live.Generation
+}
+
+// Checks every replica the workload was scaled to is up
+func rolledOut(live *liveWorkload, replicas int32) bool {
+	rollout := live.Rollout
+	for _, count := range []*int32{rollout.ReadyReplicas, rollout.AvailableReplicas, rollout.UpdatedReplicas} {
+		if count != nil && *count != replicas {
+			return false
+		}
+	}
+
+	return observed(live, replicas) && rollout.Replicas == replicas
+}
+
+// Checks the deployment controller gave up on the rollout
+func stalled(live *liveWorkload, replicas int32) bool {
+	condition := live.Rollout.Condition
+	return observed(live, replicas) && condition != nil && condition.Reason == progressDeadlineExceeded
+}
+
+// Gets the rollout progress of a deployment
+func deploymentRollout(deployment *appsv1.Deployment) rolloutStatus {
+	status := deployment.Status
+	rollout := rolloutStatus{Replicas: status.Replicas, ReadyReplicas: &status.ReadyReplicas, AvailableReplicas: &status.AvailableReplicas,
+		UpdatedReplicas: &status.UpdatedReplicas}
+	for _, condition := range status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing {
+			rollout.Condition = &rolloutCondition{Type: string(condition.Type), Status: string(condition.Status), Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	return rollout
+}
+
+// Gets the rollout progress of a StatefulSet, it has no progress deadline
+func statefulSetRollout(statefulSet *appsv1.StatefulSet) rolloutStatus {
+	status := statefulSet.Status
+	return rolloutStatus{Replicas: status.Replicas, ReadyReplicas: &status.ReadyReplicas, AvailableReplicas: &status.AvailableReplicas,
+		UpdatedReplicas: &status.UpdatedReplicas}
+}
@@ -0,0 +1,248 @@
+package replicas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+// Tests reading the wait and timeout query parameters
+func TestParseWait(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		expectedWait  time.Duration
+		expectedError bool
+	}{
+		{name: "none", query: "", expectedWait: 0},
+		{name: "wait-default", query: "wait=true", expectedWait: defaultRolloutWait},
+		{name: "wait-timeout", query: "wait=true&timeout=120s", expectedWait: 120 * time.Second},
+		{name: "no-wait", query: "wait=false", expectedWait: 0},
+		{name: "bad-wait", query: "wait=maybe", expectedError: true},
+		{name: "bad-timeout", query: "wait=true&timeout=soon", expectedError: true},
+		{name: "negative-timeout", query: "wait=true&timeout=-1s", expectedError: true},
+		{name: "timeout-too-long", query: "wait=true&timeout=1h", expectedError: true},
+		{name: "timeout-without-wait", query: "timeout=120s", expectedError: true},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/replicas/test/test-deployment?"+test.query, nil)
+			wait, err := parseWait(req)
+
+			switch {
+			case (err != nil) != test.expectedError:
+				t.Errorf("Fail: got error %v want error %v", err, test.expectedError)
+			case wait != test.expectedWait:
+				t.Errorf("Fail: got wait %s want %s", wait, test.expectedWait)
+			default:
+				t.Logf("test passed %s", wait)
+			}
+		})
+	}
+}
+
+// Acts like the deployment controller, setting the status once the deployment is scaled
+func rollOut(t *testing.T, ctx context.Context, fakeClientset *testclient.Clientset, status func(replicas int32) *appsv1.DeploymentStatus) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		deployResp, err := fakeClientset.AppsV1().Deployments("test").Get(ctx, "test-deployment", metav1.GetOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		replicas := *deployResp.Spec.Replicas
+		if replicas == deployResp.Status.Replicas {
+			continue
+		}
+		next := status(replicas)
+		if next == nil {
+			continue
+		}
+		deployResp.Status = *next
+		_, err = fakeClientset.AppsV1().Deployments("test").UpdateStatus(ctx, deployResp, metav1.UpdateOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// Tests scale requests waiting for the rollout report how it ended
+func TestV1ReplicasWait(t *testing.T) {
+	progressing := appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "NewReplicaSetAvailable"}
+	stalled := appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: progressDeadlineExceeded,
+		Message: `ReplicaSet "test-deployment-5d9c8f7b6" has timed out progressing.`}
+	five, two, three := int32(5), int32(2), int32(3)
+
+	testCases := []struct {
+		name             string
+		description      string
+		path             string
+		status           func(replicas int32) *appsv1.DeploymentStatus
+		expectedCode     int
+		expectedCurrent  int32
+		expectedRollout  *rolloutStatus
+		expectedReplicas int32
+	}{
+		{
+			name:        "complete",
+			description: "Returns once every replica is ready",
+			path:        "/v1/replicas/test/test-deployment?wait=true&timeout=5s",
+			status: func(replicas int32) *appsv1.DeploymentStatus {
+				return &appsv1.DeploymentStatus{Replicas: replicas, ReadyReplicas: replicas, AvailableReplicas: replicas, UpdatedReplicas: replicas,
+					Conditions: []appsv1.DeploymentCondition{progressing}}
+			},
+			expectedCode:    200,
+			expectedCurrent: 5,
+			expectedRollout: &rolloutStatus{Status: rolloutComplete, Replicas: 5, ReadyReplicas: &five, AvailableReplicas: &five, UpdatedReplicas: &five,
+				Condition: &rolloutCondition{Type: "Progressing", Status: "True", Reason: "NewReplicaSetAvailable"}},
+			expectedReplicas: 5,
+		},
+		{
+			name:        "progress-deadline-exceeded",
+			description: "Stops waiting once the deployment controller gives up",
+			path:        "/v1/replicas/test/test-deployment?wait=true&timeout=5s",
+			status: func(replicas int32) *appsv1.DeploymentStatus {
+				return &appsv1.DeploymentStatus{Replicas: replicas, ReadyReplicas: 2, AvailableReplicas: 2, UpdatedReplicas: replicas,
+					Conditions: []appsv1.DeploymentCondition{stalled}}
+			},
+			expectedCode:    200,
+			expectedCurrent: 5,
+			expectedRollout: &rolloutStatus{Status: rolloutProgressDeadlineExceeded, Replicas: 5, ReadyReplicas: &two, AvailableReplicas: &two, UpdatedReplicas: &five,
+				Condition: &rolloutCondition{Type: "Progressing", Status: "False", Reason: progressDeadlineExceeded, Message: stalled.Message}},
+			expectedReplicas: 5,
+		},
+		{
+			name:        "timeout",
+			description: "Reports the progress so far when the pods don't come up in time",
+			path:        "/v1/replicas/test/test-deployment?wait=true&timeout=1s",
+			status: func(replicas int32) *appsv1.DeploymentStatus {
+				return nil
+			},
+			expectedCode:     200,
+			expectedCurrent:  5,
+			expectedRollout:  &rolloutStatus{Status: rolloutTimeout, Replicas: 3, ReadyReplicas: &three, AvailableReplicas: &three, UpdatedReplicas: &three},
+			expectedReplicas: 5,
+		},
+		{
+			name:             "dry-run",
+			description:      "Nothing rolls out on a dry run",
+			path:             "/v1/replicas/test/test-deployment?wait=true&dryRun=true",
+			expectedCode:     400,
+			expectedReplicas: 3,
+		},
+		{
+			name:             "bad-timeout",
+			description:      "The timeout must be a duration",
+			path:             "/v1/replicas/test/test-deployment?wait=true&timeout=soon",
+			expectedCode:     400,
+			expectedReplicas: 3,
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("test", "test-deployment", 3)
+			deployment.Status = appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 3, AvailableReplicas: 3, UpdatedReplicas: 3}
+			fakeClientset, scales, listers := newTestClients(t, deployment)
+			store := state.NewMemoryStore()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.status != nil {
+				go rollOut(t, ctx, fakeClientset, test.status)
+			}
+
+			req := newJSONRequest(http.MethodPost, test.path, `{"replica_size": 5}`)
+			rr := httptest.NewRecorder()
+			replicasRouter(scales, listers, store, nil).ServeHTTP(rr, req)
+
+			replicas, err := testReplicas(fakeClientset, "deployments", "test", "test-deployment")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := &setReplicasResponse{}
+			if rr.Code == 200 {
+				if err := json.Unmarshal(rr.Body.Bytes(), resp); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			switch {
+			case rr.Code != test.expectedCode:
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, test.expectedCode, rr.Body.String())
+			case resp.CurrentReplicas != test.expectedCurrent:
+				t.Errorf("Fail: got current replicas %d want %d", resp.CurrentReplicas, test.expectedCurrent)
+			case !reflect.DeepEqual(resp.Rollout, test.expectedRollout):
+				t.Errorf("Fail: got rollout %s", rr.Body.String())
+			case replicas != test.expectedReplicas:
+				t.Errorf("Fail: got %d replicas want %d", replicas, test.expectedReplicas)
+			default:
+				t.Logf("test passed %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+// Tests a workload that can't be read while waiting reports an unknown rollout instead of failing
+func TestWaitForRolloutUnknown(t *testing.T) {
+	_, _, listers := newTestClients(t)
+
+	rollout, rolled := waitForRollout(deploymentWorkloads{listers.Deployments}, "test", "gone", 5, time.Second)
+
+	switch {
+	case rolled != nil:
+		t.Errorf("Fail: got workload %v want none", rolled)
+	case rollout.Status != rolloutUnknown:
+		t.Errorf("Fail: got status %q want %q", rollout.Status, rolloutUnknown)
+	case !strings.Contains(rollout.Error, "not found"):
+		t.Errorf("Fail: got error %q want not found", rollout.Error)
+	default:
+		t.Logf("test passed %v", rollout)
+	}
+}
+
+// Tests waits for a rollout end with the progress so far once the server shuts down
+func TestWaitForRolloutStopped(t *testing.T) {
+	deployment := newTestDeployment("test", "test-deployment", 5)
+	deployment.Status = appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 3, AvailableReplicas: 3, UpdatedReplicas: 3}
+	_, _, listers := newTestClients(t, deployment)
+
+	StopWaiting()
+	defer atomic.StoreInt32(&waitsStopped, 0)
+	start := time.Now()
+	rollout, rolled := waitForRollout(deploymentWorkloads{listers.Deployments}, "test", "test-deployment", 5, MaxRolloutWait)
+
+	switch {
+	case time.Since(start) > time.Second:
+		t.Errorf("Fail: waited %s after the server started shutting down", time.Since(start))
+	case rollout.Status != rolloutTimeout || rollout.Replicas != 3:
+		t.Errorf("Fail: got rollout %v want a timeout with 3 replicas", rollout)
+	case rolled == nil:
+		t.Errorf("Fail: got no workload want the one seen last")
+	default:
+		t.Logf("test passed %v", rollout)
+	}
+}
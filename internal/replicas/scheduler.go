@@ -0,0 +1,156 @@
+package replicas
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/cron"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/limits"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	// Kubernetes packages
+	"k8s.io/client-go/scale"
+)
+
+// Actor recorded in the history when a schedule scales a deployment, followed by the schedule ID
+const scheduleActorPrefix = "kube-server/schedule/"
+
+// Runs missed by up to this long, like while the leader changed, still happen late
+// Anything older is skipped so a restart after a long outage doesn't replay stale scales
+const scheduleCatchUp = 5 * time.Minute
+
+// Checks the schedules on an interval and scales the deployments that are due until the context is cancelled
+func RunScheduler(ctx context.Context, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy, interval time.Duration) {
+	logger.Log.Infof("Starting scheduler every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Stopping scheduler")
+			return
+		case now := <-ticker.C:
+			runSchedules(ctx, scales, listers, store, replicaLimits, now)
+		}
+	}
+}
+
+// Runs every schedule that is due at now once
+func runSchedules(ctx context.Context, scales scale.ScalesGetter, listers Listers, store state.StateStore, replicaLimits *limits.Policy, now time.Time) {
+	// A panic here would otherwise take the whole server down with the goroutine
+	defer e.NonFatal()
+
+	schedules, err := store.ListSchedules(ctx)
+	if err != nil {
+		logger.Log.Errorf("error listing schedules: %s", err)
+		return
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		due, err := dueRun(schedule, now)
+		if err != nil {
+			logger.Log.Errorf("error running schedule %s/%s: %s", schedule.Namespace, schedule.ID, err)
+			continue
+		}
+		if due.IsZero() {
+			continue
+		}
+		runSchedule(ctx, scales, deploymentWorkloads{listers.Deployments}, store, replicaLimits, schedule, due)
+	}
+}
+
+// Scales the deployment of a schedule through the same path as the API and records how it went on the schedule
+func runSchedule(ctx context.Context, scales scale.ScalesGetter, target workloads, store state.StateStore, replicaLimits *limits.Policy, schedule *state.Schedule, due time.Time) {
+	// Claim the run first so it happens once even if the schedule is checked again before it finishes
+	claimed := false
+	err := store.UpdateSchedule(ctx, schedule.Namespace, schedule.ID, func(current *state.Schedule) (*state.Schedule, error) {
+		// Deleted or already run since it was listed
+		if current == nil || current.LastRun != nil && !current.LastRun.Before(due) {
+			return nil, nil
+		}
+		claimed = true
+		current.LastRun = &due
+		return current, nil
+	})
+	if err != nil || !claimed {
+		if err != nil {
+			logger.Log.Errorf("error claiming the run of schedule %s/%s: %s", schedule.Namespace, schedule.ID, err)
+		}
+		return
+	}
+
+	logger.Log.Infof("Schedule %s/%s due at %s is scaling %s to %d", schedule.Namespace, schedule.ID, due, schedule.Deployment, schedule.Replicas)
+	opts := scaleOptions{Actor: scheduleActorPrefix + schedule.ID}
+	_, _, scaleErr := setReplicas(scales, target, store, replicaLimits, schedule.Namespace, schedule.Deployment, schedule.Replicas, opts)
+	result, message := state.ResultSuccess, ""
+	if scaleErr != nil {
+		logger.Log.Errorf("error running schedule %s/%s: %s", schedule.Namespace, schedule.ID, scaleErr)
+		result, message = state.ResultFailure, fmt.Sprint(scaleErr)
+	}
+
+	err = store.UpdateSchedule(ctx, schedule.Namespace, schedule.ID, func(current *state.Schedule) (*state.Schedule, error) {
+		if current == nil {
+			return nil, nil
+		}
+		current.LastResult = result
+		current.LastError = message
+		return current, nil
+	})
+	if err != nil {
+		logger.Log.Errorf("error recording the result of schedule %s/%s: %s", schedule.Namespace, schedule.ID, err)
+	}
+}
+
+// Gets the latest time the schedule was due at or before now that it hasn't run for, zero if it isn't due
+func dueRun(schedule *state.Schedule, now time.Time) (time.Time, error) {
+	expression, loc, err := parseSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	since := schedule.CreatedAt
+	if schedule.LastRun != nil && schedule.LastRun.After(since) {
+		since = *schedule.LastRun
+	}
+	if earliest := now.Add(-scheduleCatchUp); since.Before(earliest) {
+		since = earliest
+	}
+
+	var due time.Time
+	for next := expression.Next(since.In(loc)); !next.IsZero() && !next.After(now); next = expression.Next(next) {
+		due = next
+	}
+
+	return due, nil
+}
+
+// Gets the next time the schedule is due after now, zero if it never is
+func nextRun(schedule *state.Schedule, now time.Time) (time.Time, error) {
+	expression, loc, err := parseSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expression.Next(now.In(loc)), nil
+}
+
+// Parses the cron expression and time zone of a schedule, an empty time zone is UTC
+func parseSchedule(schedule *state.Schedule) (*cron.Schedule, *time.Location, error) {
+	expression, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return expression, loc, nil
+}
@@ -0,0 +1,153 @@
+package replicas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/state"
+)
+
+// Tests working out whether a schedule is due
+func TestDueRun(t *testing.T) {
+	// Wednesday
+	created := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	ranAt := time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		description string
+		schedule    state.Schedule
+		now         time.Time
+		expected    time.Time
+	}{
+		{
+			name:        "not-due",
+			description: "Nothing to do before the time",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 7, 59, 0, 0, time.UTC),
+		},
+		{
+			name:        "due",
+			description: "Due right after the time",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 8, 0, 10, 0, time.UTC),
+			expected:    time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "late",
+			description: "A run missed by a few minutes still happens",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 8, 4, 0, 0, time.UTC),
+			expected:    time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "too-late",
+			description: "A run missed for too long is skipped",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 8, 6, 0, 0, time.UTC),
+		},
+		{
+			name:        "already-ran",
+			description: "A run only happens once",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created, LastRun: &ranAt},
+			now:         time.Date(2022, 6, 1, 8, 1, 0, 0, time.UTC),
+		},
+		{
+			name:        "created-after",
+			description: "A schedule doesn't run for times before it was created",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: time.Date(2022, 6, 1, 8, 0, 30, 0, time.UTC)},
+			now:         time.Date(2022, 6, 1, 8, 1, 0, 0, time.UTC),
+		},
+		{
+			name:        "latest",
+			description: "Only the latest of several missed runs happens",
+			schedule:    state.Schedule{Cron: "* * * * *", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 8, 3, 30, 0, time.UTC),
+			expected:    time.Date(2022, 6, 1, 8, 3, 0, 0, time.UTC),
+		},
+		{
+			name:        "weekend",
+			description: "Days of the week are respected",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", CreatedAt: created},
+			now:         time.Date(2022, 6, 4, 8, 0, 10, 0, time.UTC),
+		},
+		{
+			name:        "time-zone",
+			description: "The time is in the time zone of the schedule",
+			schedule:    state.Schedule{Cron: "0 8 * * MON-FRI", TimeZone: "Europe/Berlin", CreatedAt: created},
+			now:         time.Date(2022, 6, 1, 6, 0, 10, 0, time.UTC),
+			expected:    time.Date(2022, 6, 1, 6, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			due, err := dueRun(&test.schedule, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !due.Equal(test.expected) {
+				t.Errorf("Fail: got %s want %s", due, test.expected)
+			} else {
+				t.Logf("test passed %s", due)
+			}
+		})
+	}
+}
+
+// Tests the scheduler scales due deployments once and records how it went
+func TestRunSchedules(t *testing.T) {
+	fakeClientset, scales, listers := newTestClients(t, newTestDeployment("test", "web", 1))
+	store := state.NewMemoryStore()
+	ctx := context.TODO()
+
+	created := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 6, 1, 8, 0, 10, 0, time.UTC)
+	for _, schedule := range []state.Schedule{
+		{ID: "morning", Namespace: "test", Deployment: "web", Cron: "0 8 * * *", Replicas: 5, CreatedAt: created},
+		{ID: "evening", Namespace: "test", Deployment: "web", Cron: "0 20 * * *", Replicas: 1, CreatedAt: created},
+		{ID: "missing", Namespace: "test", Deployment: "missing", Cron: "0 8 * * *", Replicas: 5, CreatedAt: created},
+	} {
+		schedule := schedule
+		err := store.UpdateSchedule(ctx, schedule.Namespace, schedule.ID, func(current *state.Schedule) (*state.Schedule, error) {
+			return &schedule, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Running twice at the same time only scales once
+	for i := 0; i < 2; i++ {
+		runSchedules(ctx, scales, listers, store, nil, now)
+	}
+
+	replicas, err := testReplicas(fakeClientset, "deployments", "test", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, total, err := store.History(ctx, state.Key{Namespace: "test", Name: "web"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]string{}
+	for _, id := range []string{"morning", "evening", "missing"} {
+		schedule, _, err := store.GetSchedule(ctx, "test", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[id] = schedule.LastResult
+	}
+
+	switch {
+	case replicas != 5:
+		t.Errorf("Fail: got %d replicas want 5", replicas)
+	case total != 1 || history[0].Actor != scheduleActorPrefix+"morning":
+		t.Errorf("Fail: got history %v want one scale by the morning schedule", history)
+	case results["morning"] != state.ResultSuccess || results["evening"] != "" || results["missing"] != state.ResultFailure:
+		t.Errorf("Fail: got results %v", results)
+	default:
+		t.Logf("test passed %v", results)
+	}
+}
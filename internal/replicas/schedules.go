@@ -0,0 +1,280 @@
+package replicas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/cron"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+	"github.com/taylorsmcclure/kube-server/internal/validation"
+
+	// Kubernetes packages
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Returned from a schedule update when the schedule was deleted in the meantime
+var errScheduleNotFound = fmt.Errorf("schedule not found")
+
+// Parse incoming payload from client when they create or replace a schedule
+type scheduleRequest struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment_name"`
+	Cron       string `json:"cron"`
+	TimeZone   string `json:"time_zone"`
+	// A pointer so a missing replica_size isn't taken as scaling to 0
+	Replicas *int32 `json:"replica_size"`
+}
+
+// Response to client for a single schedule
+type scheduleResponse struct {
+	state.Schedule
+	NextRun *time.Time `json:"next_run,omitempty"`
+	Code    int        `json:"http_status_code"`
+}
+
+// Response to client when they list schedules
+type listSchedulesResponse struct {
+	Schedules []scheduleResponse `json:"schedules"`
+	Code      int                `json:"http_status_code"`
+}
+
+// Handles the /v1/schedules endpoint, listing schedules and creating new ones
+func V1Schedules(w http.ResponseWriter, r *http.Request, listers Listers, store state.StateStore) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		schedules, err := store.ListSchedules(context.Background())
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error listing schedules: %w", err))
+			return
+		}
+		sort.Slice(schedules, func(i, j int) bool {
+			a, b := schedules[i], schedules[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Deployment != b.Deployment {
+				return a.Deployment < b.Deployment
+			}
+			return a.ID < b.ID
+		})
+
+		// Clients only see the schedules of deployments they can read
+		namespace := r.URL.Query().Get("namespace")
+		resp := &listSchedulesResponse{Schedules: []scheduleResponse{}, Code: 200}
+		for _, schedule := range schedules {
+			if namespace != "" && schedule.Namespace != namespace {
+				continue
+			}
+			allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceDeployments, schedule.Namespace, schedule.Deployment)
+			if err != nil {
+				responses.ReturnError(w, err)
+				return
+			}
+			if !allowed {
+				continue
+			}
+			resp.Schedules = append(resp.Schedules, *newScheduleResponse(&schedule, time.Now()))
+		}
+		responses.ReturnJsonResponse(w, 200, resp)
+	case http.MethodPost:
+		req, ok := decodeScheduleRequest(w, r)
+		if !ok {
+			return
+		}
+		if !authorizeSchedule(w, r, req.Namespace, req.Deployment) || !scheduledDeploymentExists(w, listers, req.Namespace, req.Deployment) {
+			return
+		}
+
+		schedule := &state.Schedule{ID: uuid.New().String(), Namespace: req.Namespace, Deployment: req.Deployment, Cron: req.Cron,
+			TimeZone: req.TimeZone, Replicas: *req.Replicas, CreatedBy: auth.FromRequest(r).String(), CreatedAt: time.Now().UTC()}
+		err := store.UpdateSchedule(context.Background(), schedule.Namespace, schedule.ID, func(current *state.Schedule) (*state.Schedule, error) {
+			return schedule, nil
+		})
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error creating schedule %s/%s: %w", schedule.Namespace, schedule.ID, err))
+			return
+		}
+		logger.Log.Infof("%s created schedule %s/%s scaling %s to %d at %q", schedule.CreatedBy, schedule.Namespace, schedule.ID,
+			schedule.Deployment, schedule.Replicas, schedule.Cron)
+
+		resp := newScheduleResponse(schedule, time.Now())
+		resp.Code = 201
+		responses.ReturnJsonResponse(w, 201, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Handles the /v1/schedules/{namespace}/{id} endpoint, reading, replacing and deleting a schedule
+// Changing or deleting a schedule needs the scale permission on its deployment, like creating it
+func V1Schedule(w http.ResponseWriter, r *http.Request, listers Listers, store state.StateStore) {
+	vars := mux.Vars(r)
+	namespace, id := vars["namespace"], vars["id"]
+
+	schedule, exists, err := store.GetSchedule(context.Background(), namespace, id)
+	if err != nil {
+		responses.ReturnError(w, fmt.Errorf("error getting schedule %s/%s: %w", namespace, id, err))
+		return
+	}
+	if !exists {
+		responses.ReturnError(w, e.NotFound("schedule %s/%s not found", namespace, id))
+		return
+	}
+	// Schedules of deployments the client can't read look like they don't exist
+	allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceDeployments, namespace, schedule.Deployment)
+	if err != nil {
+		responses.ReturnError(w, err)
+		return
+	}
+	if !allowed {
+		responses.ReturnError(w, e.NotFound("schedule %s/%s not found", namespace, id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		responses.ReturnJsonResponse(w, 200, newScheduleResponse(schedule, time.Now()))
+	case http.MethodPut:
+		req, ok := decodeScheduleRequest(w, r)
+		if !ok {
+			return
+		}
+		if req.Namespace != namespace {
+			responses.ReturnError(w, e.Validation("a schedule can't be moved to another namespace"))
+			return
+		}
+		if !authorizeSchedule(w, r, namespace, schedule.Deployment) || !authorizeSchedule(w, r, namespace, req.Deployment) ||
+			!scheduledDeploymentExists(w, listers, namespace, req.Deployment) {
+			return
+		}
+
+		// Who created it and when it last ran are kept
+		var replaced *state.Schedule
+		err := store.UpdateSchedule(context.Background(), namespace, id, func(current *state.Schedule) (*state.Schedule, error) {
+			if current == nil {
+				return nil, errScheduleNotFound
+			}
+			updated := *current
+			updated.Deployment = req.Deployment
+			updated.Cron = req.Cron
+			updated.TimeZone = req.TimeZone
+			updated.Replicas = *req.Replicas
+			replaced = &updated
+			return replaced, nil
+		})
+		if err == errScheduleNotFound {
+			responses.ReturnError(w, e.NotFound("schedule %s/%s not found", namespace, id))
+			return
+		}
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error replacing schedule %s/%s: %w", namespace, id, err))
+			return
+		}
+		logger.Log.Infof("%s replaced schedule %s/%s", auth.FromRequest(r), namespace, id)
+		responses.ReturnJsonResponse(w, 200, newScheduleResponse(replaced, time.Now()))
+	case http.MethodDelete:
+		if !authorizeSchedule(w, r, namespace, schedule.Deployment) {
+			return
+		}
+		err := store.DeleteSchedule(context.Background(), namespace, id)
+		if err != nil {
+			responses.ReturnError(w, fmt.Errorf("error deleting schedule %s/%s: %w", namespace, id, err))
+			return
+		}
+		logger.Log.Infof("%s deleted schedule %s/%s", auth.FromRequest(r), namespace, id)
+		responses.ReturnJsonResponse(w, 200, newScheduleResponse(schedule, time.Now()))
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Decodes and validates a schedule, the bool is false if the client already got a 400
+func decodeScheduleRequest(w http.ResponseWriter, r *http.Request) (*scheduleRequest, bool) {
+	var req scheduleRequest
+	err := validation.DecodeJSON(r, &req)
+	if err != nil {
+		responses.ReturnError(w, err)
+		return nil, false
+	}
+	err = req.validate()
+	if err != nil {
+		responses.ReturnError(w, e.Validation("%s", err))
+		return nil, false
+	}
+
+	return &req, true
+}
+
+// Checks the schedule names a deployment, parses and has replicas
+func (req *scheduleRequest) validate() error {
+	switch {
+	case req.Namespace == "" || req.Deployment == "":
+		return fmt.Errorf("namespace and deployment_name are required")
+	case req.Replicas == nil:
+		return fmt.Errorf("replica_size is required")
+	case *req.Replicas < 0:
+		return fmt.Errorf("replica_size can't be negative")
+	}
+	if err := validation.NamespacedName(req.Namespace, "deployment_name", req.Deployment); err != nil {
+		return err
+	}
+	if _, err := cron.Parse(req.Cron); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(req.TimeZone); err != nil {
+		return fmt.Errorf("unknown time_zone %q", req.TimeZone)
+	}
+
+	return nil
+}
+
+// Checks the client may scale the deployment of a schedule, the bool is false if the client already got a 403 or 503
+func authorizeSchedule(w http.ResponseWriter, r *http.Request, namespace string, deployment string) bool {
+	allowed, err := auth.Authorized(r, auth.VerbScale, auth.ResourceDeployments, namespace, deployment)
+	if err != nil {
+		responses.ReturnError(w, err)
+		return false
+	}
+	if !allowed {
+		responses.ReturnError(w, e.Forbidden("%s is not allowed to scale %s %s/%s", auth.FromRequest(r), auth.ResourceDeployments, namespace, deployment))
+		return false
+	}
+
+	return true
+}
+
+// Checks the deployment is there so a typo doesn't make a schedule that fails every time, the bool is false if the client already got an error
+func scheduledDeploymentExists(w http.ResponseWriter, listers Listers, namespace string, deployment string) bool {
+	_, err := deploymentWorkloads{listers.Deployments}.get(namespace, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			responses.ReturnError(w, e.NotFound("%s", err))
+			return false
+		}
+		responses.ReturnError(w, fmt.Errorf("error getting deployment %s/%s for a schedule: %w", namespace, deployment, err))
+		return false
+	}
+
+	return true
+}
+
+// Adds when the schedule runs next to the response
+func newScheduleResponse(schedule *state.Schedule, now time.Time) *scheduleResponse {
+	resp := &scheduleResponse{Schedule: *schedule, Code: 200}
+	if next, err := nextRun(schedule, now); err == nil && !next.IsZero() {
+		resp.NextRun = &next
+	}
+
+	return resp
+}
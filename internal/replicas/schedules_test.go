@@ -0,0 +1,262 @@
+package replicas
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	"github.com/gorilla/mux"
+)
+
+// Tests creating, listing, reading, replacing and deleting a schedule step by step
+func TestV1Schedules(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{
+		{
+			Subjects:    auth.Subjects{CommonNames: []string{"deployer"}},
+			Namespaces:  []string{"test"},
+			Deployments: []string{"*"},
+			Verbs:       []string{auth.VerbRead, auth.VerbScale},
+		},
+		{
+			Subjects:    auth.Subjects{CommonNames: []string{"viewer"}},
+			Namespaces:  []string{"test"},
+			Deployments: []string{"*"},
+			Verbs:       []string{auth.VerbRead},
+		},
+	}}
+
+	steps := []struct {
+		name              string
+		description       string
+		method            string
+		path              string
+		body              string
+		commonName        string
+		expectedCode      int
+		expectedSchedules int
+		expectedReplicas  int32
+	}{
+		{
+			name:             "create",
+			description:      "Creates a schedule for a deployment the client can scale",
+			method:           http.MethodPost,
+			path:             "/v1/schedules",
+			body:             `{"namespace": "test", "deployment_name": "web", "cron": "0 8 * * MON-FRI", "time_zone": "Europe/Berlin", "replica_size": 5}`,
+			commonName:       "deployer",
+			expectedCode:     201,
+			expectedReplicas: 5,
+		},
+		{
+			name:         "bad-cron",
+			description:  "The cron expression has to parse",
+			method:       http.MethodPost,
+			path:         "/v1/schedules",
+			body:         `{"namespace": "test", "deployment_name": "web", "cron": "0 8 * *", "replica_size": 5}`,
+			commonName:   "deployer",
+			expectedCode: 400,
+		},
+		{
+			name:         "bad-time-zone",
+			description:  "The time zone has to exist",
+			method:       http.MethodPost,
+			path:         "/v1/schedules",
+			body:         `{"namespace": "test", "deployment_name": "web", "cron": "0 8 * * *", "time_zone": "Mars/Olympus", "replica_size": 5}`,
+			commonName:   "deployer",
+			expectedCode: 400,
+		},
+		{
+			name:         "missing-replicas",
+			description:  "A missing replica_size isn't taken as 0",
+			method:       http.MethodPost,
+			path:         "/v1/schedules",
+			body:         `{"namespace": "test", "deployment_name": "web", "cron": "0 8 * * *"}`,
+			commonName:   "deployer",
+			expectedCode: 400,
+		},
+		{
+			name:         "missing-deployment",
+			description:  "Schedules can only be made for deployments that exist",
+			method:       http.MethodPost,
+			path:         "/v1/schedules",
+			body:         `{"namespace": "test", "deployment_name": "missing", "cron": "0 8 * * *", "replica_size": 5}`,
+			commonName:   "deployer",
+			expectedCode: 404,
+		},
+		{
+			name:         "create-forbidden",
+			description:  "Creating a schedule needs the scale permission",
+			method:       http.MethodPost,
+			path:         "/v1/schedules",
+			body:         `{"namespace": "test", "deployment_name": "web", "cron": "0 20 * * *", "replica_size": 1}`,
+			commonName:   "viewer",
+			expectedCode: 403,
+		},
+		{
+			name:              "list",
+			description:       "Readers see the schedule",
+			method:            http.MethodGet,
+			path:              "/v1/schedules",
+			commonName:        "viewer",
+			expectedCode:      200,
+			expectedSchedules: 1,
+		},
+		{
+			name:              "list-other-namespace",
+			description:       "Schedules are filtered by namespace",
+			method:            http.MethodGet,
+			path:              "/v1/schedules?namespace=other",
+			commonName:        "viewer",
+			expectedCode:      200,
+			expectedSchedules: 0,
+		},
+		{
+			name:              "list-unauthorized",
+			description:       "Clients don't see schedules of deployments they can't read",
+			method:            http.MethodGet,
+			path:              "/v1/schedules",
+			commonName:        "stranger",
+			expectedCode:      200,
+			expectedSchedules: 0,
+		},
+		{
+			name:             "get",
+			description:      "Reads the schedule back",
+			method:           http.MethodGet,
+			path:             "/v1/schedules/test/{id}",
+			commonName:       "viewer",
+			expectedCode:     200,
+			expectedReplicas: 5,
+		},
+		{
+			name:         "get-unauthorized",
+			description:  "Schedules of deployments the client can't read don't exist for them",
+			method:       http.MethodGet,
+			path:         "/v1/schedules/test/{id}",
+			commonName:   "stranger",
+			expectedCode: 404,
+		},
+		{
+			name:             "replace",
+			description:      "Replaces the schedule",
+			method:           http.MethodPut,
+			path:             "/v1/schedules/test/{id}",
+			body:             `{"namespace": "test", "deployment_name": "web", "cron": "0 9 * * MON-FRI", "replica_size": 4}`,
+			commonName:       "deployer",
+			expectedCode:     200,
+			expectedReplicas: 4,
+		},
+		{
+			name:         "move-namespace",
+			description:  "Schedules stay in their namespace",
+			method:       http.MethodPut,
+			path:         "/v1/schedules/test/{id}",
+			body:         `{"namespace": "other", "deployment_name": "web", "cron": "0 9 * * *", "replica_size": 4}`,
+			commonName:   "deployer",
+			expectedCode: 400,
+		},
+		{
+			name:         "replace-forbidden",
+			description:  "Replacing a schedule needs the scale permission",
+			method:       http.MethodPut,
+			path:         "/v1/schedules/test/{id}",
+			body:         `{"namespace": "test", "deployment_name": "web", "cron": "0 9 * * *", "replica_size": 10}`,
+			commonName:   "viewer",
+			expectedCode: 403,
+		},
+		{
+			name:         "delete-forbidden",
+			description:  "Deleting a schedule needs the scale permission",
+			method:       http.MethodDelete,
+			path:         "/v1/schedules/test/{id}",
+			commonName:   "viewer",
+			expectedCode: 403,
+		},
+		{
+			name:             "delete",
+			description:      "Deletes the schedule",
+			method:           http.MethodDelete,
+			path:             "/v1/schedules/test/{id}",
+			commonName:       "deployer",
+			expectedCode:     200,
+			expectedReplicas: 4,
+		},
+		{
+			name:         "get-deleted",
+			description:  "The schedule is gone",
+			method:       http.MethodGet,
+			path:         "/v1/schedules/test/{id}",
+			commonName:   "deployer",
+			expectedCode: 404,
+		},
+	}
+
+	_, _, listers := newTestClients(t, newTestDeployment("test", "web", 1))
+	store := state.NewMemoryStore()
+
+	router := mux.NewRouter()
+	router.Use(auth.Middleware(policy))
+	router.HandleFunc("/v1/schedules", func(w http.ResponseWriter, r *http.Request) {
+		V1Schedules(w, r, listers, store)
+	})
+	router.HandleFunc("/v1/schedules/{namespace}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		V1Schedule(w, r, listers, store)
+	})
+
+	// The steps build on each other so they share the store and the ID of the schedule
+	var id string
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			req := newJSONRequest(step.method, strings.Replace(step.path, "{id}", id, 1), step.body)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: step.commonName}}}}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != step.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, step.expectedCode, rr.Body.String())
+			}
+			if rr.Code >= 300 {
+				return
+			}
+
+			// Lists have no ID in the path
+			if step.method == http.MethodGet && !strings.Contains(step.path, "{id}") {
+				var resp listSchedulesResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if len(resp.Schedules) != step.expectedSchedules {
+					t.Errorf("Fail: got %d schedules want %d", len(resp.Schedules), step.expectedSchedules)
+				}
+				return
+			}
+
+			var resp scheduleResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if step.name == "create" {
+				id = resp.ID
+			}
+			switch {
+			case resp.ID != id || resp.Namespace != "test" || resp.Deployment != "web":
+				t.Errorf("Fail: got schedule %s/%s for %s want %s/%s for web", resp.Namespace, resp.ID, resp.Deployment, "test", id)
+			case resp.Replicas != step.expectedReplicas:
+				t.Errorf("Fail: got %d replicas want %d", resp.Replicas, step.expectedReplicas)
+			case resp.NextRun == nil:
+				t.Errorf("Fail: got no next run")
+			case resp.CreatedBy != "deployer":
+				t.Errorf("Fail: got created by %q want deployer", resp.CreatedBy)
+			default:
+				t.Logf("test passed %v", rr.Code)
+			}
+		})
+	}
+}
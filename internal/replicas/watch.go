@@ -0,0 +1,64 @@
+package replicas
+
+import (
+	"context"
+
+	// internal packages
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	// Kubernetes packages
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Updates the drift state in the state store as soon as a watch event shows spec.replicas changing
+// Works on both the deployment and the StatefulSet informer
+func WatchDrift(informer cache.SharedIndexInformer, store state.StateStore) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldKey, oldReplicas, ok := watchedReplicas(oldObj)
+			if !ok {
+				return
+			}
+			newKey, newReplicas, ok := watchedReplicas(newObj)
+			if !ok || oldKey != newKey {
+				return
+			}
+			// Status updates and resyncs don't change the desired replicas
+			if oldReplicas != nil && newReplicas != nil && *oldReplicas == *newReplicas {
+				return
+			}
+			checkDrift(store, newKey, newReplicas)
+		},
+	})
+}
+
+// Gets the state store key and spec.replicas of a watched workload
+func watchedReplicas(obj interface{}) (state.Key, *int32, bool) {
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		return state.Key{Kind: state.KindDeployment, Namespace: workload.Namespace, Name: workload.Name}, workload.Spec.Replicas, true
+	case *appsv1.StatefulSet:
+		return state.Key{Kind: state.KindStatefulSet, Namespace: workload.Namespace, Name: workload.Name}, workload.Spec.Replicas, true
+	default:
+		return state.Key{}, nil, false
+	}
+}
+
+// Compares the replicas of a workload from a watch event against its state in the state store
+func checkDrift(store state.StateStore, key state.Key, replicas *int32) {
+	// Event handlers run on the informer goroutine, don't let a panic take it down
+	defer e.NonFatal()
+
+	if replicas == nil {
+		return
+	}
+
+	// Workloads nobody has asked about aren't tracked, recordDrift leaves them alone
+	_, err := recordDrift(context.Background(), store, key, *replicas)
+	if err != nil {
+		logger.Log.Errorf("error recording drift for %s: %s", key, err)
+	}
+}
@@ -0,0 +1,31 @@
+package replicas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/state"
+)
+
+// Tests that a watch event flags drift in the state store straight away
+func TestCheckDrift(t *testing.T) {
+	deploy := newTestDeployment("test", "test-deployment", 1)
+	key := state.Key{Namespace: deploy.Namespace, Name: deploy.Name}
+
+	store := state.NewMemoryStore()
+	if err := store.Set(context.TODO(), key, &state.Value{DesiredReplicas: 3, CurrentReplicas: 3, Drift: false}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDrift(store, key, deploy.Spec.Replicas)
+
+	got, _, err := store.Get(context.TODO(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &state.Value{DesiredReplicas: 3, CurrentReplicas: 1, Drift: true}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Fail: got %v want %v", got, expected)
+	}
+}
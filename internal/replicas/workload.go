@@ -0,0 +1,214 @@
+package replicas
+
+import (
+	"context"
+	"fmt"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/state"
+
+	// Kubernetes packages
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/scale"
+	"k8s.io/client-go/util/retry"
+)
+
+// Name of the core API group in the /v1/scale path, it is empty in the API itself
+const coreGroup = "core"
+
+// Resources we have informer caches for
+var (
+	deploymentsResource  = schema.GroupResource{Group: "apps", Resource: "deployments"}
+	statefulSetsResource = schema.GroupResource{Group: "apps", Resource: "statefulsets"}
+)
+
+// Informer caches of every kind of workload we manage the replicas of
+type Listers struct {
+	Deployments  appslisters.DeploymentLister
+	StatefulSets appslisters.StatefulSetLister
+}
+
+// A kind of workload with replicas, scaled through the scale subresource
+type workloads interface {
+	// Kind of the workload in the state store keys
+	kind() string
+	// Resource of the workload used for authorization
+	resource() string
+	// API group and resource of the workload for the scale client
+	groupResource() schema.GroupResource
+	// Gets spec.replicas, the annotations and the resourceVersion of a workload
+	get(namespace string, name string) (*liveWorkload, error)
+}
+
+// What we need to know about a workload as it is in the cluster
+type liveWorkload struct {
+	Replicas        int32
+	Annotations     map[string]string
+	ResourceVersion string
+	// Generation of the spec and the last one its controller acted on
+	Generation         int64
+	ObservedGeneration int64
+	Rollout            rolloutStatus
+}
+
+// Picks the workloads of a kind, used when walking the state store
+func (l Listers) forKind(scales scale.ScalesGetter, kind string) (workloads, error) {
+	switch kind {
+	case state.KindDeployment:
+		return deploymentWorkloads{l.Deployments}, nil
+	case state.KindStatefulSet:
+		if l.StatefulSets == nil {
+			return nil, fmt.Errorf("no StatefulSet informer")
+		}
+		return statefulSetWorkloads{l.StatefulSets}, nil
+	default:
+		return scaleWorkloads{scales: scales, gr: schema.ParseGroupResource(kind)}, nil
+	}
+}
+
+// Picks the workloads of a group and resource from the /v1/scale path
+// Deployments and StatefulSets still come from the informer caches and share their state with /v1/replicas
+func (l Listers) forResource(scales scale.ScalesGetter, group string, resource string) workloads {
+	if group == coreGroup {
+		group = ""
+	}
+
+	gr := schema.GroupResource{Group: group, Resource: resource}
+	switch {
+	case gr == deploymentsResource:
+		return deploymentWorkloads{l.Deployments}
+	case gr == statefulSetsResource:
+		return statefulSetWorkloads{l.StatefulSets}
+	default:
+		return scaleWorkloads{scales: scales, gr: gr}
+	}
+}
+
+// Deployments from the deployment informer
+type deploymentWorkloads struct {
+	lister appslisters.DeploymentLister
+}
+
+func (deploymentWorkloads) kind() string {
+	return state.KindDeployment
+}
+
+func (deploymentWorkloads) resource() string {
+	return auth.ResourceDeployments
+}
+
+func (deploymentWorkloads) groupResource() schema.GroupResource {
+	return deploymentsResource
+}
+
+func (w deploymentWorkloads) get(namespace string, name string) (*liveWorkload, error) {
+	deployResp, err := w.lister.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveWorkload{Replicas: specReplicas(deployResp.Spec.Replicas), Annotations: deployResp.Annotations, ResourceVersion: deployResp.ResourceVersion,
+		Generation: deployResp.Generation, ObservedGeneration: deployResp.Status.ObservedGeneration, Rollout: deploymentRollout(deployResp)}, nil
+}
+
+// StatefulSets from the StatefulSet informer
+type statefulSetWorkloads struct {
+	lister appslisters.StatefulSetLister
+}
+
+func (statefulSetWorkloads) kind() string {
+	return state.KindStatefulSet
+}
+
+func (statefulSetWorkloads) resource() string {
+	return auth.ResourceStatefulSets
+}
+
+func (statefulSetWorkloads) groupResource() schema.GroupResource {
+	return statefulSetsResource
+}
+
+func (w statefulSetWorkloads) get(namespace string, name string) (*liveWorkload, error) {
+	statefulSet, err := w.lister.StatefulSets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveWorkload{Replicas: specReplicas(statefulSet.Spec.Replicas), Annotations: statefulSet.Annotations, ResourceVersion: statefulSet.ResourceVersion,
+		Generation: statefulSet.Generation, ObservedGeneration: statefulSet.Status.ObservedGeneration, Rollout: statefulSetRollout(statefulSet)}, nil
+}
+
+// Any other resource implementing the scale subresource, like ReplicaSets or Argo Rollouts
+// There is no informer for these so every read goes to the API server
+type scaleWorkloads struct {
+	scales scale.ScalesGetter
+	gr     schema.GroupResource
+}
+
+func (w scaleWorkloads) kind() string {
+	return w.gr.String()
+}
+
+func (w scaleWorkloads) resource() string {
+	return w.gr.String()
+}
+
+func (w scaleWorkloads) groupResource() schema.GroupResource {
+	return w.gr
+}
+
+// The scale subresource doesn't carry the annotations of the resource, so the reconcile mode can't be overridden
+func (w scaleWorkloads) get(namespace string, name string) (*liveWorkload, error) {
+	scaleResp, err := w.scales.Scales(namespace).Get(context.TODO(), w.gr, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The scale carries the resourceVersion of the resource it belongs to, but not its generation
+	return &liveWorkload{Replicas: scaleResp.Spec.Replicas, Annotations: scaleResp.Annotations, ResourceVersion: scaleResp.ResourceVersion,
+		Rollout: rolloutStatus{Replicas: scaleResp.Status.Replicas}}, nil
+}
+
+// Sets the replicas of a workload through its scale subresource, the same as kubectl scale, and returns its new resourceVersion
+// The scale is read first so the update carries its resourceVersion and retried if someone else changed it in between
+// With expectedReplicas the scale only happens if the workload still has that many replicas, otherwise errPreconditionFailed
+// A dry run is validated by the API server, admission webhooks included, but nothing is persisted
+func scaleReplicas(scales scale.ScalesGetter, target workloads, namespace string, name string, replicas int32, expectedReplicas *int32, dryRun bool) (string, error) {
+	updateOptions := metav1.UpdateOptions{}
+	if dryRun {
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+
+	var resourceVersion string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		scaleResp, err := scales.Scales(namespace).Get(context.TODO(), target.groupResource(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if expectedReplicas != nil && scaleResp.Spec.Replicas != *expectedReplicas {
+			return errPreconditionFailed
+		}
+
+		scaleResp.Spec.Replicas = replicas
+		updated, err := scales.Scales(namespace).Update(context.TODO(), target.groupResource(), scaleResp, updateOptions)
+		if err != nil {
+			return err
+		}
+		resourceVersion = updated.ResourceVersion
+		return nil
+	})
+
+	return resourceVersion, err
+}
+
+// The API server defaults spec.replicas to 1, the pointer is only nil on objects that weren't defaulted
+func specReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+
+	return *replicas
+}
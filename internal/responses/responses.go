@@ -5,15 +5,49 @@ import (
 	"net/http"
 
 	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
 )
 
+// Header carrying the ID of a request, set on the response by the request ID middleware
+const RequestIDHeader = "X-Request-ID"
+
 // Easily creates a HTTP JSON response with response code and message
 // TODO: figure out a better way to validate resMessage is an object that can be marshalled to JSON
 func ReturnJsonResponse(w http.ResponseWriter, httpStatus int, resMessage interface{}) http.ResponseWriter {
-	defer e.NonFatal()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(httpStatus)
 	json.NewEncoder(w).Encode(resMessage)
 
 	return w
 }
+
+// Sends an error to the client as an RFC 7807 problem, with the request ID so clients can quote it when troubleshooting
+// http_response_code and message are kept alongside so clients of the older error responses keep working
+// The cause of internal and upstream errors is logged, clients only see the detail
+func ReturnError(w http.ResponseWriter, err error) http.ResponseWriter {
+	problem := e.From(err)
+	requestID := w.Header().Get(RequestIDHeader)
+	if problem.Status >= http.StatusInternalServerError && problem.Err != nil {
+		logger.Log.WithField("request_id", requestID).Error(err)
+	}
+
+	body := map[string]interface{}{}
+	for name, value := range problem.Extensions {
+		body[name] = value
+	}
+	body["type"] = problem.Kind.Type
+	body["title"] = problem.Kind.Title
+	body["status"] = problem.Status
+	body["detail"] = problem.Detail
+	body["http_response_code"] = problem.Status
+	body["message"] = problem.Detail
+	if requestID != "" {
+		body["request_id"] = requestID
+	}
+
+	w.Header().Set("Content-Type", e.ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(body)
+
+	return w
+}
@@ -0,0 +1,319 @@
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets in the BoltDB file holding the replica state and the history
+var (
+	replicasBucket = []byte("replicas")
+	historyBucket  = []byte("history")
+	// Keyed by namespace/id
+	schedulesBucket = []byte("schedules")
+)
+
+// State store backed by a local BoltDB file, only one process can open the file at a time
+type FileStore struct {
+	db *bolt.DB
+}
+
+// Opens or creates the BoltDB file at path
+func NewFileStore(path string) (*FileStore, error) {
+	// Don't wait forever if another kube-server already holds the file lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(replicasBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(schedulesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	logger.Log.Infof("Opened state file at : %s", path)
+
+	return &FileStore{db: db}, nil
+}
+
+// Closes the underlying BoltDB file
+func (s *FileStore) Close() error {
+	return s.db.Close()
+}
+
+// Gets the state of a deployment from the file
+func (s *FileStore) Get(ctx context.Context, key Key) (*Value, bool, error) {
+	var value *Value
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(replicasBucket).Get([]byte(key.String()))
+		if raw == nil {
+			return nil
+		}
+		return json.Unmarshal(raw, &value)
+	})
+	if err != nil {
+		logger.Log.Errorf("error getting key %s from state file: %s", key, err)
+		return nil, false, err
+	}
+
+	return value, value != nil, nil
+}
+
+// Sets the state of a deployment in the file
+func (s *FileStore) Set(ctx context.Context, key Key, value *Value) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		logger.Log.Errorf("error marshalling key %s to state file: %s", key, err)
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(replicasBucket).Put([]byte(key.String()), raw)
+	})
+	if err != nil {
+		logger.Log.Errorf("error setting key %s in state file: %s", key, err)
+	}
+
+	return err
+}
+
+// Updates the state of a deployment inside a single BoltDB write transaction
+func (s *FileStore) Update(ctx context.Context, key Key, mutate UpdateFunc) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(replicasBucket)
+
+		var current *Value
+		if raw := bucket.Get([]byte(key.String())); raw != nil {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				logger.Log.Errorf("error unmarshalling key %s from state file: %s", key, err)
+				return err
+			}
+		}
+
+		value, err := mutate(current)
+		if err != nil || value == nil {
+			return err
+		}
+		if value == Deleted {
+			return bucket.Delete([]byte(key.String()))
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(key.String()), raw)
+	})
+}
+
+// Removes the state of a deployment from the file
+func (s *FileStore) Delete(ctx context.Context, key Key) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(replicasBucket).Delete([]byte(key.String()))
+	})
+	if err != nil {
+		logger.Log.Errorf("error deleting key %s in state file: %s", key, err)
+	}
+
+	return err
+}
+
+// Lists every deployment with state in the file
+func (s *FileStore) List(ctx context.Context) ([]Key, error) {
+	var keys []Key
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(replicasBucket).ForEach(func(k, _ []byte) error {
+			key, ok := ParseKey(string(k))
+			if !ok {
+				logger.Log.Warnf("skipping malformed key %s in state file", k)
+				return nil
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Log.Errorf("error listing keys in state file: %s", err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Appends a scale action to a per-deployment bucket keyed by a sequence so it sorts oldest first
+// Sequences only go up, so dropping the one maxHistory behind the new entry keeps the newest maxHistory
+func (s *FileStore) AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(key.String()))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		seqKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(seqKey, seq)
+		if err := bucket.Put(seqKey, raw); err != nil {
+			return err
+		}
+		if seq <= maxHistory {
+			return nil
+		}
+		oldestKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(oldestKey, seq-maxHistory)
+		return bucket.Delete(oldestKey)
+	})
+	if err != nil {
+		logger.Log.Errorf("error appending history for %s in state file: %s", key, err)
+	}
+
+	return err
+}
+
+// Gets a page of the history by walking the bucket backwards
+func (s *FileStore) History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error) {
+	entries := []HistoryEntry{}
+	total := 0
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(key.String()))
+		if bucket == nil {
+			return nil
+		}
+		total = bucket.Stats().KeyN
+
+		cursor := bucket.Cursor()
+		skipped := 0
+		for k, v := cursor.Last(); k != nil && len(entries) < limit; k, v = cursor.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("error getting history for %s from state file: %s", key, err)
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// Lists every schedule in the file
+func (s *FileStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	schedules := []Schedule{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(k, v []byte) error {
+			var schedule Schedule
+			if err := json.Unmarshal(v, &schedule); err != nil {
+				return err
+			}
+			schedules = append(schedules, schedule)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Log.Errorf("error listing schedules in state file: %s", err)
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// Gets a schedule from the file
+func (s *FileStore) GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error) {
+	var schedule *Schedule
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(schedulesBucket).Get([]byte(scheduleKey(namespace, id)))
+		if raw == nil {
+			return nil
+		}
+		return json.Unmarshal(raw, &schedule)
+	})
+	if err != nil {
+		logger.Log.Errorf("error getting schedule %s from state file: %s", scheduleKey(namespace, id), err)
+		return nil, false, err
+	}
+
+	return schedule, schedule != nil, nil
+}
+
+// Updates a schedule inside a single BoltDB write transaction
+func (s *FileStore) UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error {
+	key := []byte(scheduleKey(namespace, id))
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(schedulesBucket)
+
+		var current *Schedule
+		if raw := bucket.Get(key); raw != nil {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				logger.Log.Errorf("error unmarshalling schedule %s from state file: %s", key, err)
+				return err
+			}
+		}
+
+		schedule, err := mutate(current)
+		if err != nil || schedule == nil {
+			return err
+		}
+		raw, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(key, raw)
+	})
+}
+
+// Removes a schedule from the file
+func (s *FileStore) DeleteSchedule(ctx context.Context, namespace string, id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).Delete([]byte(scheduleKey(namespace, id)))
+	})
+	if err != nil {
+		logger.Log.Errorf("error deleting schedule %s in state file: %s", scheduleKey(namespace, id), err)
+	}
+
+	return err
+}
+
+// Checks the file is still open, BoltDB fails transactions once it's closed
+func (s *FileStore) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
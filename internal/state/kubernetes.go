@@ -0,0 +1,651 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	// Kubernetes packages
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Annotations the annotation store keeps on each deployment
+const (
+	DesiredReplicasAnnotation = "kube-server/desired-replicas"
+	CurrentReplicasAnnotation = "kube-server/current-replicas"
+	DriftAnnotation           = "kube-server/state-drift"
+	// Only there while the namespace is hibernated
+	HibernatedReplicasAnnotation = "kube-server/hibernated-replicas"
+)
+
+// Names and label of the per-namespace ConfigMaps the Kubernetes stores keep state and history in
+const (
+	stateConfigMapName   = "kube-server-state"
+	historyConfigMapName = "kube-server-history"
+	// Schedules of the deployments in the namespace, keyed by ID
+	schedulesConfigMapName = "kube-server-schedules"
+	managedByLabel         = "app.kubernetes.io/managed-by"
+	managedByValue         = "kube-server"
+)
+
+// Objects are capped at 1MiB, the history of every deployment in a namespace shares this much of its ConfigMap
+// The rest is left for the metadata, including the managed fields the API server adds
+const maxConfigMapHistoryBytes = 768 * 1024
+
+// State store kept as annotations on the deployment itself so it's visible with kubectl
+// Annotations are too small for the history and schedules, so they go to a ConfigMap like the ConfigMap store
+type AnnotationStore struct {
+	kClient kubernetes.Interface
+	history *ConfigMapStore
+}
+
+// Creates a state store that annotates deployments
+func NewAnnotationStore(kClient kubernetes.Interface) *AnnotationStore {
+	return &AnnotationStore{kClient: kClient, history: NewConfigMapStore(kClient)}
+}
+
+// Reads the state from the annotations of a deployment or StatefulSet
+func (s *AnnotationStore) Get(ctx context.Context, key Key) (*Value, bool, error) {
+	annotations, _, err := s.getAnnotations(ctx, key)
+	if err != nil {
+		// No workload means no state
+		if errors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return valueFromAnnotations(key, annotations)
+}
+
+// Writes the state to the annotations of a deployment or StatefulSet, this does not trigger a rollout
+func (s *AnnotationStore) Set(ctx context.Context, key Key, value *Value) error {
+	return s.patchAnnotations(ctx, key, "", valueAnnotations(value))
+}
+
+// Updates the state annotations only if the workload didn't change since they were read, retrying on conflict
+func (s *AnnotationStore) Update(ctx context.Context, key Key, mutate UpdateFunc) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		annotations, resourceVersion, err := s.getAnnotations(ctx, key)
+		if err != nil {
+			return err
+		}
+		current, _, err := valueFromAnnotations(key, annotations)
+		if err != nil {
+			return err
+		}
+
+		value, err := mutate(current)
+		if err != nil || value == nil {
+			return err
+		}
+		if value == Deleted {
+			return s.patchAnnotations(ctx, key, resourceVersion, deletedAnnotations())
+		}
+
+		return s.patchAnnotations(ctx, key, resourceVersion, valueAnnotations(value))
+	})
+}
+
+// Removes the state annotations from a deployment or StatefulSet
+func (s *AnnotationStore) Delete(ctx context.Context, key Key) error {
+	err := s.patchAnnotations(ctx, key, "", deletedAnnotations())
+	if errors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Lists every deployment and StatefulSet in the cluster carrying the desired replicas annotation
+func (s *AnnotationStore) List(ctx context.Context) ([]Key, error) {
+	deployments, err := s.kClient.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logger.Log.Errorf("error listing deployments for annotation state: %s", err)
+		return nil, err
+	}
+	statefulSets, err := s.kClient.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logger.Log.Errorf("error listing StatefulSets for annotation state: %s", err)
+		return nil, err
+	}
+
+	var keys []Key
+	for _, d := range deployments.Items {
+		if _, ok := d.Annotations[DesiredReplicasAnnotation]; ok {
+			keys = append(keys, Key{Kind: KindDeployment, Namespace: d.Namespace, Name: d.Name})
+		}
+	}
+	for _, ss := range statefulSets.Items {
+		if _, ok := ss.Annotations[DesiredReplicasAnnotation]; ok {
+			keys = append(keys, Key{Kind: KindStatefulSet, Namespace: ss.Namespace, Name: ss.Name})
+		}
+	}
+
+	return keys, nil
+}
+
+// Appends a scale action to the history ConfigMap
+func (s *AnnotationStore) AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error {
+	return s.history.AppendHistory(ctx, key, entry)
+}
+
+// Gets a page of the history from the history ConfigMap
+func (s *AnnotationStore) History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error) {
+	return s.history.History(ctx, key, offset, limit)
+}
+
+// Lists every schedule from the schedules ConfigMaps
+func (s *AnnotationStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	return s.history.ListSchedules(ctx)
+}
+
+// Gets a schedule from the schedules ConfigMap
+func (s *AnnotationStore) GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error) {
+	return s.history.GetSchedule(ctx, namespace, id)
+}
+
+// Updates a schedule in the schedules ConfigMap
+func (s *AnnotationStore) UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error {
+	return s.history.UpdateSchedule(ctx, namespace, id, mutate)
+}
+
+// Removes a schedule from the schedules ConfigMap
+func (s *AnnotationStore) DeleteSchedule(ctx context.Context, namespace string, id string) error {
+	return s.history.DeleteSchedule(ctx, namespace, id)
+}
+
+// Checks we can still read deployments, which is where the state lives
+func (s *AnnotationStore) Ping(ctx context.Context) error {
+	_, err := s.kClient.AppsV1().Deployments("").List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// Only deployments and StatefulSets can be annotated, other scalable resources need another store
+func errUnsupportedKind(key Key) error {
+	return fmt.Errorf("the annotations state store can't keep state for %s, use another --store", key)
+}
+
+// Gets the annotations and resourceVersion of a deployment or StatefulSet
+func (s *AnnotationStore) getAnnotations(ctx context.Context, key Key) (map[string]string, string, error) {
+	switch key.Kind {
+	case KindStatefulSet:
+		statefulSet, err := s.kClient.AppsV1().StatefulSets(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, "", err
+		}
+		return statefulSet.Annotations, statefulSet.ResourceVersion, nil
+	case KindDeployment:
+		deployment, err := s.kClient.AppsV1().Deployments(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, "", err
+		}
+		return deployment.Annotations, deployment.ResourceVersion, nil
+	default:
+		return nil, "", errUnsupportedKind(key)
+	}
+}
+
+// Merge patches the annotations of a deployment or StatefulSet
+// With a resourceVersion the API server rejects the patch with a conflict if the workload changed since
+func (s *AnnotationStore) patchAnnotations(ctx context.Context, key Key, resourceVersion string, annotations map[string]interface{}) error {
+	metadata := map[string]interface{}{"annotations": annotations}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+
+	switch key.Kind {
+	case KindStatefulSet:
+		_, err = s.kClient.AppsV1().StatefulSets(key.Namespace).Patch(ctx, key.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case KindDeployment:
+		_, err = s.kClient.AppsV1().Deployments(key.Namespace).Patch(ctx, key.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return errUnsupportedKind(key)
+	}
+	if err != nil && !errors.IsConflict(err) {
+		logger.Log.Errorf("error patching state annotations on %s: %s", key, err)
+	}
+
+	return err
+}
+
+// Merge patch that removes every state annotation, a null in a merge patch removes the annotation
+func deletedAnnotations() map[string]interface{} {
+	return map[string]interface{}{
+		DesiredReplicasAnnotation:    nil,
+		CurrentReplicasAnnotation:    nil,
+		DriftAnnotation:              nil,
+		HibernatedReplicasAnnotation: nil,
+	}
+}
+
+// Formats the state as the annotations the store keeps, a null removes the hibernated replicas after waking up
+func valueAnnotations(value *Value) map[string]interface{} {
+	annotations := map[string]interface{}{
+		DesiredReplicasAnnotation:    strconv.Itoa(int(value.DesiredReplicas)),
+		CurrentReplicasAnnotation:    strconv.Itoa(int(value.CurrentReplicas)),
+		DriftAnnotation:              strconv.FormatBool(value.Drift),
+		HibernatedReplicasAnnotation: nil,
+	}
+	if value.HibernatedReplicas != nil {
+		annotations[HibernatedReplicasAnnotation] = strconv.Itoa(int(*value.HibernatedReplicas))
+	}
+
+	return annotations
+}
+
+// Parses the state annotations, a deployment without the desired replicas annotation has no state
+func valueFromAnnotations(key Key, annotations map[string]string) (*Value, bool, error) {
+	desired, ok := annotations[DesiredReplicasAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	desiredReplicas, err := strconv.ParseInt(desired, 10, 32)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s annotation on %s: %w", DesiredReplicasAnnotation, key, err)
+	}
+	// The other annotations are informational, fall back to the zero value if someone edited them
+	currentReplicas, _ := strconv.ParseInt(annotations[CurrentReplicasAnnotation], 10, 32)
+	drift, _ := strconv.ParseBool(annotations[DriftAnnotation])
+	value := &Value{DesiredReplicas: int32(desiredReplicas), CurrentReplicas: int32(currentReplicas), Drift: drift}
+
+	// Losing the hibernated replicas would wake the deployment up to nothing, so this one has to parse
+	if hibernated, ok := annotations[HibernatedReplicasAnnotation]; ok {
+		hibernatedReplicas, err := strconv.ParseInt(hibernated, 10, 32)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s annotation on %s: %w", HibernatedReplicasAnnotation, key, err)
+		}
+		replicas := int32(hibernatedReplicas)
+		value.HibernatedReplicas = &replicas
+	}
+
+	return value, true, nil
+}
+
+// Key of a workload in the ConfigMap data, deployments use their name and other kinds "kind_name"
+// '_' can't appear in k8s names, so a deployment can never collide with a StatefulSet
+func configMapKey(key Key) string {
+	if key.Kind == KindDeployment {
+		return key.Name
+	}
+
+	return key.Kind + "_" + key.Name
+}
+
+// Parses a key of the ConfigMap data back into a state key
+func keyFromConfigMap(namespace string, dataKey string) Key {
+	if kind, name, found := strings.Cut(dataKey, "_"); found {
+		return Key{Kind: kind, Namespace: namespace, Name: name}
+	}
+
+	return Key{Kind: KindDeployment, Namespace: namespace, Name: dataKey}
+}
+
+// State store kept in a ConfigMap per namespace, one JSON entry per deployment
+type ConfigMapStore struct {
+	kClient kubernetes.Interface
+}
+
+// Creates a state store that writes to a kube-server-state ConfigMap in each namespace
+func NewConfigMapStore(kClient kubernetes.Interface) *ConfigMapStore {
+	return &ConfigMapStore{kClient: kClient}
+}
+
+// Reads the state of a deployment from the ConfigMap in its namespace
+func (s *ConfigMapStore) Get(ctx context.Context, key Key) (*Value, bool, error) {
+	configMap, err := s.kClient.CoreV1().ConfigMaps(key.Namespace).Get(ctx, stateConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	raw, ok := configMap.Data[configMapKey(key)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var value *Value
+	err = json.Unmarshal([]byte(raw), &value)
+	if err != nil {
+		logger.Log.Errorf("error unmarshalling state for %s from ConfigMap: %s", key, err)
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// Writes the state of a deployment to the ConfigMap in its namespace, creating it if needed
+func (s *ConfigMapStore) Set(ctx context.Context, key Key, value *Value) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, key.Namespace, stateConfigMapName, true, func(data map[string]string) (bool, error) {
+		data[configMapKey(key)] = string(raw)
+		return true, nil
+	})
+}
+
+// Updates the state of a deployment in the ConfigMap, the ConfigMap update is retried with fresh data on conflict
+func (s *ConfigMapStore) Update(ctx context.Context, key Key, mutate UpdateFunc) error {
+	return s.update(ctx, key.Namespace, stateConfigMapName, true, func(data map[string]string) (bool, error) {
+		var current *Value
+		if raw, ok := data[configMapKey(key)]; ok {
+			if err := json.Unmarshal([]byte(raw), &current); err != nil {
+				logger.Log.Errorf("error unmarshalling state for %s from ConfigMap: %s", key, err)
+				return false, err
+			}
+		}
+
+		value, err := mutate(current)
+		if err != nil || value == nil {
+			return false, err
+		}
+		if value == Deleted {
+			_, existed := data[configMapKey(key)]
+			delete(data, configMapKey(key))
+			return existed, nil
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return false, err
+		}
+		data[configMapKey(key)] = string(raw)
+		return true, nil
+	})
+}
+
+// Removes the state of a deployment from the ConfigMap in its namespace
+func (s *ConfigMapStore) Delete(ctx context.Context, key Key) error {
+	return s.update(ctx, key.Namespace, stateConfigMapName, false, func(data map[string]string) (bool, error) {
+		if _, ok := data[configMapKey(key)]; !ok {
+			return false, nil
+		}
+		delete(data, configMapKey(key))
+		return true, nil
+	})
+}
+
+// Lists every deployment in every kube-server ConfigMap in the cluster
+func (s *ConfigMapStore) List(ctx context.Context) ([]Key, error) {
+	configMaps, err := s.kClient.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+	})
+	if err != nil {
+		logger.Log.Errorf("error listing state ConfigMaps: %s", err)
+		return nil, err
+	}
+
+	var keys []Key
+	for _, configMap := range configMaps.Items {
+		if configMap.Name != stateConfigMapName {
+			continue
+		}
+		for dataKey := range configMap.Data {
+			keys = append(keys, keyFromConfigMap(configMap.Namespace, dataKey))
+		}
+	}
+
+	return keys, nil
+}
+
+// Appends a scale action to the JSON list for the deployment in the history ConfigMap
+// Past maxConfigMapHistoryBytes the oldest entries in the namespace are dropped, whichever deployment they belong to
+func (s *ConfigMapStore) AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error {
+	return s.update(ctx, key.Namespace, historyConfigMapName, true, func(data map[string]string) (bool, error) {
+		var entries []HistoryEntry
+		if raw, ok := data[configMapKey(key)]; ok {
+			if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+				return false, err
+			}
+		}
+
+		entries = append(entries, *entry)
+		if len(entries) > maxHistory {
+			entries = entries[len(entries)-maxHistory:]
+		}
+
+		raw, err := json.Marshal(entries)
+		if err != nil {
+			return false, err
+		}
+		data[configMapKey(key)] = string(raw)
+		return true, trimHistory(data, maxConfigMapHistoryBytes)
+	})
+}
+
+// Drops the oldest history entries of the ConfigMap data until it fits in the budget
+func trimHistory(data map[string]string, budget int) error {
+	size := 0
+	for dataKey, raw := range data {
+		size += len(dataKey) + len(raw)
+	}
+	if size <= budget {
+		return nil
+	}
+
+	histories := map[string][]HistoryEntry{}
+	for dataKey, raw := range data {
+		var entries []HistoryEntry
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			return err
+		}
+		histories[dataKey] = entries
+	}
+
+	changed := map[string]bool{}
+	for size > budget {
+		oldest := ""
+		for dataKey, entries := range histories {
+			if len(entries) > 0 && (oldest == "" || entries[0].Timestamp.Before(histories[oldest][0].Timestamp)) {
+				oldest = dataKey
+			}
+		}
+		if oldest == "" {
+			break
+		}
+
+		// The entry and the comma after it, close enough since the budget leaves room
+		raw, err := json.Marshal(histories[oldest][0])
+		if err != nil {
+			return err
+		}
+		size -= len(raw) + 1
+		histories[oldest] = histories[oldest][1:]
+		changed[oldest] = true
+	}
+
+	for dataKey := range changed {
+		if len(histories[dataKey]) == 0 {
+			delete(data, dataKey)
+			continue
+		}
+		raw, err := json.Marshal(histories[dataKey])
+		if err != nil {
+			return err
+		}
+		data[dataKey] = string(raw)
+	}
+
+	return nil
+}
+
+// Gets a page of the history from the history ConfigMap
+func (s *ConfigMapStore) History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error) {
+	configMap, err := s.kClient.CoreV1().ConfigMaps(key.Namespace).Get(ctx, historyConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return []HistoryEntry{}, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	var entries []HistoryEntry
+	if raw, ok := configMap.Data[configMapKey(key)]; ok {
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			logger.Log.Errorf("error unmarshalling history for %s from ConfigMap: %s", key, err)
+			return nil, 0, err
+		}
+	}
+
+	return pageHistory(entries, offset, limit), len(entries), nil
+}
+
+// Lists every schedule in every kube-server schedules ConfigMap in the cluster
+func (s *ConfigMapStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	configMaps, err := s.kClient.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+	})
+	if err != nil {
+		logger.Log.Errorf("error listing schedule ConfigMaps: %s", err)
+		return nil, err
+	}
+
+	schedules := []Schedule{}
+	for _, configMap := range configMaps.Items {
+		if configMap.Name != schedulesConfigMapName {
+			continue
+		}
+		for id, raw := range configMap.Data {
+			var schedule Schedule
+			if err := json.Unmarshal([]byte(raw), &schedule); err != nil {
+				logger.Log.Errorf("error unmarshalling schedule %s from ConfigMap: %s", scheduleKey(configMap.Namespace, id), err)
+				return nil, err
+			}
+			schedules = append(schedules, schedule)
+		}
+	}
+
+	return schedules, nil
+}
+
+// Gets a schedule from the schedules ConfigMap in its namespace
+func (s *ConfigMapStore) GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error) {
+	configMap, err := s.kClient.CoreV1().ConfigMaps(namespace).Get(ctx, schedulesConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	raw, ok := configMap.Data[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var schedule *Schedule
+	if err := json.Unmarshal([]byte(raw), &schedule); err != nil {
+		logger.Log.Errorf("error unmarshalling schedule %s from ConfigMap: %s", scheduleKey(namespace, id), err)
+		return nil, false, err
+	}
+
+	return schedule, true, nil
+}
+
+// Updates a schedule in the schedules ConfigMap, retried with fresh data on conflict
+func (s *ConfigMapStore) UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error {
+	return s.update(ctx, namespace, schedulesConfigMapName, true, func(data map[string]string) (bool, error) {
+		var current *Schedule
+		if raw, ok := data[id]; ok {
+			if err := json.Unmarshal([]byte(raw), &current); err != nil {
+				logger.Log.Errorf("error unmarshalling schedule %s from ConfigMap: %s", scheduleKey(namespace, id), err)
+				return false, err
+			}
+		}
+
+		schedule, err := mutate(current)
+		if err != nil || schedule == nil {
+			return false, err
+		}
+		raw, err := json.Marshal(schedule)
+		if err != nil {
+			return false, err
+		}
+		data[id] = string(raw)
+		return true, nil
+	})
+}
+
+// Removes a schedule from the schedules ConfigMap in its namespace
+func (s *ConfigMapStore) DeleteSchedule(ctx context.Context, namespace string, id string) error {
+	return s.update(ctx, namespace, schedulesConfigMapName, false, func(data map[string]string) (bool, error) {
+		if _, ok := data[id]; !ok {
+			return false, nil
+		}
+		delete(data, id)
+		return true, nil
+	})
+}
+
+// Checks we can still read ConfigMaps, which is where the state lives
+func (s *ConfigMapStore) Ping(ctx context.Context) error {
+	_, err := s.kClient.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// Read-modify-write of the ConfigMap data, retried when another replica updated it first
+// mutate reports whether it changed the data, nothing is written to the API server when it didn't
+func (s *ConfigMapStore) update(ctx context.Context, namespace string, name string, create bool, mutate func(map[string]string) (bool, error)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := s.kClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			if !create {
+				return nil
+			}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    map[string]string{managedByLabel: managedByValue},
+				},
+				Data: map[string]string{},
+			}
+			changed, err := mutate(configMap.Data)
+			if err != nil || !changed {
+				return err
+			}
+			_, err = s.kClient.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
+			// Another replica created it first, retry as an update
+			if errors.IsAlreadyExists(err) {
+				return errors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		changed, err := mutate(configMap.Data)
+		if err != nil || !changed {
+			return err
+		}
+		_, err = s.kClient.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		logger.Log.Errorf("error updating ConfigMap %s/%s: %s", namespace, name, err)
+	}
+
+	return err
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// State store kept in process memory, state is lost on restart and not shared between replicas
+type MemoryStore struct {
+	mu      sync.RWMutex
+	values  map[Key]Value
+	history map[Key][]HistoryEntry
+	// Keyed by namespace/id
+	schedules map[string]Schedule
+}
+
+// Creates an empty in-memory state store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: map[Key]Value{}, history: map[Key][]HistoryEntry{}, schedules: map[string]Schedule{}}
+}
+
+// Gets the state of a deployment from memory
+func (s *MemoryStore) Get(ctx context.Context, key Key) (*Value, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &value, true, nil
+}
+
+// Sets the state of a deployment in memory
+func (s *MemoryStore) Set(ctx context.Context, key Key, value *Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = *value
+
+	return nil
+}
+
+// Updates the state of a deployment in memory under the write lock
+func (s *MemoryStore) Update(ctx context.Context, key Key, mutate UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Value
+	if value, ok := s.values[key]; ok {
+		current = &value
+	}
+
+	value, err := mutate(current)
+	if err != nil || value == nil {
+		return err
+	}
+	if value == Deleted {
+		delete(s.values, key)
+		return nil
+	}
+	s.values[key] = *value
+
+	return nil
+}
+
+// Removes the state of a deployment from memory
+func (s *MemoryStore) Delete(ctx context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+
+	return nil
+}
+
+// Lists every deployment with state in memory
+func (s *MemoryStore) List(ctx context.Context) ([]Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]Key, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Appends a scale action to the history in memory
+func (s *MemoryStore) AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := append(s.history[key], *entry)
+	if len(entries) > maxHistory {
+		entries = append([]HistoryEntry{}, entries[len(entries)-maxHistory:]...)
+	}
+	s.history[key] = entries
+
+	return nil
+}
+
+// Gets a page of the history in memory
+func (s *MemoryStore) History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := s.history[key]
+
+	return pageHistory(entries, offset, limit), len(entries), nil
+}
+
+// Lists every schedule in memory
+func (s *MemoryStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// Gets a schedule from memory
+func (s *MemoryStore) GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedule, ok := s.schedules[scheduleKey(namespace, id)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &schedule, true, nil
+}
+
+// Updates a schedule in memory under the write lock
+func (s *MemoryStore) UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Schedule
+	if schedule, ok := s.schedules[scheduleKey(namespace, id)]; ok {
+		current = &schedule
+	}
+
+	schedule, err := mutate(current)
+	if err != nil || schedule == nil {
+		return err
+	}
+	s.schedules[scheduleKey(namespace, id)] = *schedule
+
+	return nil
+}
+
+// Removes a schedule from memory
+func (s *MemoryStore) DeleteSchedule(ctx context.Context, namespace string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.schedules, scheduleKey(namespace, id))
+
+	return nil
+}
+
+// Memory is always reachable
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
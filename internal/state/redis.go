@@ -0,0 +1,356 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+)
+
+// Redis set holding every namespace/deployment pair that has state in Redis
+const trackedKey = "kube-server:tracked"
+
+// Prefix of the Redis lists holding the history of each deployment
+const historyKeyPrefix = "kube-server:history:"
+
+// Redis hash holding every schedule as JSON, keyed by namespace/id
+const schedulesKey = "kube-server:schedules"
+
+// How many times an update is retried when another client changes the key mid-transaction
+const maxUpdateRetries = 10
+
+// State store backed by Redis, one JSON string key per deployment
+type RedisStore struct {
+	client *redis.Client
+}
+
+// Creates a state store from an already connected Redis client
+func NewRedisStore(rClient *redis.Client) *RedisStore {
+	return &RedisStore{client: rClient}
+}
+
+// Helper to form the key for state in Redis
+// Deployments keep the original "namespace-name" keys, other kinds are prefixed so they can't collide with them
+func genRedisKey(key Key) string {
+	if key.Kind == KindDeployment {
+		return fmt.Sprintf("%s-%s", key.Namespace, key.Name)
+	}
+
+	return fmt.Sprintf("%s:%s-%s", key.Kind, key.Namespace, key.Name)
+}
+
+// Gets State via the value of the Redis key
+func (s *RedisStore) Get(ctx context.Context, key Key) (*Value, bool, error) {
+	var redisGetValues *Value
+	redisKey := genRedisKey(key)
+
+	// Check if the key exists, if not return back with false
+	keyExists := s.client.Exists(ctx, redisKey).Val()
+	if keyExists == 0 {
+		logger.Log.Debugf("key %s does not exist in Redis, returning false", redisKey)
+		return nil, false, nil
+	}
+
+	// Gets the existing key in Redis
+	rGet, err := s.client.Get(ctx, redisKey).Result()
+	if err != nil {
+		logger.Log.Errorf("error getting key %s from Redis: %s", redisKey, err)
+		return nil, false, err
+	}
+
+	logger.Log.Debugf("key %s found in Redis", redisKey)
+
+	// Unmarshal the value from Redis into the Value struct
+	err = json.Unmarshal([]byte(rGet), &redisGetValues)
+	if err != nil {
+		logger.Log.Errorf("error unmarshalling key %s from Redis: %s", redisKey, err)
+		return nil, false, err
+	}
+
+	return redisGetValues, true, nil
+}
+
+// Sets the state in Redis and tracks the deployment
+func (s *RedisStore) Set(ctx context.Context, key Key, value *Value) error {
+	redisKey := genRedisKey(key)
+
+	logger.Log.Debugf("setting key %s in Redis", redisKey)
+	// Marshals the values to JSON
+	redisJson, err := json.Marshal(value)
+	if err != nil {
+		logger.Log.Errorf("error marshalling key %s to Redis: %s", redisKey, err)
+		return err
+	}
+
+	// Sets the new values in Redis
+	_, err = s.client.Set(ctx, redisKey, redisJson, 0).Result()
+	if err != nil {
+		logger.Log.Errorf("error setting key %s in Redis: %s", redisKey, err)
+		return err
+	}
+
+	// Track the deployment so the reconciler can find it later
+	_, err = s.client.SAdd(ctx, trackedKey, key.String()).Result()
+	if err != nil {
+		logger.Log.Errorf("error tracking key %s in Redis: %s", redisKey, err)
+		return err
+	}
+
+	return nil
+}
+
+// Reads and replaces the state in a WATCH/MULTI transaction so kube-server replicas can't overwrite each other
+// The transaction is retried if another client changed the key after it was read
+func (s *RedisStore) Update(ctx context.Context, key Key, mutate UpdateFunc) error {
+	redisKey := genRedisKey(key)
+
+	update := func(tx *redis.Tx) error {
+		var current *Value
+		raw, err := tx.Get(ctx, redisKey).Bytes()
+		switch {
+		case err == redis.Nil:
+			// Nothing stored yet
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(raw, &current); err != nil {
+				logger.Log.Errorf("error unmarshalling key %s from Redis: %s", redisKey, err)
+				return err
+			}
+		}
+
+		value, err := mutate(current)
+		if err != nil || value == nil {
+			return err
+		}
+		if value == Deleted {
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, redisKey)
+				pipe.SRem(ctx, trackedKey, key.String())
+				return nil
+			})
+			return err
+		}
+		redisJson, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		// Only runs if nobody touched the key since the WATCH
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisKey, redisJson, 0)
+			pipe.SAdd(ctx, trackedKey, key.String())
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := s.client.Watch(ctx, update, redisKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		logger.Log.Debugf("key %s changed during the transaction, retrying", redisKey)
+	}
+
+	return fmt.Errorf("key %s in Redis kept changing, gave up after %d attempts", redisKey, maxUpdateRetries)
+}
+
+// Removes the state and tracking of a deployment from Redis
+func (s *RedisStore) Delete(ctx context.Context, key Key) error {
+	redisKey := genRedisKey(key)
+
+	logger.Log.Debugf("deleting key %s in Redis", redisKey)
+	_, err := s.client.Del(ctx, redisKey).Result()
+	if err != nil {
+		logger.Log.Errorf("error deleting key %s in Redis: %s", redisKey, err)
+		return err
+	}
+
+	_, err = s.client.SRem(ctx, trackedKey, key.String()).Result()
+	if err != nil {
+		logger.Log.Errorf("error untracking key %s in Redis: %s", redisKey, err)
+		return err
+	}
+
+	return nil
+}
+
+// Lists the deployments in the tracked set
+func (s *RedisStore) List(ctx context.Context) ([]Key, error) {
+	members, err := s.client.SMembers(ctx, trackedKey).Result()
+	if err != nil {
+		logger.Log.Errorf("error listing tracked deployments from Redis: %s", err)
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(members))
+	for _, member := range members {
+		key, ok := ParseKey(member)
+		if !ok {
+			logger.Log.Warnf("skipping malformed tracked deployment %s", member)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Pushes a scale action onto the head of the history list so it reads newest first, trimming the oldest in the same transaction
+func (s *RedisStore) AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error {
+	historyJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	historyKey := historyKeyPrefix + key.String()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, historyKey, historyJson)
+		pipe.LTrim(ctx, historyKey, 0, maxHistory-1)
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("error appending history for %s in Redis: %s", key, err)
+	}
+
+	return err
+}
+
+// Gets a page of the history list
+func (s *RedisStore) History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error) {
+	historyKey := historyKeyPrefix + key.String()
+
+	total, err := s.client.LLen(ctx, historyKey).Result()
+	if err != nil {
+		logger.Log.Errorf("error getting history length for %s from Redis: %s", key, err)
+		return nil, 0, err
+	}
+
+	raw, err := s.client.LRange(ctx, historyKey, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		logger.Log.Errorf("error getting history for %s from Redis: %s", key, err)
+		return nil, 0, err
+	}
+
+	entries := make([]HistoryEntry, 0, len(raw))
+	for _, r := range raw {
+		var entry HistoryEntry
+		err = json.Unmarshal([]byte(r), &entry)
+		if err != nil {
+			logger.Log.Errorf("error unmarshalling history for %s from Redis: %s", key, err)
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, int(total), nil
+}
+
+// Lists every schedule in the schedules hash
+func (s *RedisStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	raw, err := s.client.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		logger.Log.Errorf("error listing schedules from Redis: %s", err)
+		return nil, err
+	}
+
+	schedules := make([]Schedule, 0, len(raw))
+	for field, r := range raw {
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(r), &schedule); err != nil {
+			logger.Log.Errorf("error unmarshalling schedule %s from Redis: %s", field, err)
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// Gets a schedule from the schedules hash
+func (s *RedisStore) GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error) {
+	field := scheduleKey(namespace, id)
+
+	raw, err := s.client.HGet(ctx, schedulesKey, field).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		logger.Log.Errorf("error getting schedule %s from Redis: %s", field, err)
+		return nil, false, err
+	}
+
+	var schedule *Schedule
+	if err := json.Unmarshal(raw, &schedule); err != nil {
+		logger.Log.Errorf("error unmarshalling schedule %s from Redis: %s", field, err)
+		return nil, false, err
+	}
+
+	return schedule, true, nil
+}
+
+// Reads and replaces a schedule in a WATCH/MULTI transaction on the schedules hash, retried like Update
+func (s *RedisStore) UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error {
+	field := scheduleKey(namespace, id)
+
+	update := func(tx *redis.Tx) error {
+		var current *Schedule
+		raw, err := tx.HGet(ctx, schedulesKey, field).Bytes()
+		switch {
+		case err == redis.Nil:
+			// No such schedule yet
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(raw, &current); err != nil {
+				logger.Log.Errorf("error unmarshalling schedule %s from Redis: %s", field, err)
+				return err
+			}
+		}
+
+		schedule, err := mutate(current)
+		if err != nil || schedule == nil {
+			return err
+		}
+		scheduleJson, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, schedulesKey, field, scheduleJson)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := s.client.Watch(ctx, update, schedulesKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		logger.Log.Debugf("schedules changed during the transaction, retrying %s", field)
+	}
+
+	return fmt.Errorf("schedules in Redis kept changing, gave up on %s after %d attempts", field, maxUpdateRetries)
+}
+
+// Removes a schedule from the schedules hash
+func (s *RedisStore) DeleteSchedule(ctx context.Context, namespace string, id string) error {
+	field := scheduleKey(namespace, id)
+
+	_, err := s.client.HDel(ctx, schedulesKey, field).Result()
+	if err != nil {
+		logger.Log.Errorf("error deleting schedule %s in Redis: %s", field, err)
+	}
+
+	return err
+}
+
+// Checks the connection to Redis
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
@@ -0,0 +1,434 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+// Tests deployments keep their original Redis keys and StatefulSets can't collide with them
+func TestGenRedisKey(t *testing.T) {
+	testCases := []struct {
+		key      Key
+		expected string
+	}{
+		{key: Key{Namespace: "namespace", Name: "web"}, expected: "namespace-web"},
+		{key: Key{Kind: KindStatefulSet, Namespace: "namespace", Name: "web"}, expected: "statefulset:namespace-web"},
+	}
+
+	for _, test := range testCases {
+		if got := genRedisKey(test.key); got != test.expected {
+			t.Errorf("Fail: got %s want %s", got, test.expected)
+		}
+	}
+}
+
+// Tests getting a deployment state object from Redis
+func TestRedisGet(t *testing.T) {
+	testCases := []struct {
+		name             string
+		description      string
+		expectSuccess    bool
+		key              Key
+		keyExists        bool
+		expectedResponse *Value
+	}{
+		{
+			name:             "replicas-first-get",
+			description:      "This is the first time the server has seen the deployment, so it will return nothing",
+			key:              Key{Namespace: "namespace", Name: "first-replicas-deployment"},
+			expectSuccess:    true,
+			keyExists:        false,
+			expectedResponse: nil,
+		},
+
+		{
+			name:          "replicas-get-no-drift",
+			description:   "This key exists in Redis with a value",
+			key:           Key{Namespace: "namespace", Name: "replicas-deployment"},
+			expectSuccess: true,
+			keyExists:     true,
+			expectedResponse: &Value{
+				DesiredReplicas: 4,
+				CurrentReplicas: 4,
+				Drift:           false,
+			},
+		},
+		{
+			name:          "replicas-get-with-drift",
+			description:   "This key exists in Redis with a value and has drift",
+			key:           Key{Namespace: "namespace", Name: "drift-replicas-deployment"},
+			expectSuccess: true,
+			keyExists:     true,
+			expectedResponse: &Value{
+				DesiredReplicas: 4,
+				CurrentReplicas: 2,
+				Drift:           true,
+			},
+		},
+		{
+			name:          "replicas-get-drift-incorrect",
+			description:   "This key exists in Redis with a value and has drift but is not marked as having drift",
+			key:           Key{Namespace: "namespace", Name: "drift-replicas-deployment-false"},
+			expectSuccess: true,
+			keyExists:     true,
+			expectedResponse: &Value{
+				DesiredReplicas: 4,
+				CurrentReplicas: 2,
+				Drift:           false,
+			},
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Init mock Redis client
+			db, mock := redismock.NewClientMock()
+			redisKey := genRedisKey(test.key)
+
+			// Handle the key exists or not and the appropriate Redis response
+			if !test.keyExists {
+				mock.ExpectExists(redisKey).RedisNil()
+			} else {
+				mock.ExpectExists(redisKey).SetVal(1)
+
+				// To match we are marshalling to JSON as well
+				rGetJson, err := json.Marshal(test.expectedResponse)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mock.ExpectGet(redisKey).SetVal(string(rGetJson))
+			}
+
+			// Run the function with the mock client and stubbed data
+			testResp, key, err := NewRedisStore(db).Get(context.TODO(), test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Test the input against the output of the function
+			// Also test if the correct values were returned if the key does not exist
+			switch {
+			case test.expectSuccess && !reflect.DeepEqual(testResp, test.expectedResponse):
+				t.Errorf("Fail: got %v want %v",
+					testResp, test.expectedResponse)
+			case test.keyExists != key:
+				t.Errorf("Redis key presence: got %v want %v", key, test.keyExists)
+			default:
+				t.Logf("test passed %v", testResp)
+			}
+
+		},
+		)
+	}
+
+}
+
+// Test for setting the state via Redis
+func TestRedisSet(t *testing.T) {
+	testCases := []struct {
+		name        string
+		description string
+		key         Key
+		value       Value
+	}{
+		{
+			name:        "replicas-first-set",
+			description: "This is the first time the server will set a key",
+			key:         Key{Namespace: "namespace", Name: "first-replicas-deployment"},
+			value: Value{
+				DesiredReplicas: 4,
+				CurrentReplicas: 4,
+				Drift:           true,
+			},
+		},
+
+		{
+			name:        "replicas-scale-down",
+			description: "This will update a key with a new desired and current replicas value",
+			key:         Key{Namespace: "namespace", Name: "replicas-scale-down"},
+			value: Value{
+				DesiredReplicas: 2,
+				CurrentReplicas: 2,
+				Drift:           false,
+			},
+		},
+
+		{
+			name:        "replicas-scale-up",
+			description: "This will update a key with a new desired and current replicas value",
+			key:         Key{Namespace: "namespace", Name: "replicas-scale-up"},
+			value: Value{
+				DesiredReplicas: 6,
+				CurrentReplicas: 6,
+				Drift:           false,
+			},
+		},
+	}
+
+	// Loop to run each test case
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Init mock Redis client
+			db, mock := redismock.NewClientMock()
+
+			// To match we are marshalling to JSON as well
+			rSetJson, err := json.Marshal(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mock.ExpectSet(genRedisKey(test.key), rSetJson, 0).SetVal("")
+			mock.ExpectSAdd(trackedKey, test.key.String()).SetVal(1)
+
+			// Run the function with the mock client and stubbed data
+			err = NewRedisStore(db).Set(context.TODO(), test.key, &test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Fail: %s", err)
+			}
+		},
+		)
+	}
+
+}
+
+// Tests updating the state in a WATCH/MULTI transaction, retrying when another client changed the key
+func TestRedisUpdate(t *testing.T) {
+	key := Key{Namespace: "namespace", Name: "replicas-deployment"}
+	redisKey := genRedisKey(key)
+	stored, err := json.Marshal(Value{DesiredReplicas: 2, CurrentReplicas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := json.Marshal(Value{DesiredReplicas: 3, CurrentReplicas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		description   string
+		conflicts     int
+		expectSuccess bool
+	}{
+		{name: "update", description: "Nobody else touches the key", expectSuccess: true},
+		{name: "retry", description: "Another client changed the key once so the transaction runs again", conflicts: 1, expectSuccess: true},
+		{name: "give-up", description: "The key never stops changing", conflicts: maxUpdateRetries, expectSuccess: false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			attempts := test.conflicts + 1
+			if attempts > maxUpdateRetries {
+				attempts = maxUpdateRetries
+			}
+			for i := 0; i < attempts; i++ {
+				mock.ExpectWatch(redisKey)
+				mock.ExpectGet(redisKey).SetVal(string(stored))
+				mock.ExpectTxPipeline()
+				mock.ExpectSet(redisKey, updated, 0).SetVal("OK")
+				mock.ExpectSAdd(trackedKey, key.String()).SetVal(1)
+				if i < test.conflicts {
+					mock.ExpectTxPipelineExec().SetErr(redis.TxFailedErr)
+				} else {
+					mock.ExpectTxPipelineExec()
+				}
+			}
+
+			err := NewRedisStore(db).Update(context.TODO(), key, func(current *Value) (*Value, error) {
+				return &Value{DesiredReplicas: current.DesiredReplicas + 1, CurrentReplicas: current.CurrentReplicas}, nil
+			})
+			switch {
+			case test.expectSuccess && err != nil:
+				t.Errorf("expected success, got error: %v", err)
+			case !test.expectSuccess && err == nil:
+				t.Errorf("expected error, got success")
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Fail: %s", err)
+			}
+		})
+	}
+}
+
+// Tests returning Deleted from an update removes the key and its tracking in the transaction
+func TestRedisUpdateDeleted(t *testing.T) {
+	key := Key{Namespace: "namespace", Name: "replicas-deployment"}
+	redisKey := genRedisKey(key)
+	stored, err := json.Marshal(Value{DesiredReplicas: 2, CurrentReplicas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock := redismock.NewClientMock()
+	mock.ExpectWatch(redisKey)
+	mock.ExpectGet(redisKey).SetVal(string(stored))
+	mock.ExpectTxPipeline()
+	mock.ExpectDel(redisKey).SetVal(1)
+	mock.ExpectSRem(trackedKey, key.String()).SetVal(1)
+	mock.ExpectTxPipelineExec()
+
+	err = NewRedisStore(db).Update(context.TODO(), key, func(current *Value) (*Value, error) {
+		return Deleted, nil
+	})
+	if err != nil {
+		t.Errorf("expected success, got error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Fail: %s", err)
+	}
+}
+
+// Tests listing the tracked deployments from the Redis set
+func TestRedisList(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectSMembers(trackedKey).SetVal([]string{"namespace/deployment", "malformed"})
+
+	keys, err := NewRedisStore(db).List(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Key{{Namespace: "namespace", Name: "deployment"}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Fail: got %v want %v", keys, expected)
+	}
+}
+
+// Tests appending to the history list trims it to the newest entries in the same transaction
+func TestRedisAppendHistory(t *testing.T) {
+	key := Key{Namespace: "namespace", Name: "deployment"}
+	entry := HistoryEntry{Actor: "test", FromReplicas: 1, ToReplicas: 2, Result: ResultSuccess}
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock := redismock.NewClientMock()
+	mock.ExpectTxPipeline()
+	mock.ExpectLPush(historyKeyPrefix+key.String(), entryJson).SetVal(1)
+	mock.ExpectLTrim(historyKeyPrefix+key.String(), 0, maxHistory-1).SetVal("OK")
+	mock.ExpectTxPipelineExec()
+
+	if err := NewRedisStore(db).AppendHistory(context.TODO(), key, &entry); err != nil {
+		t.Errorf("expected success, got error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Fail: %s", err)
+	}
+}
+
+// Tests reading a page of the history list from Redis
+func TestRedisHistory(t *testing.T) {
+	key := Key{Namespace: "namespace", Name: "deployment"}
+	entry := HistoryEntry{Actor: "test", FromReplicas: 1, ToReplicas: 2, Result: ResultSuccess}
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock := redismock.NewClientMock()
+	mock.ExpectLLen(historyKeyPrefix + key.String()).SetVal(3)
+	mock.ExpectLRange(historyKeyPrefix+key.String(), 1, 1).SetVal([]string{string(entryJson)})
+
+	entries, total, err := NewRedisStore(db).History(context.TODO(), key, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case total != 3:
+		t.Errorf("Fail: got total %d want 3", total)
+	case !reflect.DeepEqual(entries, []HistoryEntry{entry}):
+		t.Errorf("Fail: got %v want %v", entries, []HistoryEntry{entry})
+	default:
+		t.Logf("test passed %v", entries)
+	}
+}
+
+// Tests reading, updating and deleting schedules in the Redis hash
+func TestRedisSchedules(t *testing.T) {
+	schedule := Schedule{ID: "weekdays", Namespace: "namespace", Deployment: "deployment", Cron: "0 8 * * 1-5", Replicas: 5}
+	scheduleJson, err := json.Marshal(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := scheduleKey("namespace", "weekdays")
+
+	db, mock := redismock.NewClientMock()
+	store := NewRedisStore(db)
+
+	mock.ExpectHGet(schedulesKey, field).RedisNil()
+	_, exists, err := store.GetSchedule(context.TODO(), "namespace", "weekdays")
+	if err != nil || exists {
+		t.Errorf("Fail: got %v, %v want no schedule", exists, err)
+	}
+
+	mock.ExpectWatch(schedulesKey)
+	mock.ExpectHGet(schedulesKey, field).RedisNil()
+	mock.ExpectTxPipeline()
+	mock.ExpectHSet(schedulesKey, field, scheduleJson).SetVal(1)
+	mock.ExpectTxPipelineExec()
+	err = store.UpdateSchedule(context.TODO(), "namespace", "weekdays", func(current *Schedule) (*Schedule, error) {
+		return &schedule, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectHGetAll(schedulesKey).SetVal(map[string]string{field: string(scheduleJson)})
+	schedules, err := store.ListSchedules(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schedules, []Schedule{schedule}) {
+		t.Errorf("Fail: got %v want %v", schedules, []Schedule{schedule})
+	}
+
+	mock.ExpectHDel(schedulesKey, field).SetVal(1)
+	if err := store.DeleteSchedule(context.TODO(), "namespace", "weekdays"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Fail: %s", err)
+	}
+}
+
+// Tests pinging Redis for the readiness check
+func TestRedisPing(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	store := NewRedisStore(db)
+
+	mock.ExpectPing().SetVal("PONG")
+	if err := store.Ping(context.TODO()); err != nil {
+		t.Errorf("Fail: got %s want no error", err)
+	}
+
+	mock.ExpectPing().SetErr(errors.New("connection refused"))
+	if err := store.Ping(context.TODO()); err == nil {
+		t.Errorf("Fail: got no error want connection refused")
+	}
+}
@@ -0,0 +1,31 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recurring scale of a deployment to a fixed number of replicas on a cron schedule
+type Schedule struct {
+	ID         string `json:"id"`
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment_name"`
+	// Standard 5 field cron expression, evaluated in TimeZone or UTC if that's empty
+	Cron       string     `json:"cron"`
+	TimeZone   string     `json:"time_zone,omitempty"`
+	Replicas   int32      `json:"replica_size"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastResult string     `json:"last_result,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// Gets the current schedule, nil if there is none, and returns the schedule to store instead
+// Like UpdateFunc, returning nil leaves it as it is and an error aborts the update
+type ScheduleUpdateFunc func(current *Schedule) (*Schedule, error)
+
+// Key of a schedule in the stores that keep every schedule together, IDs are unique per namespace
+func scheduleKey(namespace string, id string) string {
+	return fmt.Sprintf("%s/%s", namespace, id)
+}
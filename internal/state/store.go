@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Backends that can be selected for the state store
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	// Kubernetes-native backends that keep the state in the cluster
+	BackendAnnotations = "annotations"
+	BackendConfigMap   = "configmap"
+)
+
+// Desired and current replicas of a deployment and whether they have drifted
+// HibernatedReplicas is set while the namespace is hibernated to the replicas it wakes up to
+type Value struct {
+	DesiredReplicas    int32  `json:"desired_replicas"`
+	CurrentReplicas    int32  `json:"current_replicas"`
+	Drift              bool   `json:"state_drift"`
+	HibernatedReplicas *int32 `json:"hibernated_replicas,omitempty"`
+}
+
+// Results recorded in the scale history
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Why a scale was made, recorded with scales that didn't come straight from a request
+const (
+	// Scaled back because another workload of an atomic batch failed
+	ReasonRollback = "rollback"
+)
+
+// A single scale action in the history of a deployment
+type HistoryEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Actor        string    `json:"actor"`
+	FromReplicas int32     `json:"from_replicas"`
+	ToReplicas   int32     `json:"to_replicas"`
+	Result       string    `json:"result"`
+	Reason       string    `json:"reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Kinds of workloads the state store tracks
+// Any other scalable resource uses its resource and group as the kind, like "rollouts.argoproj.io"
+const (
+	// Deployments have no kind so keys stored before StatefulSets were supported stay the same
+	KindDeployment  = ""
+	KindStatefulSet = "statefulset"
+)
+
+// Identifies a deployment, StatefulSet or other scalable resource tracked in the state store
+type Key struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// Human readable form of the key, '/' and ':' can't appear in k8s names so it splits cleanly
+// Deployments are "namespace/name" and other kinds "kind:namespace/name"
+func (k Key) String() string {
+	if k.Kind == KindDeployment {
+		return fmt.Sprintf("%s/%s", k.Namespace, k.Name)
+	}
+
+	return fmt.Sprintf("%s:%s/%s", k.Kind, k.Namespace, k.Name)
+}
+
+// Parses a key from its String form
+func ParseKey(s string) (Key, bool) {
+	var key Key
+	if kind, rest, found := strings.Cut(s, ":"); found {
+		if !validKind(kind) {
+			return Key{}, false
+		}
+		key.Kind = kind
+		s = rest
+	}
+
+	namespace, name, found := strings.Cut(s, "/")
+	if !found || namespace == "" || name == "" {
+		return Key{}, false
+	}
+	key.Namespace = namespace
+	key.Name = name
+
+	return key, true
+}
+
+// Checks the kind could be a resource and group, which keeps the key separators out of it
+func validKind(kind string) bool {
+	if kind == "" {
+		return false
+	}
+	for _, c := range kind {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returned from an UpdateFunc to remove the state and stop tracking the workload, like Delete but atomic with the read
+var Deleted = &Value{}
+
+// Gets the current state, nil if nothing is stored yet, and returns the state to store instead
+// Returning nil leaves the state as it is, Deleted removes it, an error aborts the update and is returned from Update unchanged
+// Stores may call it more than once when another writer got in first, so it shouldn't have side effects
+type UpdateFunc func(current *Value) (*Value, error)
+
+// Storage for the replica state of deployments
+type StateStore interface {
+	// Gets the state of a deployment, the bool is false if nothing is stored for it yet
+	Get(ctx context.Context, key Key) (*Value, bool, error)
+	// Stores the state of a deployment and starts tracking it
+	Set(ctx context.Context, key Key, value *Value) error
+	// Removes the state of a deployment and stops tracking it
+	Delete(ctx context.Context, key Key) error
+	// Lists every tracked deployment
+	List(ctx context.Context) ([]Key, error)
+	// Atomically reads and replaces the state of a deployment, concurrent writers can't slip in between
+	Update(ctx context.Context, key Key, mutate UpdateFunc) error
+	// Appends a scale action to the history of a deployment, history is kept when the state is deleted
+	AppendHistory(ctx context.Context, key Key, entry *HistoryEntry) error
+	// Gets a page of the history of a deployment newest first, along with the total number of entries
+	History(ctx context.Context, key Key, offset int, limit int) ([]HistoryEntry, int, error)
+	// Lists every scale schedule
+	ListSchedules(ctx context.Context) ([]Schedule, error)
+	// Gets a scale schedule, the bool is false if there is no such schedule
+	GetSchedule(ctx context.Context, namespace string, id string) (*Schedule, bool, error)
+	// Atomically creates or replaces a scale schedule, concurrent writers can't slip in between
+	UpdateSchedule(ctx context.Context, namespace string, id string, mutate ScheduleUpdateFunc) error
+	// Removes a scale schedule, removing one that doesn't exist is not an error
+	DeleteSchedule(ctx context.Context, namespace string, id string) error
+	// Checks the store can be reached, used by the readiness check
+	Ping(ctx context.Context) error
+}
+
+// Only the newest history entries per deployment are kept
+// The ConfigMap store shares one ConfigMap between every deployment of a namespace, so it also trims by size, see maxConfigMapHistoryBytes
+const maxHistory = 500
+
+// Helper to slice a page out of a history kept oldest first, returning it newest first
+func pageHistory(entries []HistoryEntry, offset int, limit int) []HistoryEntry {
+	page := []HistoryEntry{}
+	for i := len(entries) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, entries[i])
+	}
+
+	return page
+}
@@ -0,0 +1,533 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+// Builds every StateStore that doesn't need a server
+func newTestStores(t *testing.T) map[string]StateStore {
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fileStore.Close() })
+
+	// The annotation store can only hold state for workloads that exist
+	newFakeClientset := func() *testclient.Clientset {
+		replicas := int32(1)
+		return testclient.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployment", Namespace: "namespace"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployment", Namespace: "namespace"},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		})
+	}
+
+	return map[string]StateStore{
+		BackendMemory:      NewMemoryStore(),
+		BackendFile:        fileStore,
+		BackendAnnotations: NewAnnotationStore(newFakeClientset()),
+		BackendConfigMap:   NewConfigMapStore(newFakeClientset()),
+	}
+}
+
+// Runs the same behavior checks against every StateStore that doesn't need a server
+func TestStateStores(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			key := Key{Namespace: "namespace", Name: "deployment"}
+			value := &Value{DesiredReplicas: 4, CurrentReplicas: 2, Drift: true}
+
+			if err := store.Ping(ctx); err != nil {
+				t.Fatalf("Fail: ping failed: %s", err)
+			}
+
+			// Nothing is stored yet
+			got, exists, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists || got != nil {
+				t.Errorf("Fail: got %v, %v want nil, false", got, exists)
+			}
+
+			// Stored values come back and are tracked
+			if err := store.Set(ctx, key, value); err != nil {
+				t.Fatal(err)
+			}
+			got, exists, err = store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists || !reflect.DeepEqual(got, value) {
+				t.Errorf("Fail: got %v, %v want %v, true", got, exists, value)
+			}
+			keys, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, []Key{key}) {
+				t.Errorf("Fail: got %v want %v", keys, []Key{key})
+			}
+
+			// Deleted values are gone and untracked
+			if err := store.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			_, exists, err = store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, err = store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists || len(keys) != 0 {
+				t.Errorf("Fail: key %s still stored after delete", key)
+			}
+		})
+	}
+}
+
+// Checks every StateStore keeps the hibernated replicas and drops them again on wake
+func TestStateStoreHibernatedReplicas(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			key := Key{Namespace: "namespace", Name: "deployment"}
+			hibernated := int32(0)
+
+			for _, value := range []*Value{
+				{DesiredReplicas: 0, CurrentReplicas: 0, HibernatedReplicas: &hibernated},
+				{DesiredReplicas: 3, CurrentReplicas: 3},
+			} {
+				if err := store.Set(ctx, key, value); err != nil {
+					t.Fatal(err)
+				}
+				got, _, err := store.Get(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, value) {
+					t.Errorf("Fail: got %+v want %+v", got, value)
+				}
+			}
+		})
+	}
+}
+
+// Runs the same read-modify-write checks against every StateStore that doesn't need a server
+func TestStateStoreUpdate(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			key := Key{Namespace: "namespace", Name: "deployment"}
+
+			// Nothing stored yet, so the update starts from nil
+			err := store.Update(ctx, key, func(current *Value) (*Value, error) {
+				if current != nil {
+					t.Errorf("Fail: got %v want nil", current)
+				}
+				return &Value{DesiredReplicas: 1, CurrentReplicas: 1}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Errors abort the update and come back unchanged
+			errAbort := errors.New("abort")
+			err = store.Update(ctx, key, func(current *Value) (*Value, error) {
+				return &Value{DesiredReplicas: 5}, errAbort
+			})
+			if err != errAbort {
+				t.Errorf("Fail: got %v want %v", err, errAbort)
+			}
+
+			// Returning nil leaves the state as it is
+			err = store.Update(ctx, key, func(current *Value) (*Value, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := &Value{DesiredReplicas: 1, CurrentReplicas: 1}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Fail: got %v want %v", got, expected)
+			}
+
+			// Returning Deleted removes the state and stops tracking it
+			err = store.Update(ctx, key, func(current *Value) (*Value, error) {
+				return Deleted, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, exists, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists || len(keys) != 0 {
+				t.Errorf("Fail: got state %v and keys %v after deleting", exists, keys)
+			}
+		})
+	}
+}
+
+// Tests the history ConfigMap of a namespace stays below its size budget by dropping the oldest entries of any deployment
+func TestConfigMapStoreHistoryBudget(t *testing.T) {
+	kClient := testclient.NewSimpleClientset()
+	store := NewConfigMapStore(kClient)
+	ctx := context.TODO()
+	keys := []Key{{Namespace: "namespace", Name: "web"}, {Namespace: "namespace", Name: "worker"}, {Namespace: "namespace", Name: "cron"}}
+
+	// Big errors fill the budget after a few dozen entries
+	for i := 0; i < 60; i++ {
+		entry := &HistoryEntry{Timestamp: time.Unix(int64(i), 0).UTC(), Actor: "test", ToReplicas: int32(i), Result: ResultFailure, Error: strings.Repeat("x", 20*1024)}
+		if err := store.AppendHistory(ctx, keys[i%len(keys)], entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configMap, err := kClient.CoreV1().ConfigMaps("namespace").Get(ctx, historyConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 0
+	for dataKey, raw := range configMap.Data {
+		size += len(dataKey) + len(raw)
+	}
+	if size > maxConfigMapHistoryBytes {
+		t.Errorf("Fail: got %d bytes of history want at most %d", size, maxConfigMapHistoryBytes)
+	}
+
+	for i, key := range keys {
+		entries, total, err := store.History(ctx, key, 0, maxHistory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case total == 0 || total == 20:
+			t.Errorf("Fail: got %d entries for %s want some trimmed", total, key)
+		case entries[0].ToReplicas != int32(57+i):
+			t.Errorf("Fail: got newest entry to %d for %s want %d", entries[0].ToReplicas, key, 57+i)
+		case entries[total-1].ToReplicas != int32(60-3*total+i):
+			t.Errorf("Fail: got oldest entry to %d for %s, the oldest entries of the namespace should go first", entries[total-1].ToReplicas, key)
+		}
+	}
+}
+
+// Tests the ConfigMap store doesn't write to the API server when nothing changed
+func TestConfigMapStoreNoopWrites(t *testing.T) {
+	kClient := testclient.NewSimpleClientset()
+	store := NewConfigMapStore(kClient)
+	ctx := context.TODO()
+	key := Key{Namespace: "namespace", Name: "deployment"}
+
+	noops := map[string]func() error{
+		"update-nothing": func() error {
+			return store.Update(ctx, key, func(current *Value) (*Value, error) { return nil, nil })
+		},
+		"delete-missing": func() error {
+			return store.Delete(ctx, key)
+		},
+		"schedule-nothing": func() error {
+			return store.UpdateSchedule(ctx, "namespace", "id", func(current *Schedule) (*Schedule, error) { return nil, nil })
+		},
+		"delete-missing-schedule": func() error {
+			return store.DeleteSchedule(ctx, "namespace", "id")
+		},
+	}
+
+	// Once with no ConfigMaps and once with ConfigMaps that don't hold the key
+	for _, setup := range []func() error{
+		func() error { return nil },
+		func() error {
+			if err := store.Set(ctx, Key{Namespace: "namespace", Name: "other"}, &Value{DesiredReplicas: 1}); err != nil {
+				return err
+			}
+			return store.UpdateSchedule(ctx, "namespace", "other", func(current *Schedule) (*Schedule, error) { return &Schedule{ID: "other"}, nil })
+		},
+	} {
+		if err := setup(); err != nil {
+			t.Fatal(err)
+		}
+		for name, noop := range noops {
+			kClient.ClearActions()
+			if err := noop(); err != nil {
+				t.Fatalf("Fail: %s: %s", name, err)
+			}
+			for _, action := range kClient.Actions() {
+				if action.GetVerb() != "get" {
+					t.Errorf("Fail: %s sent a %s to the API server", name, action.GetVerb())
+				}
+			}
+		}
+	}
+}
+
+// Tests concurrent updates in the stores that lock locally don't lose writes
+func TestStateStoreUpdateConcurrent(t *testing.T) {
+	stores := newTestStores(t)
+	for _, name := range []string{BackendMemory, BackendFile} {
+		store := stores[name]
+		t.Run(name, func(t *testing.T) {
+			key := Key{Namespace: "namespace", Name: "deployment"}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.Update(context.TODO(), key, func(current *Value) (*Value, error) {
+						if current == nil {
+							current = &Value{}
+						}
+						return &Value{DesiredReplicas: current.DesiredReplicas + 1}, nil
+					})
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			got, _, err := store.Get(context.TODO(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.DesiredReplicas != 20 {
+				t.Errorf("Fail: got %d want 20, updates were lost", got.DesiredReplicas)
+			}
+		})
+	}
+}
+
+// Tests a deployment and StatefulSet with the same name get their own state in every StateStore
+func TestStateStoreKinds(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			deploymentKey := Key{Kind: KindDeployment, Namespace: "namespace", Name: "deployment"}
+			statefulSetKey := Key{Kind: KindStatefulSet, Namespace: "namespace", Name: "deployment"}
+			deploymentValue := &Value{DesiredReplicas: 2, CurrentReplicas: 2}
+			statefulSetValue := &Value{DesiredReplicas: 5, CurrentReplicas: 1, Drift: true}
+
+			if err := store.Set(ctx, deploymentKey, deploymentValue); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Set(ctx, statefulSetKey, statefulSetValue); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, expected := range map[Key]*Value{deploymentKey: deploymentValue, statefulSetKey: statefulSetValue} {
+				got, _, err := store.Get(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Fail: got %v want %v for %s", got, expected, key)
+				}
+			}
+
+			keys, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 2 {
+				t.Errorf("Fail: got %v want both keys", keys)
+			}
+		})
+	}
+}
+
+// Tests keys survive a round trip through their string form
+func TestParseKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		expected Key
+		ok       bool
+	}{
+		{name: "deployment", key: "namespace/name", expected: Key{Namespace: "namespace", Name: "name"}, ok: true},
+		{name: "statefulset", key: "statefulset:namespace/name", expected: Key{Kind: KindStatefulSet, Namespace: "namespace", Name: "name"}, ok: true},
+		{name: "custom-resource", key: "rollouts.argoproj.io:namespace/name", expected: Key{Kind: "rollouts.argoproj.io", Namespace: "namespace", Name: "name"}, ok: true},
+		{name: "invalid-kind", key: "Roll_outs:namespace/name"},
+		{name: "no-name", key: "namespace/"},
+		{name: "legacy-redis-key", key: "namespace-name"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := ParseKey(test.key)
+			switch {
+			case ok != test.ok:
+				t.Errorf("Fail: got ok %v want %v", ok, test.ok)
+			case got != test.expected:
+				t.Errorf("Fail: got %v want %v", got, test.expected)
+			case ok && got.String() != test.key:
+				t.Errorf("Fail: got %s back want %s", got, test.key)
+			}
+		})
+	}
+}
+
+// Runs the same history checks against every StateStore that doesn't need a server
+func TestStateStoreHistory(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			key := Key{Namespace: "namespace", Name: "deployment"}
+
+			// No history yet
+			entries, total, err := store.History(ctx, key, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 || total != 0 {
+				t.Errorf("Fail: got %v, %d want no history", entries, total)
+			}
+
+			// Append scaling 0->1, 1->2, 2->3
+			for i := int32(0); i < 3; i++ {
+				entry := &HistoryEntry{Timestamp: time.Unix(int64(i), 0).UTC(), Actor: "test", FromReplicas: i, ToReplicas: i + 1, Result: ResultSuccess}
+				if err := store.AppendHistory(ctx, key, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Pages come back newest first
+			entries, total, err = store.History(ctx, key, 1, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case total != 3:
+				t.Errorf("Fail: got total %d want 3", total)
+			case len(entries) != 2:
+				t.Errorf("Fail: got %d entries want 2", len(entries))
+			case entries[0].ToReplicas != 2 || entries[1].ToReplicas != 1:
+				t.Errorf("Fail: got %v want newest first after skipping one", entries)
+			default:
+				t.Logf("test passed %v", entries)
+			}
+
+			// Only the newest entries are kept once the history is full
+			for i := int32(3); i < maxHistory+2; i++ {
+				entry := &HistoryEntry{Timestamp: time.Unix(int64(i), 0).UTC(), Actor: "test", FromReplicas: i, ToReplicas: i + 1, Result: ResultSuccess}
+				if err := store.AppendHistory(ctx, key, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries, total, err = store.History(ctx, key, maxHistory-1, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case total != maxHistory:
+				t.Errorf("Fail: got total %d want %d", total, maxHistory)
+			case len(entries) != 1 || entries[0].ToReplicas != 3:
+				t.Errorf("Fail: got %v want the oldest kept entry scaling to 3", entries)
+			}
+		})
+	}
+}
+
+// Checks every StateStore creates, updates, lists and deletes schedules
+func TestStateStoreSchedules(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			schedule := Schedule{ID: "weekdays", Namespace: "namespace", Deployment: "deployment", Cron: "0 8 * * MON-FRI",
+				Replicas: 5, CreatedBy: "test", CreatedAt: time.Unix(0, 0).UTC()}
+
+			// Nothing is stored yet
+			got, exists, err := store.GetSchedule(ctx, "namespace", "weekdays")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists || got != nil {
+				t.Errorf("Fail: got %v, %v want nil, false", got, exists)
+			}
+
+			// Created schedules come back
+			err = store.UpdateSchedule(ctx, "namespace", "weekdays", func(current *Schedule) (*Schedule, error) {
+				if current != nil {
+					t.Errorf("Fail: got current schedule %v want nil", current)
+				}
+				return &schedule, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, exists, err = store.GetSchedule(ctx, "namespace", "weekdays")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists || !reflect.DeepEqual(*got, schedule) {
+				t.Errorf("Fail: got %v, %v want %v, true", got, exists, schedule)
+			}
+
+			// Updates see what is stored, returning nil keeps it
+			lastRun := time.Unix(60, 0).UTC()
+			err = store.UpdateSchedule(ctx, "namespace", "weekdays", func(current *Schedule) (*Schedule, error) {
+				current.LastRun = &lastRun
+				current.LastResult = ResultSuccess
+				return current, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.UpdateSchedule(ctx, "namespace", "weekdays", func(current *Schedule) (*Schedule, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedule.LastRun = &lastRun
+			schedule.LastResult = ResultSuccess
+			schedules, err := store.ListSchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(schedules, []Schedule{schedule}) {
+				t.Errorf("Fail: got %v want %v", schedules, []Schedule{schedule})
+			}
+
+			// Deleted schedules are gone, deleting them again is fine
+			for i := 0; i < 2; i++ {
+				if err := store.DeleteSchedule(ctx, "namespace", "weekdays"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			schedules, err = store.ListSchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schedules) != 0 {
+				t.Errorf("Fail: got %v after delete want no schedules", schedules)
+			}
+		})
+	}
+}
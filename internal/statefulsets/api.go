@@ -0,0 +1,113 @@
+package statefulsets
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	// internal packages
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+	"github.com/taylorsmcclure/kube-server/internal/responses"
+
+	// k8s api packages
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+)
+
+// JSON response for StatefulSets
+type getStatefulSetsResponse struct {
+	Code         int                    `json:"http_response_code"`
+	StatefulSets []StatefulSetNamespace `json:"statefulsets"`
+}
+
+// Struct for a list of StatefulSets and namespaces
+type StatefulSetNamespace struct {
+	StatefulSet string `json:"statefulset_name"`
+	Namespace   string `json:"namespace"`
+}
+
+// Error type for when there are no StatefulSets found
+type errNoStatefulSets error
+
+// Lists all StatefulSets on the cluster
+func V1StatefulSets(w http.ResponseWriter, r *http.Request, ssLister appslisters.StatefulSetLister) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Allow filtering by namespace statefulsets?namespace=<namespace>
+		namespace := r.URL.Query().Get("namespace")
+		// Clients need read access to at least one StatefulSet in the namespace, or any namespace when not filtering
+		allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceStatefulSets, namespace, "")
+		if err != nil {
+			responses.ReturnError(w, err)
+			return
+		}
+		if !allowed {
+			responses.ReturnError(w, e.Forbidden("%s is not allowed to read statefulsets", auth.FromRequest(r)))
+			return
+		}
+		resp, err := getStatefulSets(ssLister, namespace)
+		if err != nil {
+			switch err.(type) {
+			case errNoStatefulSets:
+				responses.ReturnError(w, e.NotFound("%s", err))
+			default:
+				responses.ReturnError(w, err)
+			}
+			return
+		}
+		// Only list the StatefulSets the client is allowed to read
+		readable := []StatefulSetNamespace{}
+		for _, s := range resp.StatefulSets {
+			allowed, err := auth.Authorized(r, auth.VerbRead, auth.ResourceStatefulSets, s.Namespace, s.StatefulSet)
+			if err != nil {
+				responses.ReturnError(w, err)
+				return
+			}
+			if allowed {
+				readable = append(readable, s)
+			}
+		}
+		resp.StatefulSets = readable
+		responses.ReturnJsonResponse(w, 200, resp)
+	default:
+		responses.ReturnError(w, e.MethodNotAllowed())
+	}
+}
+
+// Gets all StatefulSets on the cluster or filter by namespace from the informer cache
+func getStatefulSets(ssLister appslisters.StatefulSetLister, namespace string) (*getStatefulSetsResponse, error) {
+	var statefulSets []*appsv1.StatefulSet
+	var err error
+	if namespace == "" {
+		statefulSets, err = ssLister.List(labels.Everything())
+	} else {
+		statefulSets, err = ssLister.StatefulSets(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return &getStatefulSetsResponse{}, err
+	}
+
+	// If there are no StatefulSets, return a specific error
+	if len(statefulSets) == 0 {
+		return &getStatefulSetsResponse{}, errNoStatefulSets(errors.New("no statefulsets found"))
+	}
+
+	var available []StatefulSetNamespace
+	for _, s := range statefulSets {
+		available = append(available, StatefulSetNamespace{StatefulSet: s.Name, Namespace: s.Namespace})
+	}
+
+	// The cache has no ordering, sort like the API server does so responses are stable
+	sort.Slice(available, func(i, j int) bool {
+		if available[i].Namespace != available[j].Namespace {
+			return available[i].Namespace < available[j].Namespace
+		}
+		return available[i].StatefulSet < available[j].StatefulSet
+	})
+
+	resp := &getStatefulSetsResponse{Code: 200, StatefulSets: available}
+
+	return resp, nil
+}
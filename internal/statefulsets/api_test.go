@@ -0,0 +1,151 @@
+package statefulsets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/taylorsmcclure/kube-server/internal/auth"
+	"github.com/taylorsmcclure/kube-server/internal/logger"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+)
+
+// I don't like being dependent on the internal package, but
+// this causes a nil pointer exception if it isn't initialized
+func init() {
+	logger.Setup(false)
+}
+
+// Builds a StatefulSet lister backed by a fake clientset and waits for its cache to fill
+func newTestLister(t *testing.T, objects ...runtime.Object) appslisters.StatefulSetLister {
+	fakeClientset := testclient.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(fakeClientset, 0)
+	ssLister := factory.Apps().V1().StatefulSets().Lister()
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+
+	return ssLister
+}
+
+// Helper to build a StatefulSet
+func newTestStatefulSet(namespace string, name string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+// Tests getStatefulSets() with multiple cases
+func TestGetStatefulSets(t *testing.T) {
+	objects := []runtime.Object{
+		newTestStatefulSet("test_dos", "db"),
+		newTestStatefulSet("test", "queue"),
+		newTestStatefulSet("test", "cache"),
+	}
+
+	testCases := []struct {
+		name             string
+		namespace        string
+		expectSuccess    bool
+		expectedResponse getStatefulSetsResponse
+	}{
+		{
+			name:          "filter",
+			namespace:     "test",
+			expectSuccess: true,
+			expectedResponse: getStatefulSetsResponse{Code: 200, StatefulSets: []StatefulSetNamespace{
+				{StatefulSet: "cache", Namespace: "test"},
+				{StatefulSet: "queue", Namespace: "test"},
+			}},
+		},
+		{
+			name:          "no_filter",
+			expectSuccess: true,
+			expectedResponse: getStatefulSetsResponse{Code: 200, StatefulSets: []StatefulSetNamespace{
+				{StatefulSet: "cache", Namespace: "test"},
+				{StatefulSet: "queue", Namespace: "test"},
+				{StatefulSet: "db", Namespace: "test_dos"},
+			}},
+		},
+		{
+			name:             "no_statefulsets",
+			namespace:        "none",
+			expectSuccess:    false,
+			expectedResponse: getStatefulSetsResponse{},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := getStatefulSets(newTestLister(t, objects...), test.namespace)
+			switch {
+			case test.expectSuccess && err != nil:
+				t.Errorf("expected success, got error: %v", err)
+			case !test.expectSuccess && err == nil:
+				t.Errorf("expected error, got success")
+			case !reflect.DeepEqual(resp, &test.expectedResponse):
+				t.Errorf("expected %v, got %v", test.expectedResponse, resp)
+			default:
+				t.Logf("test passed with %v", resp)
+			}
+		})
+	}
+}
+
+// Tests the listing only shows StatefulSets the client is allowed to read
+func TestV1StatefulSetsAuthorization(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{
+		Subjects:     auth.Subjects{CommonNames: []string{"reader"}},
+		Namespaces:   []string{"test"},
+		StatefulSets: []string{"cache"},
+		Verbs:        []string{auth.VerbRead},
+	}}}
+	ssLister := newTestLister(t, newTestStatefulSet("test", "cache"), newTestStatefulSet("test", "queue"))
+
+	testCases := []struct {
+		name         string
+		commonName   string
+		expectedCode int
+		expected     []StatefulSetNamespace
+	}{
+		{name: "filtered", commonName: "reader", expectedCode: 200, expected: []StatefulSetNamespace{{StatefulSet: "cache", Namespace: "test"}}},
+		{name: "unknown-client", commonName: "mallory", expectedCode: 403},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			handler := auth.Middleware(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				V1StatefulSets(w, r, ssLister)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/v1/statefulsets?namespace=test", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.commonName}}}}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, test.expectedCode)
+			}
+			if test.expectedCode != 200 {
+				return
+			}
+			var resp getStatefulSetsResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.StatefulSets, test.expected) {
+				t.Errorf("Fail: got %v want %v", resp.StatefulSets, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	// internal packages
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+)
+
+// Largest request body accepted, the biggest batch is well below it
+const MaxBodySize = 1 << 20
+
+// Decodes the JSON body of a request into v
+// The request has to be sent as application/json, fit in MaxBodySize and hold a single object with only the fields of v
+func DecodeJSON(r *http.Request, v interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return e.Validation("Content-Type header is required, send the body as application/json")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/json" {
+		return e.Validation("Content-Type must be application/json, got %q", contentType)
+	}
+
+	// Read one byte more than allowed to tell a body of exactly the limit from a bigger one
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return e.Validation("couldn't read the request body: %s", err)
+	}
+	if len(body) > MaxBodySize {
+		return e.Validation("request body is larger than %d bytes", MaxBodySize)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return e.Validation("request body is empty, send a JSON object")
+	}
+
+	// Don't allow any other json fields in payload except for what's in v
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return describeJSONError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return e.Validation("request body must hold a single JSON object, found more after it")
+	}
+
+	return nil
+}
+
+// Turns an error from decoding JSON into a message that tells the client what to fix
+func describeJSONError(err error) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		return e.Validation("request body isn't valid JSON at byte %d: %s", syntaxError.Offset, syntaxError)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return e.Validation("request body is cut off, it isn't complete JSON")
+	case errors.As(err, &typeError) && typeError.Field != "":
+		return e.Validation("field %q must be %s, got %s", typeError.Field, jsonType(typeError.Type), typeError.Value)
+	case errors.As(err, &typeError):
+		return e.Validation("request body must be %s, got %s", jsonType(typeError.Type), typeError.Value)
+	// encoding/json has no type for unknown fields
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return e.Validation("unknown field %s in the request body", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		return e.Validation("invalid request body: %s", err)
+	}
+}
+
+// Names the JSON type a Go type is decoded from
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
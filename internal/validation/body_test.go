@@ -0,0 +1,57 @@
+package validation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tests request bodies are only decoded when they are a single JSON object of the right shape
+func TestDecodeJSON(t *testing.T) {
+	testCases := []struct {
+		name          string
+		contentType   string
+		body          string
+		expectedError string
+	}{
+		{name: "valid", contentType: "application/json", body: `{"replica_size": 3}`},
+		{name: "charset", contentType: "application/json; charset=utf-8", body: `{"replica_size": 3}`},
+		{name: "no-content-type", body: `{"replica_size": 3}`, expectedError: "Content-Type header is required"},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: `{"replica_size": 3}`, expectedError: "Content-Type must be application/json"},
+		{name: "empty", contentType: "application/json", expectedError: "request body is empty"},
+		{name: "too-large", contentType: "application/json", body: `{"name": "` + strings.Repeat("a", MaxBodySize) + `"}`, expectedError: "request body is larger than"},
+		{name: "syntax", contentType: "application/json", body: `{"replica_size": 3,}`, expectedError: "request body isn't valid JSON at byte 20"},
+		{name: "truncated", contentType: "application/json", body: `{"replica_size": 3`, expectedError: "request body is cut off"},
+		{name: "wrong-type", contentType: "application/json", body: `{"replica_size": "3"}`, expectedError: `field "replica_size" must be an integer, got string`},
+		{name: "not-an-object", contentType: "application/json", body: `[3]`, expectedError: "request body must be an object, got array"},
+		{name: "unknown-field", contentType: "application/json", body: `{"replicas": 3}`, expectedError: `unknown field "replicas"`},
+		{name: "trailing-data", contentType: "application/json", body: `{"replica_size": 3} {"replica_size": 4}`, expectedError: "request body must hold a single JSON object"},
+		{name: "trailing-brace", contentType: "application/json", body: `{"replica_size": 3}}`, expectedError: "request body must hold a single JSON object"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/replicas/test/test-deployment", strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+
+			var body struct {
+				ReplicaSize int32 `json:"replica_size"`
+			}
+			err := DecodeJSON(req, &body)
+
+			switch {
+			case test.expectedError == "" && err != nil:
+				t.Errorf("Fail: got error %q want none", err)
+			case test.expectedError == "" && body.ReplicaSize != 3:
+				t.Errorf("Fail: got replica_size %d want 3", body.ReplicaSize)
+			case test.expectedError != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expectedError)):
+				t.Errorf("Fail: got error %v want %q", err, test.expectedError)
+			default:
+				t.Logf("test passed %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package validation
+
+import (
+	"strings"
+
+	// internal packages
+	e "github.com/taylorsmcclure/kube-server/internal/errors"
+
+	// Kubernetes packages
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Checks a namespace is an RFC 1123 DNS label, the same rule the API server applies
+func Namespace(namespace string) error {
+	if namespace == "" {
+		return e.Validation("namespace is required")
+	}
+	if problems := validation.IsDNS1123Label(namespace); len(problems) > 0 {
+		return e.Validation("invalid namespace %q: %s", namespace, strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// Checks the name of a deployment, StatefulSet or other workload is an RFC 1123 DNS subdomain, the same rule the API server applies
+// What is the kind of name for the error message, like "deployment_name"
+func Name(what string, name string) error {
+	if name == "" {
+		return e.Validation("%s is required", what)
+	}
+	if problems := validation.IsDNS1123Subdomain(name); len(problems) > 0 {
+		return e.Validation("invalid %s %q: %s", what, name, strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// Checks a namespace and the name of a workload in it
+func NamespacedName(namespace string, what string, name string) error {
+	if err := Namespace(namespace); err != nil {
+		return err
+	}
+
+	return Name(what, name)
+}
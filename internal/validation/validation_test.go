@@ -0,0 +1,41 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests namespaces have to be DNS labels and names DNS subdomains
+func TestNamespacedName(t *testing.T) {
+	testCases := []struct {
+		name          string
+		namespace     string
+		workload      string
+		expectedError string
+	}{
+		{name: "valid", namespace: "team-a", workload: "web-1"},
+		{name: "dotted-name", namespace: "team-a", workload: "web.v2"},
+		{name: "no-namespace", workload: "web", expectedError: "namespace is required"},
+		{name: "no-name", namespace: "team-a", expectedError: "deployment_name is required"},
+		{name: "uppercase-namespace", namespace: "Team-A", workload: "web", expectedError: `invalid namespace "Team-A"`},
+		{name: "dotted-namespace", namespace: "team.a", workload: "web", expectedError: `invalid namespace "team.a"`},
+		{name: "long-namespace", namespace: strings.Repeat("a", 64), workload: "web", expectedError: "invalid namespace"},
+		{name: "underscore-name", namespace: "team-a", workload: "web_1", expectedError: `invalid deployment_name "web_1"`},
+		{name: "long-name", namespace: "team-a", workload: strings.Repeat("a", 254), expectedError: "invalid deployment_name"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := NamespacedName(test.namespace, "deployment_name", test.workload)
+
+			switch {
+			case test.expectedError == "" && err != nil:
+				t.Errorf("Fail: got error %q want none", err)
+			case test.expectedError != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expectedError)):
+				t.Errorf("Fail: got error %v want %q", err, test.expectedError)
+			default:
+				t.Logf("test passed %v", err)
+			}
+		})
+	}
+}
@@ -6,7 +6,7 @@ set -e
 
 echo "Starting server..."
 
-go run cmd/kube-server/main.go --port=${PORT} --verbose \
+go run cmd/kube-server/main.go --port=${PORT} --metrics-port=${METRICS_PORT} --verbose \
 --ca=server-certs/ca.crt \
 --cert=server-certs/server.crt \
 --key=server-certs/server.key \
@@ -6,7 +6,7 @@ set -e
 
 echo "Starting server..."
 
-./kube-server-linux --port=${PORT} \
+./kube-server-linux --port=${PORT} --metrics-port=${METRICS_PORT} --leader-elect \
 --ca=server-certs/ca.crt \
 --cert=server-certs/server.crt \
 --key=server-certs/server.key \
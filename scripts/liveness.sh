@@ -1,11 +1,11 @@
 #!/usr/bin/env bash
 
-## Liveness probe check for kube-server
+## Liveness probe check for kube-server, only checks the process is serving requests
 
 set -e
 
-curl -k \
+curl --fail -k \
      --cacert client-certs/ca.crt \
      --key client-certs/client.key \
      --cert client-certs/client.crt \
-     "https://localhost:8443/v1/healthz"
\ No newline at end of file
+     "https://localhost:8443/v1/livez"
@@ -0,0 +1,11 @@
+#!/usr/bin/env bash
+
+## Readiness probe check for kube-server, fails while the Kubernetes API or the state store is unreachable or the server is shutting down
+
+set -e
+
+curl --fail -k \
+     --cacert client-certs/ca.crt \
+     --key client-certs/client.key \
+     --cert client-certs/client.crt \
+     "https://localhost:8443/v1/readyz"
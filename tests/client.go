@@ -76,6 +76,22 @@ func main() {
 			endpoint:      "/v1/heaalthz",
 			statusCode:    404,
 		},
+		{
+			name:          "livez-get",
+			description:   "Testing livez endpoint",
+			expectSuccess: true,
+			method:        "GET",
+			endpoint:      "/v1/livez",
+			statusCode:    200,
+		},
+		{
+			name:          "readyz-get",
+			description:   "Testing readyz endpoint",
+			expectSuccess: true,
+			method:        "GET",
+			endpoint:      "/v1/readyz",
+			statusCode:    200,
+		},
 		{
 			name:          "deployments-get",
 			description:   "Testing GET on deployments endpoint",